@@ -0,0 +1,128 @@
+package bans
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/criticalstack/quake-kube/internal/quake/bans"
+)
+
+var opts struct {
+	File     string
+	Allow    bool
+	Reason   string
+	Duration time.Duration
+}
+
+func NewCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "bans",
+		Short: "manage the ban and allow lists enforced by the websocket proxy",
+		Long: `Manage the ban and allow lists enforced by the websocket proxy.
+
+A running server reloads the file when it changes, closing the connections of
+any clients that are no longer allowed.`,
+	}
+	cmd.PersistentFlags().StringVarP(&opts.File, "file", "f", "assets/bans.json", "ban and allow lists file")
+	cmd.PersistentFlags().BoolVar(&opts.Allow, "allow", false, "use the allow list instead of the ban list")
+	cmd.AddCommand(
+		newListCommand(),
+		newAddCommand(),
+		newRemoveCommand(),
+	)
+	return cmd
+}
+
+func newListCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:          "list",
+		Short:        "list the bans, or the allow list",
+		SilenceUsage: true,
+		Args:         cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			l, err := bans.Open(opts.File)
+			if err != nil {
+				return err
+			}
+			entries := l.Bans()
+			if opts.Allow {
+				entries = l.Allowed()
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+			fmt.Fprintln(w, "CIDR\tCREATED\tEXPIRES\tREASON")
+			for _, e := range entries {
+				expires := "never"
+				if e.Expires != nil {
+					expires = e.Expires.Format(time.RFC3339)
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", e.CIDR, e.Created.Format(time.RFC3339), expires, e.Reason)
+			}
+			return w.Flush()
+		},
+	}
+}
+
+func newAddCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "add <address or cidr>...",
+		Short:        "ban addresses, or add them to the allow list",
+		SilenceUsage: true,
+		Args:         cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			l, err := bans.Open(opts.File)
+			if err != nil {
+				return err
+			}
+			add := l.Ban
+			if opts.Allow {
+				add = l.Allow
+			}
+			for _, cidr := range args {
+				e, err := add(cidr, opts.Reason, opts.Duration)
+				if err != nil {
+					return err
+				}
+				fmt.Printf("added %s\n", e.CIDR)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&opts.Reason, "reason", "", "reason shown to refused clients")
+	cmd.Flags().DurationVar(&opts.Duration, "duration", 0, "remove the entry after this long (default never)")
+	return cmd
+}
+
+func newRemoveCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:          "remove <address or cidr>...",
+		Short:        "remove bans, or remove addresses from the allow list",
+		SilenceUsage: true,
+		Args:         cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			l, err := bans.Open(opts.File)
+			if err != nil {
+				return err
+			}
+			remove := l.Unban
+			if opts.Allow {
+				remove = l.Disallow
+			}
+			for _, cidr := range args {
+				found, err := remove(cidr)
+				if err != nil {
+					return err
+				}
+				if !found {
+					return errors.Errorf("%s not found", cidr)
+				}
+				fmt.Printf("removed %s\n", cidr)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,60 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/yaml"
+
+	quakeserver "github.com/criticalstack/quake-kube/internal/quake/server"
+)
+
+var opts struct {
+	ConfigFiles    []string
+	ResolveSecrets bool
+}
+
+func NewCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "q3 server configuration",
+	}
+	cmd.AddCommand(newRenderCommand())
+	return cmd
+}
+
+func newRenderCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "render",
+		Short:        "print the merged configuration and the generated server.cfg",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := quakeserver.LoadConfig(opts.ConfigFiles...)
+			if err != nil {
+				return err
+			}
+			if opts.ResolveSecrets {
+				if err := cfg.ResolveSecrets(); err != nil {
+					return err
+				}
+			}
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+			data, err := yaml.Marshal(cfg)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("# config.yaml\n%s\n", data)
+			data, err = cfg.Marshal()
+			if err != nil {
+				return err
+			}
+			fmt.Printf("# server.cfg\n%s", data)
+			return nil
+		},
+	}
+	cmd.Flags().StringSliceVarP(&opts.ConfigFiles, "config", "c", nil, "server configuration files or directories, merged in order")
+	cmd.Flags().BoolVar(&opts.ResolveSecrets, "resolve-secrets", false, "resolve secret references (prints passwords)")
+	return cmd
+}
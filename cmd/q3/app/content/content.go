@@ -1,7 +1,7 @@
 package content
 
 import (
-	"fmt"
+	"context"
 	"net/http"
 	"net/url"
 	"os"
@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/spf13/cobra"
+	"go.uber.org/zap"
 
 	quakecontent "github.com/criticalstack/quake-kube/internal/quake/content"
 )
@@ -17,6 +18,13 @@ var opts struct {
 	Addr           string
 	AssetsDir      string
 	SeedContentURL string
+	IndexFile      string
+	WatchInterval  time.Duration
+	GameDirs       []string
+	MaxUploadSize  int64
+	MaxUnpacked    int64
+	UploadersFile  string
+	AuditFile      string
 }
 
 func NewCommand() *cobra.Command {
@@ -42,13 +50,41 @@ func NewCommand() *cobra.Command {
 				if err != nil {
 					return err
 				}
-				if err := quakecontent.CopyAssets(u, opts.AssetsDir); err != nil {
+				if err := quakecontent.CopyAssets(u, opts.AssetsDir, nil); err != nil {
 					return err
 				}
 			}
 
+			if opts.IndexFile == "" {
+				opts.IndexFile = filepath.Join(opts.AssetsDir, quakecontent.DefaultIndexFile)
+			}
+			index, err := quakecontent.OpenIndex(opts.AssetsDir, opts.IndexFile)
+			if err != nil {
+				return err
+			}
+			go index.Watch(context.Background(), opts.WatchInterval)
+
+			var uploaders []*quakecontent.Uploader
+			if opts.UploadersFile != "" {
+				uploaders, err = quakecontent.LoadUploaders(opts.UploadersFile)
+				if err != nil {
+					return err
+				}
+			} else {
+				zap.L().Warn("uploads are disabled without --uploaders")
+			}
+			if opts.AuditFile == "" {
+				opts.AuditFile = filepath.Join(opts.AssetsDir, quakecontent.DefaultAuditFile)
+			}
+
 			e, err := quakecontent.NewRouter(&quakecontent.Config{
-				AssetsDir: opts.AssetsDir,
+				AssetsDir:       opts.AssetsDir,
+				Index:           index,
+				GameDirs:        opts.GameDirs,
+				MaxUploadSize:   opts.MaxUploadSize,
+				MaxUnpackedSize: opts.MaxUnpacked,
+				Uploaders:       uploaders,
+				AuditFile:       opts.AuditFile,
 			})
 			if err != nil {
 				return err
@@ -60,12 +96,19 @@ func NewCommand() *cobra.Command {
 				WriteTimeout:   600 * time.Second,
 				MaxHeaderBytes: 1 << 20,
 			}
-			fmt.Printf("Starting server %s\n", opts.Addr)
+			zap.L().Info("starting content server", zap.String("addr", opts.Addr))
 			return s.ListenAndServe()
 		},
 	}
 	cmd.Flags().StringVarP(&opts.Addr, "addr", "a", ":9090", "address <host>:<port>")
 	cmd.Flags().StringVarP(&opts.AssetsDir, "assets-dir", "d", "assets", "assets directory")
 	cmd.Flags().StringVar(&opts.SeedContentURL, "seed-content-url", "", "seed content from another content server")
+	cmd.Flags().StringVar(&opts.IndexFile, "index-file", "", "location for the checksums of the assets (default <assets-dir>/"+quakecontent.DefaultIndexFile+")")
+	cmd.Flags().DurationVar(&opts.WatchInterval, "watch-interval", 15*time.Second, "how often to check the assets directory for changes")
+	cmd.Flags().StringSliceVar(&opts.GameDirs, "game-dirs", quakecontent.DefaultGameDirs, "game directories that map packs can be uploaded to")
+	cmd.Flags().Int64Var(&opts.MaxUploadSize, "max-upload-size", quakecontent.DefaultMaxUploadSize, "largest upload in bytes")
+	cmd.Flags().Int64Var(&opts.MaxUnpacked, "max-unpacked-size", quakecontent.DefaultMaxUnpackedSize, "most bytes that an upload may decompress to")
+	cmd.Flags().StringVar(&opts.UploadersFile, "uploaders", "", "file with the tokens, passwords and quotas of the uploaders (uploads are disabled if unset)")
+	cmd.Flags().StringVar(&opts.AuditFile, "audit-file", "", "location for the log of uploads and deletions (default <assets-dir>/"+quakecontent.DefaultAuditFile+")")
 	return cmd
 }
@@ -0,0 +1,60 @@
+package probe
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var opts struct {
+	URL                string
+	Live               bool
+	Timeout            time.Duration
+	InsecureSkipVerify bool
+}
+
+func NewCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "probe",
+		Short:        "check the readiness or liveness of a q3 server, for exec probes",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := "/readyz"
+			if opts.Live {
+				path = "/healthz"
+			}
+			client := &http.Client{
+				Timeout: opts.Timeout,
+				Transport: &http.Transport{
+					TLSClientConfig: &tls.Config{InsecureSkipVerify: opts.InsecureSkipVerify},
+				},
+			}
+			resp, err := client.Get(strings.TrimSuffix(opts.URL, "/") + path)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+
+			body, err := ioutil.ReadAll(resp.Body)
+			if err != nil {
+				return err
+			}
+			fmt.Print(string(body))
+			if resp.StatusCode != http.StatusOK {
+				return errors.Errorf("%s: %s", path, resp.Status)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&opts.URL, "url", "http://127.0.0.1:8080", "url of the client server")
+	cmd.Flags().BoolVar(&opts.Live, "live", false, "check liveness with /healthz instead of readiness with /readyz")
+	cmd.Flags().DurationVar(&opts.Timeout, "timeout", 5*time.Second, "how long to wait for the server")
+	cmd.Flags().BoolVar(&opts.InsecureSkipVerify, "insecure-skip-verify", false, "accept any certificate, when the url is https")
+	return cmd
+}
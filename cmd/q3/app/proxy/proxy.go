@@ -1,11 +1,17 @@
 package proxy
 
 import (
+	"context"
 	"fmt"
+	"net"
 	"net/http"
+	"time"
 
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	"go.uber.org/zap"
 
+	"github.com/criticalstack/quake-kube/internal/quake/bans"
 	quakeclient "github.com/criticalstack/quake-kube/internal/quake/client"
 	netutil "github.com/criticalstack/quake-kube/internal/util/net"
 )
@@ -14,14 +20,37 @@ var opts struct {
 	ClientAddr    string
 	ServerAddr    string
 	ContentServer string
+	BansFile      string
+	Reverse       bool
+	MaxClients    int
+
+	Backends     []string
+	BackendsFile string
+
+	TrustedProxies []string
+	ProxyProtocol  bool
+	Limits         quakeclient.Limits
+	ResumeGrace    time.Duration
+
+	WebRTC          bool
+	WebRTCPublicIPs []string
+	WebRTCPortRange string
 }
 
 func NewCommand() *cobra.Command {
 	cmd := &cobra.Command{
-		Use:          "proxy",
-		Short:        "q3 websocket/udp proxy",
+		Use:   "proxy",
+		Short: "q3 websocket/udp proxy",
+		Long: `Proxies websocket (and WebRTC) clients to a UDP dedicated server.
+
+With --reverse, native clients connecting over UDP to --client-addr are
+proxied to the websocket server at --server-addr instead, which is either
+<host>:<port> or a ws:// or wss:// URL.`,
 		SilenceUsage: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.Reverse {
+				return runReverse()
+			}
 			if opts.ClientAddr == "" {
 				hostIPv4, err := netutil.DetectHostIPv4()
 				if err != nil {
@@ -29,18 +58,99 @@ func NewCommand() *cobra.Command {
 				}
 				opts.ClientAddr = fmt.Sprintf("%s:8080", hostIPv4)
 			}
+			backends, err := quakeclient.ParseBackendFlags(opts.Backends, opts.BackendsFile)
+			if err != nil {
+				return err
+			}
+			if opts.ServerAddr == "" && backends == nil {
+				return errors.New("either --server-addr or --backend/--backends-file must be set")
+			}
 			p, err := quakeclient.NewProxy(opts.ServerAddr)
 			if err != nil {
 				return err
 			}
+			defer p.Close()
+			if backends != nil {
+				p.Backends = backends
+				go backends.Watch(context.Background(), 15*time.Second)
+			}
+			if opts.BansFile != "" {
+				bl, err := bans.Open(opts.BansFile)
+				if err != nil {
+					return err
+				}
+				p.Bans = bl
+				bl.OnChange(p.CloseBanned)
+				go bl.Watch(context.Background(), 15*time.Second)
+			}
+			trusted, err := netutil.ParseCIDRs(opts.TrustedProxies)
+			if err != nil {
+				return err
+			}
+			p.ClientIP = quakeclient.NewIPExtractor(trusted)
+			p.Limits = opts.Limits
+			p.ResumeGrace = opts.ResumeGrace
+			rtc, err := quakeclient.ParseWebRTCFlags(opts.WebRTC, opts.WebRTCPublicIPs, opts.WebRTCPortRange)
+			if err != nil {
+				return err
+			}
+			if rtc != nil {
+				if err := p.EnableWebRTC(*rtc); err != nil {
+					return err
+				}
+			}
+
+			l, err := net.Listen("tcp", opts.ClientAddr)
+			if err != nil {
+				return err
+			}
+			if opts.ProxyProtocol {
+				l = &netutil.ProxyProtocolListener{
+					Listener: l,
+					Trusted: func(ip net.IP) bool {
+						return netutil.ContainsIP(trusted, ip)
+					},
+				}
+			}
 			s := http.Server{
 				Addr:    opts.ClientAddr,
 				Handler: p,
 			}
-			return s.ListenAndServe()
+			zap.L().Info("starting proxy", zap.String("addr", opts.ClientAddr), zap.String("server", opts.ServerAddr))
+			return s.Serve(l)
 		},
 	}
 	cmd.Flags().StringVarP(&opts.ClientAddr, "client-addr", "c", "", "client address <host>:<port>")
 	cmd.Flags().StringVarP(&opts.ServerAddr, "server-addr", "s", "", "dedicated server <host>:<port>")
+	cmd.Flags().BoolVar(&opts.Reverse, "reverse", false, "listen for native clients on UDP, and connect them to a websocket server")
+	cmd.Flags().IntVar(&opts.MaxClients, "max-clients", quakeclient.DefaultMaxReverseClients, "native clients connected at a time with --reverse")
+	cmd.Flags().StringArrayVar(&opts.Backends, "backend", nil, "dedicated server clients can be routed to <name>=<host>:<port>[,<hostname>...], may be repeated")
+	cmd.Flags().StringVar(&opts.BackendsFile, "backends-file", "", "YAML file of the dedicated servers clients can be routed to, reloaded when changed")
+	cmd.Flags().StringVar(&opts.BansFile, "bans-file", "", "ban and allow lists to enforce")
+	cmd.Flags().StringSliceVar(&opts.TrustedProxies, "trusted-proxies", nil, "addresses or CIDR ranges of load balancers trusted to set X-Forwarded-For")
+	cmd.Flags().BoolVar(&opts.ProxyProtocol, "proxy-protocol", false, "read the PROXY protocol header from trusted proxies")
+	cmd.Flags().IntVar(&opts.Limits.SessionsPerIP, "max-sessions-per-ip", quakeclient.DefaultLimits.SessionsPerIP, "concurrent websocket sessions allowed from an address (0 is unlimited)")
+	cmd.Flags().IntVar(&opts.Limits.ConnectionsPerMinute, "max-connections-per-minute", quakeclient.DefaultLimits.ConnectionsPerMinute, "new websocket sessions allowed from an address per minute (0 is unlimited)")
+	cmd.Flags().IntVar(&opts.Limits.PacketsPerSecond, "max-packets-per-second", quakeclient.DefaultLimits.PacketsPerSecond, "packets per second allowed from a websocket session (0 is unlimited)")
+	cmd.Flags().IntVar(&opts.Limits.BytesPerSecond, "max-bytes-per-second", quakeclient.DefaultLimits.BytesPerSecond, "bytes per second allowed from a websocket session (0 is unlimited)")
+	cmd.Flags().DurationVar(&opts.ResumeGrace, "resume-grace", 10*time.Second, "how long to keep the session of a client after its connection drops, waiting for it to reconnect (0 disables)")
+	cmd.Flags().BoolVar(&opts.WebRTC, "webrtc", false, "allow clients to connect with a WebRTC data channel")
+	cmd.Flags().StringSliceVar(&opts.WebRTCPublicIPs, "webrtc-public-ips", nil, "addresses advertised to WebRTC clients, when behind NAT")
+	cmd.Flags().StringVar(&opts.WebRTCPortRange, "webrtc-port-range", "", "UDP ports used by WebRTC clients <min>-<max> (default any ephemeral port)")
 	return cmd
 }
+
+// runReverse lets native clients join the websocket server at --server-addr,
+// e.g. with `connect 127.0.0.1` in ioquake3.
+func runReverse() error {
+	if opts.ClientAddr == "" {
+		opts.ClientAddr = "127.0.0.1:27960"
+	}
+	p, err := quakeclient.NewReverseProxy(opts.ServerAddr)
+	if err != nil {
+		return err
+	}
+	p.MaxClients = opts.MaxClients
+	zap.L().Info("forwarding native clients", zap.String("addr", opts.ClientAddr), zap.String("url", p.URL))
+	return p.ListenAndServe(opts.ClientAddr)
+}
@@ -2,16 +2,23 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net/url"
+	"path/filepath"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	"go.uber.org/zap"
 
+	"github.com/criticalstack/quake-kube/internal/quake/bans"
 	quakeclient "github.com/criticalstack/quake-kube/internal/quake/client"
 	"github.com/criticalstack/quake-kube/internal/quake/content"
+	quakenet "github.com/criticalstack/quake-kube/internal/quake/net"
 	quakeserver "github.com/criticalstack/quake-kube/internal/quake/server"
+	"github.com/criticalstack/quake-kube/internal/quake/stats"
+	netutil "github.com/criticalstack/quake-kube/internal/util/net"
 	httputil "github.com/criticalstack/quake-kube/internal/util/net/http"
 	"github.com/criticalstack/quake-kube/public"
 )
@@ -22,8 +29,34 @@ var opts struct {
 	ContentServer string
 	AcceptEula    bool
 	AssetsDir     string
-	ConfigFile    string
+	ConfigFiles   []string
 	WatchInterval time.Duration
+	StatsDir      string
+	BansFile      string
+
+	Backends     []string
+	BackendsFile string
+
+	AllowDefaultPassword bool
+
+	TrustedProxies []string
+	ProxyProtocol  bool
+	Limits         quakeclient.Limits
+	ResumeGrace    time.Duration
+
+	WebRTC          bool
+	WebRTCPublicIPs []string
+	WebRTCPortRange string
+
+	AdminToken    string
+	AdminUsername string
+	AdminPassword string
+
+	TLSCert           string
+	TLSKey            string
+	TLSClientCA       string
+	HTTPSRedirectAddr string
+	HTTPSPort         string
 }
 
 func NewCommand() *cobra.Command {
@@ -39,54 +72,246 @@ func NewCommand() *cobra.Command {
 			if err != nil {
 				return err
 			}
+			rtc, err := quakeclient.ParseWebRTCFlags(opts.WebRTC, opts.WebRTCPublicIPs, opts.WebRTCPortRange)
+			if err != nil {
+				return err
+			}
+			backends, err := quakeclient.ParseBackendFlags(opts.Backends, opts.BackendsFile)
+			if err != nil {
+				return err
+			}
+			if backends != nil {
+				go backends.Watch(ctx, opts.WatchInterval)
+			}
+			tlsConfig, err := loadTLSConfig(ctx)
+			if err != nil {
+				return err
+			}
 			if !opts.AcceptEula {
 				fmt.Println(quakeserver.Q3DemoEULA)
 				return errors.New("You must agree to the EULA to continue")
 			}
-			if err := httputil.GetUntil(opts.ContentServer, ctx.Done()); err != nil {
+			if opts.StatsDir == "" {
+				opts.StatsDir = filepath.Join(opts.AssetsDir, "stats")
+			}
+			store, err := stats.Open(opts.StatsDir)
+			if err != nil {
 				return err
 			}
+			defer store.Close()
 
-			// TODO(chrism): only download what is in map config
-			if err := content.CopyAssets(csurl, opts.AssetsDir); err != nil {
+			if opts.BansFile == "" {
+				opts.BansFile = filepath.Join(opts.AssetsDir, "bans.json")
+			}
+			bl, err := bans.Open(opts.BansFile)
+			if err != nil {
 				return err
 			}
+			go bl.Watch(ctx, opts.WatchInterval)
 
-			go func() {
-				s := quakeserver.Server{
-					Dir:           opts.AssetsDir,
-					WatchInterval: opts.WatchInterval,
-					ConfigFile:    opts.ConfigFile,
-					Addr:          opts.ServerAddr,
-				}
-				if err := s.Start(ctx); err != nil {
-					panic(err)
-				}
-			}()
+			qs := &quakeserver.Server{
+				Dir:           opts.AssetsDir,
+				WatchInterval: opts.WatchInterval,
+				ConfigFiles:   opts.ConfigFiles,
+				Addr:          opts.ServerAddr,
+				Stats:         store,
+
+				AllowDefaultPassword: opts.AllowDefaultPassword,
+			}
+
+			trusted, err := netutil.ParseCIDRs(opts.TrustedProxies)
+			if err != nil {
+				return err
+			}
+			sessions := &quakeclient.Sessions{}
+			go sessions.Track(ctx, qs, 5*time.Second)
+
+			s := &quakeclient.Server{
+				Addr:       opts.ClientAddr,
+				ServerAddr: opts.ServerAddr,
+				Bans:       bl,
+				Sessions:   sessions,
+
+				TrustedProxies: trusted,
+				ProxyProtocol:  opts.ProxyProtocol,
+				Limits:         opts.Limits,
+				ResumeGrace:    opts.ResumeGrace,
+				WebRTC:         rtc,
+				Backends:       backends,
+				TLSConfig:      tlsConfig,
+				RedirectAddr:   opts.HTTPSRedirectAddr,
+				HTTPSPort:      opts.HTTPSPort,
+			}
+			progress := &content.Progress{}
+			health := &quakeclient.Health{}
+			health.Add("content", checkContent(progress))
+			health.Add("ioq3ded", checkProcess(qs))
+			health.Add("getinfo", checkGetInfo(opts.ServerAddr))
+			health.Add("listener", s.CheckListener)
 
 			e, err := quakeclient.NewRouter(&quakeclient.Config{
 				ContentServerURL: opts.ContentServer,
 				ServerAddr:       opts.ServerAddr,
 				Files:            public.Files,
+				Stats:            store,
+				Bans:             bl,
+				Sessions:         sessions,
+				TrustedProxies:   trusted,
+				Health:           health,
+				Admin: &quakeclient.AdminConfig{
+					Token:       opts.AdminToken,
+					Username:    opts.AdminUsername,
+					Password:    opts.AdminPassword,
+					ClientCerts: opts.TLSClientCA != "",
+					Console:     qs,
+				},
 			})
 			if err != nil {
 				return err
 			}
-			s := &quakeclient.Server{
-				Addr:       opts.ClientAddr,
-				Handler:    e,
-				ServerAddr: opts.ServerAddr,
-			}
-			fmt.Printf("Starting server %s\n", opts.ClientAddr)
-			return s.ListenAndServe()
+			s.Handler = e
+
+			// the client server is started while assets are downloading, so
+			// that the progress is reported by /readyz
+			errc := make(chan error, 2)
+			go func() {
+				if err := httputil.GetUntil(opts.ContentServer, ctx.Done()); err != nil {
+					errc <- err
+					return
+				}
+
+				// TODO(chrism): only download what is in map config
+				if err := content.CopyAssets(csurl, opts.AssetsDir, progress); err != nil {
+					errc <- err
+					return
+				}
+				if err := qs.Start(ctx); err != nil {
+					errc <- err
+				}
+			}()
+			zap.L().Info("starting server", zap.String("addr", opts.ClientAddr))
+			go func() {
+				errc <- s.ListenAndServe()
+			}()
+			return <-errc
 		},
 	}
-	cmd.Flags().StringVarP(&opts.ConfigFile, "config", "c", "", "server configuration file")
+	cmd.Flags().StringSliceVarP(&opts.ConfigFiles, "config", "c", nil, "server configuration files or directories, merged in order")
 	cmd.Flags().StringVar(&opts.ContentServer, "content-server", "http://content.quakejs.com", "content server url")
 	cmd.Flags().BoolVar(&opts.AcceptEula, "agree-eula", false, "agree to the Quake 3 demo EULA")
 	cmd.Flags().StringVar(&opts.AssetsDir, "assets-dir", "assets", "location for game files")
 	cmd.Flags().StringVar(&opts.ClientAddr, "client-addr", "0.0.0.0:8080", "client address <host>:<port>")
 	cmd.Flags().StringVar(&opts.ServerAddr, "server-addr", "0.0.0.0:27960", "dedicated server <host>:<port>")
+	cmd.Flags().BoolVar(&opts.AllowDefaultPassword, "allow-default-password", false, "allow starting with the default rcon password")
+	cmd.Flags().StringVar(&opts.StatsDir, "stats-dir", "", "location for the match history (default <assets-dir>/stats)")
+	cmd.Flags().StringVar(&opts.BansFile, "bans-file", "", "location for the ban and allow lists (default <assets-dir>/bans.json)")
+	cmd.Flags().StringArrayVar(&opts.Backends, "backend", nil, "other dedicated server clients can be routed to <name>=<host>:<port>[,<hostname>...], may be repeated")
+	cmd.Flags().StringVar(&opts.BackendsFile, "backends-file", "", "YAML file of the other dedicated servers clients can be routed to, reloaded when changed")
+	cmd.Flags().StringSliceVar(&opts.TrustedProxies, "trusted-proxies", nil, "addresses or CIDR ranges of load balancers trusted to set X-Forwarded-For")
+	cmd.Flags().BoolVar(&opts.ProxyProtocol, "proxy-protocol", false, "read the PROXY protocol header from trusted proxies")
+	cmd.Flags().IntVar(&opts.Limits.SessionsPerIP, "max-sessions-per-ip", quakeclient.DefaultLimits.SessionsPerIP, "concurrent websocket sessions allowed from an address (0 is unlimited)")
+	cmd.Flags().IntVar(&opts.Limits.ConnectionsPerMinute, "max-connections-per-minute", quakeclient.DefaultLimits.ConnectionsPerMinute, "new websocket sessions allowed from an address per minute (0 is unlimited)")
+	cmd.Flags().IntVar(&opts.Limits.PacketsPerSecond, "max-packets-per-second", quakeclient.DefaultLimits.PacketsPerSecond, "packets per second allowed from a websocket session (0 is unlimited)")
+	cmd.Flags().IntVar(&opts.Limits.BytesPerSecond, "max-bytes-per-second", quakeclient.DefaultLimits.BytesPerSecond, "bytes per second allowed from a websocket session (0 is unlimited)")
+	cmd.Flags().DurationVar(&opts.ResumeGrace, "resume-grace", 10*time.Second, "how long to keep the session of a client after its connection drops, waiting for it to reconnect (0 disables)")
+	cmd.Flags().BoolVar(&opts.WebRTC, "webrtc", false, "allow clients to connect with a WebRTC data channel")
+	cmd.Flags().StringSliceVar(&opts.WebRTCPublicIPs, "webrtc-public-ips", nil, "addresses advertised to WebRTC clients, when behind NAT")
+	cmd.Flags().StringVar(&opts.WebRTCPortRange, "webrtc-port-range", "", "UDP ports used by WebRTC clients <min>-<max> (default any ephemeral port)")
+	cmd.Flags().StringVar(&opts.AdminToken, "admin-token", "", "bearer token for the admin API")
+	cmd.Flags().StringVar(&opts.AdminUsername, "admin-username", "admin", "basic auth username for the admin API")
+	cmd.Flags().StringVar(&opts.AdminPassword, "admin-password", "", "basic auth password for the admin API")
+	cmd.Flags().StringVar(&opts.TLSCert, "tls-cert", "", "certificate file for serving HTTPS and WSS, reloaded when changed")
+	cmd.Flags().StringVar(&opts.TLSKey, "tls-key", "", "key file for --tls-cert")
+	cmd.Flags().StringVar(&opts.TLSClientCA, "tls-client-ca", "", "CA certificates that sign client certificates accepted for the admin API")
+	cmd.Flags().StringVar(&opts.HTTPSRedirectAddr, "https-redirect-addr", "", "address that redirects plain HTTP to HTTPS <host>:<port>")
+	cmd.Flags().StringVar(&opts.HTTPSPort, "https-port", "", "port in redirects to HTTPS (default the port of --client-addr)")
 	cmd.Flags().DurationVar(&opts.WatchInterval, "watch-interval", 15*time.Second, "dedicated server <host>:<port>")
 	return cmd
 }
+
+// loadTLSConfig returns the TLS config given by the flags, which is nil when
+// serving plain HTTP. The certificate is reloaded when it changes, until the
+// context is done.
+func loadTLSConfig(ctx context.Context) (*tls.Config, error) {
+	if opts.TLSCert == "" && opts.TLSKey == "" {
+		if opts.TLSClientCA != "" || opts.HTTPSRedirectAddr != "" {
+			return nil, errors.New("--tls-client-ca and --https-redirect-addr require --tls-cert and --tls-key")
+		}
+		return nil, nil
+	}
+	if opts.TLSCert == "" || opts.TLSKey == "" {
+		return nil, errors.New("--tls-cert and --tls-key must be set together")
+	}
+	cert, err := netutil.LoadCertificate(opts.TLSCert, opts.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+	go cert.Watch(ctx, opts.WatchInterval)
+	cfg := &tls.Config{
+		GetCertificate: cert.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+	if opts.TLSClientCA != "" {
+		pool, err := netutil.LoadCertPool(opts.TLSClientCA)
+		if err != nil {
+			return nil, err
+		}
+		// game clients do not have certificates, they are only required
+		// for the admin API
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg, nil
+}
+
+// checkContent waits for the assets to be downloaded.
+func checkContent(progress *content.Progress) quakeclient.HealthCheck {
+	return func(ctx context.Context) quakeclient.CheckResult {
+		done, total := progress.Files()
+		finished, err := progress.Finished()
+		switch {
+		case err != nil:
+			return quakeclient.CheckResult{Status: quakeclient.StatusFailed, Detail: err.Error()}
+		case !finished && total == 0:
+			return quakeclient.CheckResult{Status: quakeclient.StatusWaiting, Detail: "waiting for the content server"}
+		case !finished:
+			return quakeclient.CheckResult{Status: quakeclient.StatusWaiting, Detail: fmt.Sprintf("downloaded %d/%d files", done, total)}
+		}
+		return quakeclient.CheckResult{Status: quakeclient.StatusOK, Detail: fmt.Sprintf("downloaded %d files", total)}
+	}
+}
+
+// checkProcess fails when ioq3ded has exited, as it is not restarted until the
+// config changes.
+func checkProcess(qs *quakeserver.Server) quakeclient.HealthCheck {
+	return func(ctx context.Context) quakeclient.CheckResult {
+		p := qs.Process()
+		switch {
+		case p.Pid == 0:
+			return quakeclient.CheckResult{Status: quakeclient.StatusWaiting, Detail: "not started"}
+		case !p.Running && p.Err != nil:
+			return quakeclient.CheckResult{Status: quakeclient.StatusFailed, Detail: fmt.Sprintf("pid %d exited: %v", p.Pid, p.Err)}
+		case !p.Running:
+			return quakeclient.CheckResult{Status: quakeclient.StatusFailed, Detail: fmt.Sprintf("pid %d exited", p.Pid)}
+		}
+		return quakeclient.CheckResult{
+			Status: quakeclient.StatusOK,
+			Detail: fmt.Sprintf("pid %d running for %s", p.Pid, time.Since(p.Started).Round(time.Second)),
+		}
+	}
+}
+
+// checkGetInfo waits for the dedicated server to answer getinfo, which it does
+// not while loading a map.
+func checkGetInfo(addr string) quakeclient.HealthCheck {
+	return func(ctx context.Context) quakeclient.CheckResult {
+		info, err := quakenet.GetInfoContext(ctx, addr)
+		if err != nil {
+			return quakeclient.CheckResult{Status: quakeclient.StatusWaiting, Detail: err.Error()}
+		}
+		return quakeclient.CheckResult{
+			Status: quakeclient.StatusOK,
+			Detail: fmt.Sprintf("%s %s/%s players", info["mapname"], info["clients"], info["sv_maxclients"]),
+		}
+	}
+}
@@ -1,35 +1,62 @@
 package main
 
 import (
-	"log"
+	"fmt"
+	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
+	"go.uber.org/zap"
 
+	q3bans "github.com/criticalstack/quake-kube/cmd/q3/app/bans"
 	q3cmd "github.com/criticalstack/quake-kube/cmd/q3/app/cmd"
+	q3config "github.com/criticalstack/quake-kube/cmd/q3/app/config"
 	q3content "github.com/criticalstack/quake-kube/cmd/q3/app/content"
+	q3probe "github.com/criticalstack/quake-kube/cmd/q3/app/probe"
 	q3proxy "github.com/criticalstack/quake-kube/cmd/q3/app/proxy"
 	q3server "github.com/criticalstack/quake-kube/cmd/q3/app/server"
+	logutil "github.com/criticalstack/quake-kube/internal/util/log"
 )
 
 var global struct {
 	Verbosity int
+	LogFormat string
 }
 
 func main() {
 	cmd := &cobra.Command{
 		Use:   "q3",
 		Short: "",
+		// errors are written by main, after the logger is flushed
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			log, err := logutil.New(os.Stderr, global.LogFormat, global.Verbosity)
+			if err != nil {
+				return err
+			}
+			zap.ReplaceGlobals(log)
+			// libraries using the standard logger, such as net/http
+			zap.RedirectStdLog(log.Named("stdlog"))
+			return nil
+		},
 	}
 	cmd.AddCommand(
+		q3bans.NewCommand(),
 		q3cmd.NewCommand(),
+		q3config.NewCommand(),
 		q3content.NewCommand(),
+		q3probe.NewCommand(),
 		q3proxy.NewCommand(),
 		q3server.NewCommand(),
 	)
 
-	cmd.PersistentFlags().CountVarP(&global.Verbosity, "verbose", "v", "log output verbosity")
+	cmd.PersistentFlags().CountVarP(&global.Verbosity, "verbose", "v", "log output verbosity, debug records are written with -v")
+	cmd.PersistentFlags().StringVar(&global.LogFormat, "log-format", "text", "log output format ("+strings.Join(logutil.Formats, ", ")+")")
 
-	if err := cmd.Execute(); err != nil {
-		log.Fatal(err)
+	err := cmd.Execute()
+	zap.L().Sync()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
 	}
 }
@@ -7,11 +7,14 @@ require (
 	github.com/google/go-cmp v0.3.0
 	github.com/gorilla/websocket v1.4.0
 	github.com/labstack/echo/v4 v4.1.16
+	github.com/pion/datachannel v1.4.21
+	github.com/pion/webrtc/v2 v2.2.26
 	github.com/pkg/errors v0.9.1
 	github.com/prometheus/client_golang v0.9.3
 	github.com/shurcooL/httpfs v0.0.0-20190707220628-8d4bc4ba7749 // indirect
 	github.com/shurcooL/vfsgen v0.0.0-20200627165143-92b8a710ab6c // indirect
 	github.com/spf13/cobra v1.0.0
+	go.uber.org/zap v1.15.0
 	k8s.io/apimachinery v0.18.6
 	sigs.k8s.io/yaml v1.2.0
 )
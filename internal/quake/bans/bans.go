@@ -0,0 +1,364 @@
+// Package bans implements the ban and allow lists enforced by the websocket
+// proxy. Every browser client reaches the dedicated server from the address of
+// the proxy, so the proxy is the only place where the real client addresses
+// are known.
+package bans
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	netutil "github.com/criticalstack/quake-kube/internal/util/net"
+)
+
+// ErrNotAllowed is returned by Check for addresses that are not in a
+// non-empty allow list.
+var ErrNotAllowed = errors.New("address is not in the allow list")
+
+// BannedError is returned by Check for banned addresses.
+type BannedError struct {
+	Entry Entry
+}
+
+func (e *BannedError) Error() string {
+	if e.Entry.Reason == "" {
+		return "banned"
+	}
+	return fmt.Sprintf("banned: %s", e.Entry.Reason)
+}
+
+// Entry is a range of addresses in a ban or allow list.
+type Entry struct {
+	CIDR    string    `json:"cidr"`
+	Reason  string    `json:"reason,omitempty"`
+	Created time.Time `json:"created"`
+
+	// Expires is when the entry is removed, entries without an expiry are
+	// kept until they are removed.
+	Expires *time.Time `json:"expires,omitempty"`
+
+	network *net.IPNet
+}
+
+func (e *Entry) expired(now time.Time) bool {
+	return e.Expires != nil && !now.Before(*e.Expires)
+}
+
+// ParseCIDR parses an address range in CIDR notation. A single address is
+// treated as a range containing only that address.
+func ParseCIDR(s string) (*net.IPNet, error) {
+	return netutil.ParseCIDR(s)
+}
+
+type listFile struct {
+	Bans  []*Entry `json:"bans"`
+	Allow []*Entry `json:"allow"`
+}
+
+// List is a ban list and an allow list persisted to a JSON file. Bans take
+// precedence over the allow list, and when the allow list is empty every
+// address that is not banned is allowed.
+type List struct {
+	path string
+
+	mu        sync.RWMutex
+	bans      []*Entry
+	allow     []*Entry
+	modTime   time.Time
+	callbacks []func()
+}
+
+// Open loads the lists from the file at path, which is created when the lists
+// are first changed. The lists are only kept in memory when path is empty.
+func Open(path string) (*List, error) {
+	l := &List{path: path}
+	if _, err := l.refresh(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// refresh reloads the file when it has been modified since it was last read,
+// returning whether it was reloaded. The caller must hold the write lock,
+// except when opening.
+func (l *List) refresh() (bool, error) {
+	if l.path == "" {
+		return false, nil
+	}
+	fi, err := os.Stat(l.path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if fi.ModTime().Equal(l.modTime) {
+		return false, nil
+	}
+	data, err := ioutil.ReadFile(l.path)
+	if err != nil {
+		return false, err
+	}
+	var f listFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return false, errors.Wrapf(err, "cannot read %s", l.path)
+	}
+	for _, list := range [][]*Entry{f.Bans, f.Allow} {
+		for _, e := range list {
+			if e.network, err = ParseCIDR(e.CIDR); err != nil {
+				return false, errors.Wrapf(err, "cannot read %s", l.path)
+			}
+		}
+	}
+	l.bans, l.allow, l.modTime = f.Bans, f.Allow, fi.ModTime()
+	return true, nil
+}
+
+// save writes the lists to the file, dropping expired entries. The caller
+// must hold the write lock.
+func (l *List) save() error {
+	now := time.Now()
+	l.bans = unexpired(l.bans, now)
+	l.allow = unexpired(l.allow, now)
+	if l.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(listFile{Bans: l.bans, Allow: l.allow}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(l.path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(l.path+".tmp", l.path); err != nil {
+		return err
+	}
+	fi, err := os.Stat(l.path)
+	if err != nil {
+		return err
+	}
+	l.modTime = fi.ModTime()
+	return nil
+}
+
+func unexpired(entries []*Entry, now time.Time) []*Entry {
+	result := make([]*Entry, 0, len(entries))
+	for _, e := range entries {
+		if !e.expired(now) {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// OnChange registers fn to be called after the lists change, either from
+// this List or when the file is reloaded by Watch.
+func (l *List) OnChange(fn func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.callbacks = append(l.callbacks, fn)
+}
+
+func (l *List) changed() {
+	l.mu.RLock()
+	callbacks := append([]func(){}, l.callbacks...)
+	l.mu.RUnlock()
+	for _, fn := range callbacks {
+		fn()
+	}
+}
+
+// update applies fn to the lists, after reloading any changes made to the
+// file by another process, and saves the result.
+func (l *List) update(fn func() error) error {
+	l.mu.Lock()
+	err := func() error {
+		if _, err := l.refresh(); err != nil {
+			return err
+		}
+		if err := fn(); err != nil {
+			return err
+		}
+		return l.save()
+	}()
+	l.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	l.changed()
+	return nil
+}
+
+func add(entries []*Entry, cidr, reason string, ttl time.Duration) ([]*Entry, *Entry, error) {
+	network, err := ParseCIDR(cidr)
+	if err != nil {
+		return entries, nil, err
+	}
+	e := &Entry{
+		CIDR:    network.String(),
+		Reason:  reason,
+		Created: time.Now().UTC(),
+		network: network,
+	}
+	if ttl > 0 {
+		expires := e.Created.Add(ttl)
+		e.Expires = &expires
+	}
+	for i, existing := range entries {
+		if existing.CIDR == e.CIDR {
+			entries[i] = e
+			return entries, e, nil
+		}
+	}
+	return append(entries, e), e, nil
+}
+
+func remove(entries []*Entry, cidr string) ([]*Entry, bool, error) {
+	network, err := ParseCIDR(cidr)
+	if err != nil {
+		return entries, false, err
+	}
+	for i, e := range entries {
+		if e.CIDR == network.String() {
+			return append(entries[:i], entries[i+1:]...), true, nil
+		}
+	}
+	return entries, false, nil
+}
+
+// Ban adds an address range to the ban list, replacing any existing ban for
+// the same range. The ban never expires when ttl is zero.
+func (l *List) Ban(cidr, reason string, ttl time.Duration) (entry Entry, err error) {
+	err = l.update(func() error {
+		var e *Entry
+		l.bans, e, err = add(l.bans, cidr, reason, ttl)
+		if e != nil {
+			entry = *e
+		}
+		return err
+	})
+	return entry, err
+}
+
+// Unban removes an address range from the ban list, returning whether it was
+// found.
+func (l *List) Unban(cidr string) (found bool, err error) {
+	err = l.update(func() error {
+		l.bans, found, err = remove(l.bans, cidr)
+		return err
+	})
+	return found, err
+}
+
+// Allow adds an address range to the allow list. Once the allow list has any
+// entries, only addresses in the list are allowed.
+func (l *List) Allow(cidr, reason string, ttl time.Duration) (entry Entry, err error) {
+	err = l.update(func() error {
+		var e *Entry
+		l.allow, e, err = add(l.allow, cidr, reason, ttl)
+		if e != nil {
+			entry = *e
+		}
+		return err
+	})
+	return entry, err
+}
+
+// Disallow removes an address range from the allow list, returning whether it
+// was found.
+func (l *List) Disallow(cidr string) (found bool, err error) {
+	err = l.update(func() error {
+		l.allow, found, err = remove(l.allow, cidr)
+		return err
+	})
+	return found, err
+}
+
+func copyEntries(entries []*Entry, now time.Time) []Entry {
+	result := make([]Entry, 0, len(entries))
+	for _, e := range entries {
+		if !e.expired(now) {
+			result = append(result, *e)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Created.Before(result[j].Created)
+	})
+	return result
+}
+
+// Bans returns the current bans, oldest first.
+func (l *List) Bans() []Entry {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return copyEntries(l.bans, time.Now())
+}
+
+// Allowed returns the current allow list, oldest first.
+func (l *List) Allowed() []Entry {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return copyEntries(l.allow, time.Now())
+}
+
+// Check returns a *BannedError when the address is banned, or ErrNotAllowed
+// when it is not in a non-empty allow list.
+func (l *List) Check(ip net.IP) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	now := time.Now()
+	for _, e := range l.bans {
+		if !e.expired(now) && e.network.Contains(ip) {
+			return &BannedError{Entry: *e}
+		}
+	}
+	allow := unexpired(l.allow, now)
+	if len(allow) == 0 {
+		return nil
+	}
+	for _, e := range allow {
+		if e.network.Contains(ip) {
+			return nil
+		}
+	}
+	return ErrNotAllowed
+}
+
+// Watch reloads the lists when the file is changed by another process, such
+// as the q3 bans command, until the context is done.
+func (l *List) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			l.mu.Lock()
+			reloaded, err := l.refresh()
+			l.mu.Unlock()
+			if err != nil {
+				zap.L().Named("bans").Error("cannot reload", zap.String("file", l.path), zap.Error(err))
+				continue
+			}
+			if reloaded {
+				l.changed()
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
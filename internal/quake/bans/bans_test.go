@@ -0,0 +1,96 @@
+package bans
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bans")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "bans.json")
+
+	l, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := 0
+	l.OnChange(func() { changes++ })
+
+	if _, err := l.Ban("10.0.0.0/24", "cheating", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Ban("192.168.1.5", "", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Ban("not-an-address", "", 0); err == nil {
+		t.Error("bans: expected invalid address error")
+	}
+	if changes != 2 {
+		t.Errorf("bans: expected 2 changes, received %d", changes)
+	}
+
+	cases := []struct {
+		ip     string
+		banned bool
+	}{
+		{"10.0.0.7", true},
+		{"10.0.1.7", false},
+		{"192.168.1.5", true},
+		{"192.168.1.6", false},
+	}
+	for _, c := range cases {
+		err := l.Check(net.ParseIP(c.ip))
+		if _, ok := err.(*BannedError); ok != c.banned {
+			t.Errorf("bans: %s expected banned %t, received %v", c.ip, c.banned, err)
+		}
+	}
+	if err := l.Check(net.ParseIP("10.0.0.1")); err.Error() != "banned: cheating" {
+		t.Errorf("bans: unexpected error %q", err)
+	}
+
+	// reopening the file keeps the bans, and expired bans are dropped
+	l, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(l.Bans()); n != 2 {
+		t.Fatalf("bans: expected 2 bans, received %d", n)
+	}
+	if _, err := l.Ban("192.168.1.5", "", time.Nanosecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+	if err := l.Check(net.ParseIP("192.168.1.5")); err != nil {
+		t.Errorf("bans: expected ban to expire, received %v", err)
+	}
+	if found, err := l.Unban("10.0.0.0/24"); err != nil || !found {
+		t.Fatalf("bans: expected unban, received %t %v", found, err)
+	}
+	if n := len(l.Bans()); n != 0 {
+		t.Errorf("bans: expected no bans, received %d", n)
+	}
+
+	if _, err := l.Allow("172.16.0.0/12", "office", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Check(net.ParseIP("172.20.1.1")); err != nil {
+		t.Errorf("bans: expected allowed, received %v", err)
+	}
+	if err := l.Check(net.ParseIP("8.8.8.8")); err != ErrNotAllowed {
+		t.Errorf("bans: expected ErrNotAllowed, received %v", err)
+	}
+	if _, err := l.Ban("172.20.1.1", "", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := l.Check(net.ParseIP("172.20.1.1")).(*BannedError); !ok {
+		t.Error("bans: expected ban to take precedence over the allow list")
+	}
+}
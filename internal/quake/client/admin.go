@@ -0,0 +1,551 @@
+package client
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+
+	"github.com/criticalstack/quake-kube/internal/quake/bans"
+	"github.com/criticalstack/quake-kube/internal/quake/content"
+	quakenet "github.com/criticalstack/quake-kube/internal/quake/net"
+	httputil "github.com/criticalstack/quake-kube/internal/util/net/http"
+)
+
+// Console runs commands on the dedicated server.
+type Console interface {
+	// Exec runs the command and returns its output, if any.
+	Exec(cmd string) (string, error)
+
+	// Subscribe returns the recent output of the server, and a channel that
+	// receives each new line of output until cancel is called.
+	Subscribe() (history []string, lines <-chan string, cancel func())
+}
+
+// AdminConfig protects the admin API with a bearer token, basic auth, TLS
+// client certificates, or any of them.
+type AdminConfig struct {
+	Token    string
+	Username string
+	Password string
+
+	// ClientCerts accepts requests with a TLS client certificate, which has
+	// been verified with the client CAs of the server.
+	ClientCerts bool
+
+	Console Console
+}
+
+func (a *AdminConfig) enabled() bool {
+	return a != nil && a.Console != nil && (a.Token != "" || a.Password != "" || a.ClientCerts)
+}
+
+// authenticate checks the request for a verified client certificate, or the
+// admin bearer token or basic auth credentials. Browsers cannot set headers on
+// websocket requests, so the token may also be given with the token query
+// parameter when upgrading.
+func (a *AdminConfig) authenticate(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if a.ClientCerts {
+			if state := c.Request().TLS; state != nil && len(state.VerifiedChains) > 0 {
+				if crossOrigin(c.Request()) {
+					return errCrossOrigin
+				}
+				return next(c)
+			}
+		}
+		auth := c.Request().Header.Get(echo.HeaderAuthorization)
+		if a.Token != "" && strings.HasPrefix(auth, "Bearer ") {
+			if secureCompare(strings.TrimPrefix(auth, "Bearer "), a.Token) {
+				return next(c)
+			}
+		}
+		if a.Token != "" && websocket.IsWebSocketUpgrade(c.Request()) {
+			if secureCompare(c.QueryParam("token"), a.Token) {
+				// keep the token out of the request log
+				req := c.Request()
+				q := req.URL.Query()
+				q.Del("token")
+				req.URL.RawQuery = q.Encode()
+				req.RequestURI = req.URL.RequestURI()
+				return next(c)
+			}
+		}
+		if a.Password != "" {
+			if user, pass, ok := c.Request().BasicAuth(); ok {
+				if secureCompare(user, a.Username) && secureCompare(pass, a.Password) {
+					if crossOrigin(c.Request()) {
+						return errCrossOrigin
+					}
+					return next(c)
+				}
+			}
+			c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="quake-kube admin"`)
+		}
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid or missing credentials")
+	}
+}
+
+var errCrossOrigin = echo.NewHTTPError(http.StatusForbidden, "cross-origin requests must use a bearer token")
+
+// crossOrigin reports whether the request was made by a browser from another
+// site. Browsers send client certificates and basic auth credentials with
+// requests made by any site, so these are only accepted from the same origin.
+func crossOrigin(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "", "same-origin", "none":
+	default:
+		return true
+	}
+	origin := r.Header.Get(echo.HeaderOrigin)
+	if origin == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return true
+	}
+	return !strings.EqualFold(u.Host, r.Host)
+}
+
+func secureCompare(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// validArg ensures that a value from a request cannot be used to run
+// additional server commands.
+func validArg(name, s string) error {
+	if s == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s is required", name))
+	}
+	if strings.ContainsAny(s, "\";\r\n") {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s contains invalid characters", name))
+	}
+	return nil
+}
+
+// validName is the same as validArg, but also does not allow whitespace in
+// names of maps, bots and cvars.
+func validName(name, s string) error {
+	if strings.ContainsAny(s, " \t") {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s contains invalid characters", name))
+	}
+	return validArg(name, s)
+}
+
+type adminAPI struct {
+	console          Console
+	contentServerURL string
+	bans             *bans.List
+	sessions         *Sessions
+}
+
+func registerAdminAPI(e *echo.Echo, cfg *Config) {
+	a := &adminAPI{
+		console:          cfg.Admin.Console,
+		contentServerURL: cfg.ContentServerURL,
+		bans:             cfg.Bans,
+		sessions:         cfg.Sessions,
+	}
+	if a.sessions != nil {
+		// the session table includes client addresses, so is only served to
+		// admins
+		e.GET("/api/sessions", a.listSessions, httputil.JSONErrors, cfg.Admin.authenticate)
+	}
+	g := e.Group("/admin/api", httputil.JSONErrors, cfg.Admin.authenticate)
+	g.GET("/console", a.streamConsole)
+	g.GET("/maps", a.listMaps)
+	g.GET("/players", a.listPlayers)
+	g.POST("/players/:num/kick", a.kickPlayer)
+	g.POST("/players/:num/ban", a.banPlayer)
+	g.POST("/map", a.changeMap)
+	g.POST("/map/restart", a.restartMap)
+	g.PUT("/nextmap", a.setNextMap)
+	g.POST("/bots", a.addBot)
+	g.DELETE("/bots/:name", a.removeBot)
+	g.POST("/say", a.say)
+	g.GET("/cvars/:name", a.getCvar)
+	g.PUT("/cvars/:name", a.setCvar)
+	if a.bans != nil {
+		g.GET("/bans", a.listBans)
+		g.POST("/bans", a.addBan)
+		g.DELETE("/bans", a.removeBan)
+		g.POST("/allow", a.addAllow)
+		g.DELETE("/allow", a.removeAllow)
+	}
+}
+
+// exec runs a server command, treating failures as the server being
+// unavailable.
+func (a *adminAPI) exec(format string, args ...interface{}) (string, error) {
+	out, err := a.console.Exec(fmt.Sprintf(format, args...))
+	if err != nil {
+		return "", echo.NewHTTPError(http.StatusBadGateway, err.Error())
+	}
+	return out, nil
+}
+
+func (a *adminAPI) status() (*quakenet.ServerStatus, error) {
+	out, err := a.exec("status")
+	if err != nil {
+		return nil, err
+	}
+	if out == "" {
+		return nil, echo.NewHTTPError(http.StatusNotImplemented, "server status requires an rcon password")
+	}
+	return quakenet.ParseServerStatus(out)
+}
+
+// client returns the client in the slot from the request path.
+func (a *adminAPI) client(c echo.Context) (*quakenet.Client, error) {
+	num, err := strconv.Atoi(c.Param("num"))
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "invalid client number")
+	}
+	status, err := a.status()
+	if err != nil {
+		return nil, err
+	}
+	for _, client := range status.Clients {
+		if client.Num == num {
+			return &client, nil
+		}
+	}
+	return nil, echo.NewHTTPError(http.StatusNotFound, "player not found")
+}
+
+func (a *adminAPI) listPlayers(c echo.Context) error {
+	status, err := a.status()
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, status)
+}
+
+func (a *adminAPI) listSessions(c echo.Context) error {
+	return c.JSON(http.StatusOK, a.sessions.List())
+}
+
+func (a *adminAPI) kickPlayer(c echo.Context) error {
+	client, err := a.client(c)
+	if err != nil {
+		return err
+	}
+	if _, err := a.exec("clientkick %d", client.Num); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, client)
+}
+
+func (a *adminAPI) banPlayer(c echo.Context) error {
+	client, err := a.client(c)
+	if err != nil {
+		return err
+	}
+	if client.IsBot() {
+		return echo.NewHTTPError(http.StatusBadRequest, "bots cannot be banned")
+	}
+	host, _, err := net.SplitHostPort(client.Address)
+	if err != nil {
+		host = client.Address
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		// banning the proxy address would ban every websocket client, so the
+		// real address of the client is banned at the proxy instead
+		if a.bans == nil || a.sessions == nil {
+			return echo.NewHTTPError(http.StatusConflict, "cannot ban a client connected through the websocket proxy")
+		}
+		sess, ok := a.sessions.BySlot(client.Num)
+		if !ok {
+			return echo.NewHTTPError(http.StatusConflict, "no websocket session found for the player")
+		}
+		req := banRequest{Reason: "banned by admin"}
+		if c.Request().ContentLength > 0 {
+			if err := c.Bind(&req); err != nil {
+				return err
+			}
+		}
+		if _, err := a.bans.Ban(sess.IP.String(), req.Reason, req.Duration.Duration); err != nil {
+			return err
+		}
+	} else if _, err := a.exec("banaddr %s", host); err != nil {
+		return err
+	}
+	if _, err := a.exec("clientkick %d", client.Num); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, client)
+}
+
+type mapRequest struct {
+	Name string `json:"name"`
+}
+
+func (a *adminAPI) changeMap(c echo.Context) error {
+	var req mapRequest
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	if err := validName("name", req.Name); err != nil {
+		return err
+	}
+	if _, err := a.exec("map %s", req.Name); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, req)
+}
+
+func (a *adminAPI) restartMap(c echo.Context) error {
+	if _, err := a.exec("map_restart"); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (a *adminAPI) setNextMap(c echo.Context) error {
+	var req mapRequest
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	if err := validName("name", req.Name); err != nil {
+		return err
+	}
+	// the map rotation sets nextmap to the next map in the rotation, which is
+	// kept so that the rotation continues after the map
+	cmd := "map " + req.Name
+	out, err := a.exec("nextmap")
+	if err != nil {
+		return err
+	}
+	if _, value, ok := quakenet.ParseCvar(out); ok {
+		if m := rotationRegexp.FindAllStringSubmatch(value, -1); len(m) > 0 {
+			cmd += " ; set nextmap vstr " + m[len(m)-1][1]
+		}
+	}
+	if _, err := a.exec(`set nextmap "%s"`, cmd); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, req)
+}
+
+// rotationRegexp matches the next map of the map rotation in the nextmap
+// cvar.
+var rotationRegexp = regexp.MustCompile(`\bvstr (d\d+)\b`)
+
+type botRequest struct {
+	Name  string `json:"name"`
+	Skill int    `json:"skill"`
+	Team  string `json:"team"`
+}
+
+func (a *adminAPI) addBot(c echo.Context) error {
+	req := botRequest{Skill: 2, Team: "free"}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	if err := validName("name", req.Name); err != nil {
+		return err
+	}
+	if req.Skill < 1 || req.Skill > 5 {
+		return echo.NewHTTPError(http.StatusBadRequest, "skill must be between 1 and 5")
+	}
+	switch req.Team {
+	case "red", "blue", "free":
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("unknown team %q", req.Team))
+	}
+	if _, err := a.exec("addbot %s %d %s", req.Name, req.Skill, req.Team); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusCreated, req)
+}
+
+func (a *adminAPI) removeBot(c echo.Context) error {
+	name := c.Param("name")
+	if err := validName("name", name); err != nil {
+		return err
+	}
+	if _, err := a.exec(`kick "%s"`, name); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+type sayRequest struct {
+	Message string `json:"message"`
+}
+
+func (a *adminAPI) say(c echo.Context) error {
+	var req sayRequest
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	if err := validArg("message", req.Message); err != nil {
+		return err
+	}
+	if _, err := a.exec(`say "%s"`, req.Message); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, req)
+}
+
+type cvar struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+func (a *adminAPI) getCvar(c echo.Context) error {
+	name := c.Param("name")
+	if err := validName("name", name); err != nil {
+		return err
+	}
+	// a cvar is read by running its name as a command, so the name is checked
+	// against the cvar list first to not run any other command
+	out, err := a.exec("cvarlist %s", name)
+	if err != nil {
+		return err
+	}
+	if out == "" {
+		return echo.NewHTTPError(http.StatusNotImplemented, "reading cvars requires an rcon password")
+	}
+	found := false
+	for _, n := range quakenet.ParseCvarList(out) {
+		if strings.EqualFold(n, name) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("unknown cvar %q", name))
+	}
+	out, err = a.exec("%s", name)
+	if err != nil {
+		return err
+	}
+	_, value, ok := quakenet.ParseCvar(out)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("unknown cvar %q", name))
+	}
+	return c.JSON(http.StatusOK, cvar{Name: name, Value: value})
+}
+
+func (a *adminAPI) setCvar(c echo.Context) error {
+	name := c.Param("name")
+	if err := validName("name", name); err != nil {
+		return err
+	}
+	var req cvar
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	if strings.ContainsAny(req.Value, "\";\r\n") {
+		return echo.NewHTTPError(http.StatusBadRequest, "value contains invalid characters")
+	}
+	if _, err := a.exec(`set %s "%s"`, name, req.Value); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, cvar{Name: name, Value: req.Value})
+}
+
+// listMaps returns the maps available from the content server, sorted by
+// name.
+func (a *adminAPI) listMaps(c echo.Context) error {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(strings.TrimSuffix(a.contentServerURL, "/") + "/maps")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadGateway, err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return echo.NewHTTPError(http.StatusBadGateway, fmt.Sprintf("content server returned %s", resp.Status))
+	}
+	maps := make([]*content.Map, 0)
+	if err := json.NewDecoder(resp.Body).Decode(&maps); err != nil {
+		return echo.NewHTTPError(http.StatusBadGateway, fmt.Sprintf("cannot decode maps: %v", err))
+	}
+	sort.Slice(maps, func(i, j int) bool {
+		return maps[i].Name < maps[j].Name
+	})
+	return c.JSON(http.StatusOK, maps)
+}
+
+// consoleUpgrader only accepts websocket requests from the same origin, since
+// browsers will send basic auth credentials with cross-origin requests.
+var consoleUpgrader = &websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// streamConsole sends the server output to a websocket, one line per message,
+// starting with the recent history. Each message received is run as a server
+// command, and any output is sent back to the same websocket.
+func (a *adminAPI) streamConsole(c echo.Context) error {
+	ws, err := consoleUpgrader.Upgrade(c.Response(), c.Request(), nil)
+	if err != nil {
+		// the upgrader has already responded with the error
+		return nil
+	}
+	defer ws.Close()
+
+	history, lines, cancel := a.console.Subscribe()
+	defer cancel()
+
+	output := make(chan string, 16)
+	done := make(chan struct{})
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		defer close(done)
+		for {
+			_, msg, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			cmd := strings.TrimSpace(string(msg))
+			if cmd == "" {
+				continue
+			}
+			out, err := a.console.Exec(cmd)
+			if err != nil {
+				out = fmt.Sprintf("error: %v", err)
+			}
+			if out == "" {
+				continue
+			}
+			select {
+			case output <- strings.TrimRight(out, "\n"):
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	for _, line := range history {
+		if err := ws.WriteMessage(websocket.TextMessage, []byte(line)); err != nil {
+			return nil
+		}
+	}
+	for {
+		var msg string
+		select {
+		case msg = <-lines:
+		case msg = <-output:
+		case <-done:
+			return nil
+		}
+		if err := ws.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			return nil
+		}
+	}
+}
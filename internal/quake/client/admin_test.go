@@ -0,0 +1,266 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/labstack/echo/v4"
+)
+
+const adminStatusOutput = `map: q3dm7
+num score ping name            lastmsg address               qport rate
+--- ----- ---- --------------- ------- --------------------- ----- -----
+  0     5    0 Sarge^7              50 bot                       0 16384
+  1    12   45 player^7              0 203.0.113.7:27960      1234 25000
+  2     3   60 proxied^7             0 127.0.0.1:53211        4321 25000
+`
+
+// fakeConsole returns the output set for each command, and records the
+// commands that were run.
+type fakeConsole struct {
+	output map[string]string
+
+	mu   sync.Mutex
+	cmds []string
+}
+
+func (f *fakeConsole) Exec(cmd string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.cmds = append(f.cmds, cmd)
+	return f.output[cmd], nil
+}
+
+func (f *fakeConsole) Subscribe() ([]string, <-chan string, func()) {
+	return nil, make(chan string), func() {}
+}
+
+func (f *fakeConsole) commands() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.cmds...)
+}
+
+func TestAdminAuth(t *testing.T) {
+	cases := []struct {
+		name     string
+		url      string
+		header   map[string]string
+		basic    []string
+		expected int
+	}{
+		{name: "bearer", url: "/test", header: map[string]string{"Authorization": "Bearer secret"}, expected: http.StatusOK},
+		{name: "wrong bearer", url: "/test", header: map[string]string{"Authorization": "Bearer nope"}, expected: http.StatusUnauthorized},
+		{name: "basic", url: "/test", basic: []string{"admin", "hunter2"}, expected: http.StatusOK},
+		{name: "wrong basic", url: "/test", basic: []string{"admin", "nope"}, expected: http.StatusUnauthorized},
+		{name: "missing", url: "/test", expected: http.StatusUnauthorized},
+		{
+			name: "query token",
+			url:  "/test?token=secret",
+			header: map[string]string{
+				"Connection": "Upgrade",
+				"Upgrade":    "websocket",
+			},
+			expected: http.StatusOK,
+		},
+		{name: "query token without upgrade", url: "/test?token=secret", expected: http.StatusUnauthorized},
+		{
+			name:     "same origin basic",
+			url:      "/test",
+			header:   map[string]string{"Origin": "http://example.com", "Sec-Fetch-Site": "same-origin"},
+			basic:    []string{"admin", "hunter2"},
+			expected: http.StatusOK,
+		},
+		{
+			name:     "cross origin basic",
+			url:      "/test",
+			header:   map[string]string{"Origin": "http://evil.example.com"},
+			basic:    []string{"admin", "hunter2"},
+			expected: http.StatusForbidden,
+		},
+		{
+			name:     "cross site basic",
+			url:      "/test",
+			header:   map[string]string{"Sec-Fetch-Site": "cross-site"},
+			basic:    []string{"admin", "hunter2"},
+			expected: http.StatusForbidden,
+		},
+		{
+			name:     "cross origin bearer",
+			url:      "/test",
+			header:   map[string]string{"Origin": "http://evil.example.com", "Authorization": "Bearer secret"},
+			expected: http.StatusOK,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			a := &AdminConfig{Token: "secret", Username: "admin", Password: "hunter2"}
+			e := echo.New()
+			var query string
+			e.GET("/test", func(c echo.Context) error {
+				query = c.Request().URL.RawQuery
+				return c.NoContent(http.StatusOK)
+			}, a.authenticate)
+			req := httptest.NewRequest(http.MethodGet, "http://example.com"+c.url, nil)
+			for k, v := range c.header {
+				req.Header.Set(k, v)
+			}
+			if c.basic != nil {
+				req.SetBasicAuth(c.basic[0], c.basic[1])
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != c.expected {
+				t.Errorf("client: expected %d, received %d", c.expected, rec.Code)
+			}
+			if strings.Contains(query, "token") {
+				t.Errorf("client: expected the token to be removed from the query, received %q", query)
+			}
+		})
+	}
+}
+
+func TestValidArg(t *testing.T) {
+	cases := []struct {
+		value string
+		arg   bool
+		name  bool
+	}{
+		{"q3dm17", true, true},
+		{"hello world", true, false},
+		{"", false, false},
+		{"q3dm17;quit", false, false},
+		{`say "hi"`, false, false},
+		{"line\nquit", false, false},
+		{"tab\there", true, false},
+	}
+	for _, c := range cases {
+		if err := validArg("value", c.value); (err == nil) != c.arg {
+			t.Errorf("client: validArg(%q) expected valid %t, received %v", c.value, c.arg, err)
+		}
+		if err := validName("value", c.value); (err == nil) != c.name {
+			t.Errorf("client: validName(%q) expected valid %t, received %v", c.value, c.name, err)
+		}
+	}
+}
+
+func TestAdminCommands(t *testing.T) {
+	cases := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		expected int
+		cmds     []string
+	}{
+		{
+			name:     "kick",
+			method:   http.MethodPost,
+			path:     "/admin/api/players/1/kick",
+			expected: http.StatusOK,
+			cmds:     []string{"status", "clientkick 1"},
+		},
+		{
+			name:     "kick unknown player",
+			method:   http.MethodPost,
+			path:     "/admin/api/players/7/kick",
+			expected: http.StatusNotFound,
+			cmds:     []string{"status"},
+		},
+		{
+			name:     "kick invalid player",
+			method:   http.MethodPost,
+			path:     "/admin/api/players/one/kick",
+			expected: http.StatusBadRequest,
+		},
+		{
+			name:     "ban",
+			method:   http.MethodPost,
+			path:     "/admin/api/players/1/ban",
+			expected: http.StatusOK,
+			cmds:     []string{"status", "banaddr 203.0.113.7", "clientkick 1"},
+		},
+		{
+			name:     "ban bot",
+			method:   http.MethodPost,
+			path:     "/admin/api/players/0/ban",
+			expected: http.StatusBadRequest,
+			cmds:     []string{"status"},
+		},
+		{
+			// the proxy address must never be banned
+			name:     "ban proxied player without bans",
+			method:   http.MethodPost,
+			path:     "/admin/api/players/2/ban",
+			expected: http.StatusConflict,
+			cmds:     []string{"status"},
+		},
+		{
+			name:     "change map",
+			method:   http.MethodPost,
+			path:     "/admin/api/map",
+			body:     `{"name": "q3dm17"}`,
+			expected: http.StatusOK,
+			cmds:     []string{"map q3dm17"},
+		},
+		{
+			name:     "change map with command",
+			method:   http.MethodPost,
+			path:     "/admin/api/map",
+			body:     `{"name": "q3dm17;quit"}`,
+			expected: http.StatusBadRequest,
+		},
+		{
+			name:     "next map",
+			method:   http.MethodPut,
+			path:     "/admin/api/nextmap",
+			body:     `{"name": "q3dm17"}`,
+			expected: http.StatusOK,
+			cmds:     []string{"nextmap", `set nextmap "map q3dm17 ; set nextmap vstr d2"`},
+		},
+		{
+			name:     "read cvar",
+			method:   http.MethodGet,
+			path:     "/admin/api/cvars/sv_hostname",
+			expected: http.StatusOK,
+			cmds:     []string{"cvarlist sv_hostname", "sv_hostname"},
+		},
+		{
+			// commands are not cvars, so must not be run
+			name:     "read command",
+			method:   http.MethodGet,
+			path:     "/admin/api/cvars/quit",
+			expected: http.StatusNotFound,
+			cmds:     []string{"cvarlist quit"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			console := &fakeConsole{output: map[string]string{
+				"status":               adminStatusOutput,
+				"nextmap":              `"nextmap" is:"vstr d2^7" default:"^7"` + "\n",
+				"cvarlist sv_hostname": "S R     sv_hostname \"quakekube\"\n\n1 total cvars\n",
+				"sv_hostname":          `"sv_hostname" is:"quakekube^7" default:"noname^7"` + "\n",
+				"cvarlist quit":        "\n0 total cvars\n",
+			}}
+			e := echo.New()
+			registerAdminAPI(e, &Config{Admin: &AdminConfig{Token: "secret", Console: console}})
+			req := httptest.NewRequest(c.method, c.path, strings.NewReader(c.body))
+			req.Header.Set(echo.HeaderAuthorization, "Bearer secret")
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != c.expected {
+				t.Errorf("client: expected %d, received %d: %s", c.expected, rec.Code, rec.Body.String())
+			}
+			if diff := cmp.Diff(c.cmds, console.commands(), cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("client: commands differ: (-want +got)\n%s", diff)
+			}
+		})
+	}
+}
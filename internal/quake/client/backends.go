@@ -0,0 +1,240 @@
+package client
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"sigs.k8s.io/yaml"
+)
+
+// ErrUnknownBackend is returned by Route when the request names a backend
+// that is not in the list, so that the proxy cannot be used to reach
+// arbitrary UDP addresses.
+var ErrUnknownBackend = errors.New("unknown server")
+
+// Backend is a dedicated server that clients can be routed to.
+type Backend struct {
+	// Name selects the backend with the path of the request, e.g. /ffa, or
+	// the server query parameter, e.g. ?server=ffa.
+	Name string `json:"name"`
+
+	// Addr is the UDP address of the dedicated server.
+	Addr string `json:"addr"`
+
+	// Hosts select the backend with the Host header of the request.
+	Hosts []string `json:"hosts,omitempty"`
+
+	// Default is the backend for requests that do not select one.
+	Default bool `json:"default,omitempty"`
+}
+
+type backendsFile struct {
+	Backends []Backend `json:"backends"`
+}
+
+// Backends is the list of dedicated servers the proxy routes clients to,
+// which is either static or loaded from a file.
+type Backends struct {
+	path string
+
+	mu       sync.RWMutex
+	backends []Backend
+	modTime  time.Time
+}
+
+// NewBackends returns a static list of backends.
+func NewBackends(backends []Backend) (*Backends, error) {
+	if err := validateBackends(backends); err != nil {
+		return nil, err
+	}
+	return &Backends{backends: backends}, nil
+}
+
+// OpenBackends loads the backends from a YAML or JSON file, e.g.:
+//
+//	backends:
+//	- name: ffa
+//	  addr: 10.0.0.5:27960
+//	  hosts: [ffa.example.com]
+//	  default: true
+//	- name: ctf
+//	  addr: 10.0.0.6:27960
+func OpenBackends(path string) (*Backends, error) {
+	b := &Backends{path: path}
+	if _, err := b.refresh(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// ParseBackend parses a backend given as <name>=<addr>[,<host>...].
+func ParseBackend(s string) (Backend, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 {
+		return Backend{}, errors.Errorf("invalid backend: %q", s)
+	}
+	fields := strings.Split(parts[1], ",")
+	return Backend{Name: parts[0], Addr: fields[0], Hosts: fields[1:]}, nil
+}
+
+// ParseBackendFlags returns the backends given by the backend flags of the
+// commands serving the proxy, either a list of <name>=<addr>[,<host>...] or
+// a file, which are nil when there are none.
+func ParseBackendFlags(backends []string, path string) (*Backends, error) {
+	if path != "" {
+		if len(backends) > 0 {
+			return nil, errors.New("--backend and --backends-file cannot be used together")
+		}
+		return OpenBackends(path)
+	}
+	if len(backends) == 0 {
+		return nil, nil
+	}
+	list := make([]Backend, 0, len(backends))
+	for _, s := range backends {
+		b, err := ParseBackend(s)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, b)
+	}
+	return NewBackends(list)
+}
+
+func validateBackends(backends []Backend) error {
+	names := make(map[string]bool)
+	defaults := 0
+	for _, b := range backends {
+		if b.Name == "" || strings.ContainsAny(b.Name, "/?#") {
+			return errors.Errorf("invalid backend name: %q", b.Name)
+		}
+		// these paths are used by the admin console and WebRTC signalling
+		if b.Name == "admin" || b.Name == "rtc" {
+			return errors.Errorf("reserved backend name: %q", b.Name)
+		}
+		if names[b.Name] {
+			return errors.Errorf("duplicate backend: %q", b.Name)
+		}
+		names[b.Name] = true
+		if _, _, err := net.SplitHostPort(b.Addr); err != nil {
+			return errors.Errorf("invalid address for backend %q: %q", b.Name, b.Addr)
+		}
+		if b.Default {
+			defaults++
+		}
+	}
+	if defaults > 1 {
+		return errors.New("more than one default backend")
+	}
+	return nil
+}
+
+// refresh reloads the file when it has been modified since it was last read,
+// returning whether it was reloaded. The caller must hold the write lock,
+// except when opening.
+func (b *Backends) refresh() (bool, error) {
+	fi, err := os.Stat(b.path)
+	if err != nil {
+		return false, err
+	}
+	if fi.ModTime().Equal(b.modTime) {
+		return false, nil
+	}
+	data, err := ioutil.ReadFile(b.path)
+	if err != nil {
+		return false, err
+	}
+	var f backendsFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return false, errors.Wrapf(err, "cannot read %s", b.path)
+	}
+	if err := validateBackends(f.Backends); err != nil {
+		return false, errors.Wrapf(err, "cannot read %s", b.path)
+	}
+	b.backends, b.modTime = f.Backends, fi.ModTime()
+	return true, nil
+}
+
+// Watch reloads the file when it changes, until the context is done. The
+// previous backends are kept when the file is invalid.
+func (b *Backends) Watch(ctx context.Context, interval time.Duration) {
+	if b.path == "" {
+		return
+	}
+	log := zap.L().Named("backends")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			b.mu.Lock()
+			reloaded, err := b.refresh()
+			b.mu.Unlock()
+			if err != nil {
+				log.Error("cannot reload", zap.String("file", b.path), zap.Error(err))
+				continue
+			}
+			if reloaded {
+				log.Info("reloaded", zap.String("file", b.path))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// List returns the backends.
+func (b *Backends) List() []Backend {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return append([]Backend{}, b.backends...)
+}
+
+// Route returns the backend selected by the request, using the first of the
+// path, the server query parameter and the Host header that is set. The path
+// is given separately so that a prefix, such as /rtc, can be removed. A nil
+// backend is returned when the request does not select one, and there is no
+// default.
+func (b *Backends) Route(req *http.Request, path string) (*Backend, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	name := strings.Trim(path, "/")
+	if name == "" {
+		name = req.URL.Query().Get("server")
+	}
+	if name != "" {
+		for _, backend := range b.backends {
+			if backend.Name == name {
+				return &backend, nil
+			}
+		}
+		return nil, ErrUnknownBackend
+	}
+	host := req.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	for _, backend := range b.backends {
+		for _, h := range backend.Hosts {
+			if strings.EqualFold(h, host) {
+				return &backend, nil
+			}
+		}
+	}
+	for _, backend := range b.backends {
+		if backend.Default {
+			return &backend, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,129 @@
+package client
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestBackendsRoute(t *testing.T) {
+	b, err := NewBackends([]Backend{
+		{Name: "ffa", Addr: "10.0.0.5:27960", Hosts: []string{"ffa.example.com"}, Default: true},
+		{Name: "ctf", Addr: "10.0.0.6:27960", Hosts: []string{"ctf.example.com"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name     string
+		url      string
+		host     string
+		expected string
+		err      error
+	}{
+		{"path", "/ctf", "quake.example.com", "ctf", nil},
+		{"query", "/?server=ctf", "quake.example.com", "ctf", nil},
+		{"host", "/", "CTF.example.com:8080", "ctf", nil},
+		{"path before host", "/ffa", "ctf.example.com", "ffa", nil},
+		{"default", "/", "quake.example.com", "ffa", nil},
+		{"unknown path", "/tdm", "ctf.example.com", "", ErrUnknownBackend},
+		{"unknown query", "/?server=10.0.0.7:27960", "quake.example.com", "", ErrUnknownBackend},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tc.url, nil)
+			req.Host = tc.host
+			backend, err := b.Route(req, req.URL.Path)
+			if err != tc.err {
+				t.Fatalf("expected error %v, received %v", tc.err, err)
+			}
+			if tc.err != nil {
+				return
+			}
+			if backend.Name != tc.expected {
+				t.Fatalf("expected %q, received %q", tc.expected, backend.Name)
+			}
+		})
+	}
+}
+
+func TestBackendsValidate(t *testing.T) {
+	invalid := [][]Backend{
+		{{Name: "", Addr: "10.0.0.5:27960"}},
+		{{Name: "admin", Addr: "10.0.0.5:27960"}},
+		{{Name: "ffa", Addr: "10.0.0.5"}},
+		{{Name: "ffa", Addr: "10.0.0.5:27960"}, {Name: "ffa", Addr: "10.0.0.6:27960"}},
+		{{Name: "ffa", Addr: "10.0.0.5:27960", Default: true}, {Name: "ctf", Addr: "10.0.0.6:27960", Default: true}},
+	}
+	for _, backends := range invalid {
+		if _, err := NewBackends(backends); err == nil {
+			t.Errorf("expected error for %+v", backends)
+		}
+	}
+}
+
+func TestBackendsReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "backends")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "backends.yaml")
+	if err := ioutil.WriteFile(path, []byte("backends:\n- name: ffa\n  addr: 10.0.0.5:27960\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b, err := OpenBackends(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte("backends:\n- name: ctf\n  addr: 10.0.0.6:27960\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// ensure the modification time changes on filesystems with a coarse
+	// resolution
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+	reloaded, err := b.refresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reloaded {
+		t.Fatal("expected backends to be reloaded")
+	}
+	backends := b.List()
+	if len(backends) != 1 || backends[0].Name != "ctf" {
+		t.Fatalf("expected ctf backend, received %+v", backends)
+	}
+}
+
+func TestProxyUnknownBackend(t *testing.T) {
+	p, err := NewProxy("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Backends, err = NewBackends([]Backend{{Name: "ffa", Addr: "127.0.0.1:27960"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(p)
+	defer srv.Close()
+
+	for _, path := range []string{"/", "/tdm", "/?server=127.0.0.1:27961"} {
+		_, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+path, nil)
+		if err == nil {
+			t.Fatalf("%s: expected connection to be refused", path)
+		}
+		if resp == nil || resp.StatusCode != http.StatusNotFound {
+			t.Fatalf("%s: expected %d, received %v", path, http.StatusNotFound, resp)
+		}
+	}
+}
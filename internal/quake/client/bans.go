@@ -0,0 +1,91 @@
+package client
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/criticalstack/quake-kube/internal/quake/bans"
+)
+
+type banList struct {
+	Bans  []bans.Entry `json:"bans"`
+	Allow []bans.Entry `json:"allow"`
+}
+
+type banRequest struct {
+	CIDR   string `json:"cidr"`
+	Reason string `json:"reason"`
+
+	// Duration is how long the entry is kept, forever when not set.
+	Duration metav1.Duration `json:"duration"`
+}
+
+func (a *adminAPI) listBans(c echo.Context) error {
+	return c.JSON(http.StatusOK, banList{Bans: a.bans.Bans(), Allow: a.bans.Allowed()})
+}
+
+// bindBan reads an entry for the ban or allow list from the request.
+func bindBan(c echo.Context) (*banRequest, error) {
+	var req banRequest
+	if err := c.Bind(&req); err != nil {
+		return nil, err
+	}
+	if _, err := bans.ParseCIDR(req.CIDR); err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if req.Duration.Duration < 0 {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "duration cannot be negative")
+	}
+	return &req, nil
+}
+
+func (a *adminAPI) addBan(c echo.Context) error {
+	req, err := bindBan(c)
+	if err != nil {
+		return err
+	}
+	e, err := a.bans.Ban(req.CIDR, req.Reason, req.Duration.Duration)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusCreated, e)
+}
+
+func (a *adminAPI) addAllow(c echo.Context) error {
+	req, err := bindBan(c)
+	if err != nil {
+		return err
+	}
+	e, err := a.bans.Allow(req.CIDR, req.Reason, req.Duration.Duration)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusCreated, e)
+}
+
+// removeEntry removes the address range given by the cidr query parameter,
+// since ranges contain a slash.
+func removeEntry(c echo.Context, remove func(string) (bool, error)) error {
+	cidr := c.QueryParam("cidr")
+	if _, err := bans.ParseCIDR(cidr); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	found, err := remove(cidr)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return echo.NewHTTPError(http.StatusNotFound, "address range not found")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (a *adminAPI) removeBan(c echo.Context) error {
+	return removeEntry(c, a.bans.Unban)
+}
+
+func (a *adminAPI) removeAllow(c echo.Context) error {
+	return removeEntry(c, a.bans.Disallow)
+}
@@ -0,0 +1,109 @@
+package client
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	quakenet "github.com/criticalstack/quake-kube/internal/quake/net"
+)
+
+var droppedPackets = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "quake_proxy_dropped_packets",
+	Help: "Connectionless packets from clients that were not forwarded, by reason",
+}, []string{"reason"})
+
+var (
+	droppedPort    = droppedPackets.WithLabelValues("port")
+	droppedUnknown = droppedPackets.WithLabelValues("unknown")
+)
+
+var (
+	outOfBandHeader = []byte(quakenet.OutOfBandHeader)
+	portCommand     = []byte("port")
+
+	// clientCommands are the connectionless commands that the dedicated
+	// server accepts from clients, see SV_ConnectionlessPacket in ioquake3.
+	clientCommands = [][]byte{
+		[]byte("getstatus"),
+		[]byte("getinfo"),
+		[]byte("getchallenge"),
+		[]byte("connect"),
+		[]byte("rcon"),
+	}
+)
+
+// portMessage is sent by QuakeJS peers when connecting, to tell the other side
+// the port they are listening on, which replaces the port of the websocket.
+func portMessage(port int) []byte {
+	msg := []byte("\xff\xff\xff\xffport\x00\x00")
+	binary.BigEndian.PutUint16(msg[8:], uint16(port))
+	return msg
+}
+
+func isPortMessage(msg []byte) bool {
+	_, ok := parsePortMessage(msg)
+	return ok
+}
+
+// parsePortMessage returns the port of a port message.
+func parsePortMessage(msg []byte) (int, bool) {
+	if len(msg) != 10 || !bytes.HasPrefix(msg, outOfBandHeader) || !bytes.Equal(msg[4:8], portCommand) {
+		return 0, false
+	}
+	return int(binary.BigEndian.Uint16(msg[8:])), true
+}
+
+// connectionlessCommand returns the command of a connectionless packet, which
+// is the first word after the out-of-band header.
+func connectionlessCommand(msg []byte) ([]byte, bool) {
+	if !bytes.HasPrefix(msg, outOfBandHeader) {
+		return nil, false
+	}
+	cmd := msg[len(outOfBandHeader):]
+	for i, c := range cmd {
+		if c <= ' ' {
+			return cmd[:i], true
+		}
+	}
+	return cmd, true
+}
+
+// isClientCommand reports whether the dedicated server handles the command,
+// which like ioquake3 ignores case.
+func isClientCommand(cmd []byte) bool {
+	for _, c := range clientCommands {
+		if bytes.EqualFold(cmd, c) {
+			return true
+		}
+	}
+	return false
+}
+
+// listenPort returns the port the client connected to, which is the port in
+// the websocket URL of QuakeJS clients. The Host header has no port when the
+// default port of the scheme is used.
+func listenPort(req *http.Request) int {
+	if _, port, err := net.SplitHostPort(req.Host); err == nil {
+		if p, err := strconv.Atoi(port); err == nil {
+			return p
+		}
+	}
+	if req.TLS != nil || req.Header.Get("X-Forwarded-Proto") == "https" {
+		return 443
+	}
+	return 80
+}
+
+// truncate shortens a packet for logging.
+func truncate(msg []byte, n int) []byte {
+	if len(msg) > n {
+		return msg[:n]
+	}
+	return msg
+}
@@ -0,0 +1,153 @@
+package client
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParsePortMessage(t *testing.T) {
+	cases := []struct {
+		msg  string
+		port int
+		ok   bool
+	}{
+		{"\xff\xff\xff\xffport\x6d\x38", 27960, true},
+		{"\xff\xff\xff\xffport\x00\x50", 80, true},
+		{"\xff\xff\xff\xffport\x6d", 0, false},
+		{"\xff\xff\xff\xffport\x6d\x38\x00", 0, false},
+		{"\xff\xff\xff\xffpong\x6d\x38", 0, false},
+		{"\x00\x00\x00\x00port\x6d\x38", 0, false},
+	}
+	for _, c := range cases {
+		port, ok := parsePortMessage([]byte(c.msg))
+		if port != c.port || ok != c.ok {
+			t.Errorf("client: %q: expected %d, %v, received %d, %v", c.msg, c.port, c.ok, port, ok)
+		}
+	}
+	if port, ok := parsePortMessage(portMessage(27960)); !ok || port != 27960 {
+		t.Errorf("client: expected port message to round trip, received %d, %v", port, ok)
+	}
+}
+
+func TestConnectionlessCommand(t *testing.T) {
+	cases := []struct {
+		msg    string
+		cmd    string
+		ok     bool
+		client bool
+	}{
+		{"\xff\xff\xff\xffgetinfo", "getinfo", true, true},
+		{"\xff\xff\xff\xffgetstatus xxx", "getstatus", true, true},
+		{"\xff\xff\xff\xffgetchallenge 1234 ioq3", "getchallenge", true, true},
+		{"\xff\xff\xff\xffconnect \x22\x81", "connect", true, true},
+		{"\xff\xff\xff\xffrcon secret status", "rcon", true, true},
+		{"\xff\xff\xff\xffGetInfo\n", "GetInfo", true, true},
+		{"\xff\xff\xff\xffport\x6d\x38", "port\x6d\x38", true, false},
+		{"\xff\xff\xff\xffipAuthorize 1 accept", "ipAuthorize", true, false},
+		{"\xff\xff\xff\xff", "", true, false},
+		{"\x01\x00\x00\x00\xff\xff", "", false, false},
+	}
+	for _, c := range cases {
+		cmd, ok := connectionlessCommand([]byte(c.msg))
+		if string(cmd) != c.cmd || ok != c.ok {
+			t.Errorf("client: %q: expected %q, %v, received %q, %v", c.msg, c.cmd, c.ok, cmd, ok)
+		}
+		if ok && isClientCommand(cmd) != c.client {
+			t.Errorf("client: %q: expected client command %v", c.msg, c.client)
+		}
+	}
+}
+
+func TestListenPort(t *testing.T) {
+	cases := []struct {
+		name     string
+		host     string
+		tls      bool
+		proto    string
+		expected int
+	}{
+		{"explicit", "quake.example.com:8080", false, "", 8080},
+		{"http", "quake.example.com", false, "", 80},
+		{"https", "quake.example.com", true, "", 443},
+		{"forwarded", "quake.example.com", false, "https", 443},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Host = c.host
+			if c.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			if c.proto != "" {
+				req.Header.Set("X-Forwarded-Proto", c.proto)
+			}
+			if port := listenPort(req); port != c.expected {
+				t.Errorf("client: expected %d, received %d", c.expected, port)
+			}
+		})
+	}
+}
+
+func TestProxyPortMessage(t *testing.T) {
+	backend, senders := echoBackend(t)
+	defer backend.Close()
+
+	p, err := NewProxy(backend.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(p)
+	defer srv.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	// the proxy answers with the port the client connected to, as the
+	// websocket URL of a QuakeJS client has the port of the server
+	port := srv.Listener.Addr().(*net.TCPAddr).Port
+	if msg := readPacket(t, ws); msg != string(portMessage(port)) {
+		t.Fatalf("client: expected port message for %d, received %q", port, msg)
+	}
+
+	dropped := testutil.ToFloat64(droppedPackets.WithLabelValues("port")) +
+		testutil.ToFloat64(droppedPackets.WithLabelValues("unknown"))
+	for _, msg := range []string{
+		"\xff\xff\xff\xffport\x6d\x38",
+		// only the first message is a port message
+		"\xff\xff\xff\xffport\x6d\x39",
+		"\xff\xff\xff\xffipAuthorize 1 accept",
+		"\xff\xff\xff\xffgetinfo",
+	} {
+		if err := ws.WriteMessage(websocket.BinaryMessage, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if msg := readPacket(t, ws); msg != "\xff\xff\xff\xffgetinfo" {
+		t.Fatalf("client: expected only getinfo to be forwarded, received %q", msg)
+	}
+	<-senders
+	select {
+	case addr := <-senders:
+		t.Fatalf("client: unexpected packet from %s", addr)
+	default:
+	}
+
+	sessions := p.Sessions.List()
+	if len(sessions) != 1 || sessions[0].ClientPort != 27960 || sessions[0].Stats.PacketsDropped != 2 {
+		t.Fatalf("client: expected client port 27960 and 2 dropped packets, received %+v", sessions)
+	}
+	after := testutil.ToFloat64(droppedPackets.WithLabelValues("port")) +
+		testutil.ToFloat64(droppedPackets.WithLabelValues("unknown"))
+	if after-dropped != 2 {
+		t.Errorf("client: expected 2 dropped packets to be counted, received %v", after-dropped)
+	}
+}
@@ -0,0 +1,121 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// healthTimeout bounds the checks of a single request to /healthz or /readyz.
+const healthTimeout = 3 * time.Second
+
+type CheckStatus string
+
+const (
+	StatusOK CheckStatus = "ok"
+
+	// StatusWaiting is a part of the server that is not ready yet, such as
+	// while assets are downloading. It fails readiness, but not liveness.
+	StatusWaiting CheckStatus = "waiting"
+
+	// StatusFailed is a part of the server that will not recover without a
+	// restart, such as when ioq3ded has exited.
+	StatusFailed CheckStatus = "failed"
+)
+
+// CheckResult is the status of a part of the server, with detail such as the
+// download progress or why it failed.
+type CheckResult struct {
+	Status CheckStatus `json:"status"`
+	Detail string      `json:"detail,omitempty"`
+}
+
+// HealthCheck reports the status of a part of the server. It should return
+// before the context is done.
+type HealthCheck func(ctx context.Context) CheckResult
+
+// HealthReport is the result of every check, served as JSON. The status is
+// the worst status of the checks.
+type HealthReport struct {
+	Status CheckStatus            `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// Live returns whether the server is working, even if it is not ready.
+func (r *HealthReport) Live() bool {
+	return r.Status != StatusFailed
+}
+
+// Ready returns whether the server is ready for players.
+func (r *HealthReport) Ready() bool {
+	return r.Status == StatusOK
+}
+
+// Health is the set of checks served at /healthz and /readyz.
+type Health struct {
+	mu     sync.Mutex
+	names  []string
+	checks []HealthCheck
+}
+
+// Add adds a named check.
+func (h *Health) Add(name string, check HealthCheck) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.names = append(h.names, name)
+	h.checks = append(h.checks, check)
+}
+
+// Check runs the checks concurrently.
+func (h *Health) Check(ctx context.Context) *HealthReport {
+	h.mu.Lock()
+	names, checks := h.names, h.checks
+	h.mu.Unlock()
+
+	results := make([]CheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func(i int, check HealthCheck) {
+			defer wg.Done()
+			results[i] = check(ctx)
+		}(i, check)
+	}
+	wg.Wait()
+
+	r := &HealthReport{Status: StatusOK, Checks: make(map[string]CheckResult)}
+	for i, result := range results {
+		r.Checks[names[i]] = result
+		switch {
+		case result.Status == StatusFailed:
+			r.Status = StatusFailed
+		case result.Status != StatusOK && r.Status == StatusOK:
+			r.Status = StatusWaiting
+		}
+	}
+	return r
+}
+
+func isHealthPath(c echo.Context) bool {
+	path := c.Request().URL.Path
+	return path == "/healthz" || path == "/readyz"
+}
+
+func registerHealth(e *echo.Echo, h *Health) {
+	serve := func(ok func(*HealthReport) bool) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			ctx, cancel := context.WithTimeout(c.Request().Context(), healthTimeout)
+			defer cancel()
+			r := h.Check(ctx)
+			if !ok(r) {
+				return c.JSON(http.StatusServiceUnavailable, r)
+			}
+			return c.JSON(http.StatusOK, r)
+		}
+	}
+	e.GET("/healthz", serve((*HealthReport).Live))
+	e.GET("/readyz", serve((*HealthReport).Ready))
+}
@@ -0,0 +1,86 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func result(status CheckStatus) HealthCheck {
+	return func(ctx context.Context) CheckResult {
+		return CheckResult{Status: status}
+	}
+}
+
+func TestHealth(t *testing.T) {
+	cases := []struct {
+		name    string
+		checks  []CheckStatus
+		healthz int
+		readyz  int
+	}{
+		{"ok", []CheckStatus{StatusOK, StatusOK}, http.StatusOK, http.StatusOK},
+		{"downloading", []CheckStatus{StatusWaiting, StatusOK}, http.StatusOK, http.StatusServiceUnavailable},
+		{"exited", []CheckStatus{StatusWaiting, StatusFailed}, http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+		{"none", nil, http.StatusOK, http.StatusOK},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			h := &Health{}
+			for i, status := range c.checks {
+				h.Add(string(rune('a'+i)), result(status))
+			}
+			e := echo.New()
+			registerHealth(e, h)
+			for path, expected := range map[string]int{"/healthz": c.healthz, "/readyz": c.readyz} {
+				rec := httptest.NewRecorder()
+				e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+				if rec.Code != expected {
+					t.Errorf("client: %s: expected %d, received %d", path, expected, rec.Code)
+				}
+				var r HealthReport
+				if err := json.Unmarshal(rec.Body.Bytes(), &r); err != nil {
+					t.Fatal(err)
+				}
+				if len(r.Checks) != len(c.checks) {
+					t.Errorf("client: %s: expected %d checks, received %+v", path, len(c.checks), r)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckListener(t *testing.T) {
+	backend, _ := echoBackend(t)
+	defer backend.Close()
+
+	s := &Server{ServerAddr: backend.LocalAddr().String(), Handler: http.NotFoundHandler()}
+	if r := s.CheckListener(context.Background()); r.Status != StatusWaiting {
+		t.Fatalf("client: expected waiting before serving, received %+v", r)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		s.Serve(l)
+		close(done)
+	}()
+	defer l.Close()
+	for s.CheckListener(context.Background()).Status != StatusOK {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	l.Close()
+	<-done
+	if r := s.CheckListener(context.Background()); r.Status != StatusFailed {
+		t.Fatalf("client: expected failed after the listener closed, received %+v", r)
+	}
+}
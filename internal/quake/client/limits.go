@@ -0,0 +1,213 @@
+package client
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var limitedSessions = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "quake_proxy_limited_sessions",
+	Help: "Websocket sessions refused or closed for exceeding a limit, by limit",
+}, []string{"limit"})
+
+// Limits bound the resources used by clients of the websocket proxy. A limit
+// of zero is unlimited.
+type Limits struct {
+	// SessionsPerIP is the number of concurrent sessions from an address.
+	SessionsPerIP int
+
+	// ConnectionsPerMinute is the number of new sessions from an address in a
+	// minute, which may all be made at once.
+	ConnectionsPerMinute int
+
+	// PacketsPerSecond and BytesPerSecond limit the packets sent by each
+	// session to the dedicated server, allowing bursts of up to a second.
+	PacketsPerSecond int
+	BytesPerSecond   int
+}
+
+// DefaultLimits allow several players from the same address, such as a
+// household behind NAT, and comfortably more than a client sends at the
+// maximum cl_maxpackets and rate.
+var DefaultLimits = Limits{
+	SessionsPerIP:        8,
+	ConnectionsPerMinute: 30,
+	PacketsPerSecond:     250,
+	BytesPerSecond:       256 * 1024,
+}
+
+// LimitError is the reason a session was refused or closed, which is sent to
+// the client in the close message.
+type LimitError struct {
+	// Limit is the name of the limit in metrics.
+	Limit string
+
+	// Code is the websocket close code.
+	Code int
+	Text string
+}
+
+func (e *LimitError) Error() string {
+	return e.Text
+}
+
+var (
+	errTooManySessions = &LimitError{
+		Limit: "sessions_per_ip",
+		Code:  websocket.CloseTryAgainLater,
+		Text:  "too many sessions from this address",
+	}
+	errConnectionRate = &LimitError{
+		Limit: "connections_per_minute",
+		Code:  websocket.CloseTryAgainLater,
+		Text:  "too many new connections from this address",
+	}
+	errPacketRate = &LimitError{
+		Limit: "packets_per_second",
+		Code:  websocket.ClosePolicyViolation,
+		Text:  "packet rate limit exceeded",
+	}
+	errByteRate = &LimitError{
+		Limit: "bytes_per_second",
+		Code:  websocket.ClosePolicyViolation,
+		Text:  "byte rate limit exceeded",
+	}
+)
+
+// tokenBucket allows rate events per second on average, with bursts of up to
+// burst events.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst float64, now time.Time) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: now}
+}
+
+func (b *tokenBucket) fill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// take removes n tokens, returning false when there are not enough.
+func (b *tokenBucket) take(n float64, now time.Time) bool {
+	b.fill(now)
+	if b.tokens < n {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+func (b *tokenBucket) full(now time.Time) bool {
+	b.fill(now)
+	return b.tokens >= b.burst
+}
+
+type addrLimits struct {
+	sessions    int
+	connections *tokenBucket
+}
+
+// ipLimiter tracks the sessions and new connections of each address. The zero
+// value is ready to use.
+type ipLimiter struct {
+	mu        sync.Mutex
+	addrs     map[string]*addrLimits
+	lastSweep time.Time
+}
+
+// acquire reserves a session for the address, which must be released when
+// the session ends.
+func (l *ipLimiter) acquire(ip net.IP, limits Limits, now time.Time) *LimitError {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.addrs == nil {
+		l.addrs = make(map[string]*addrLimits)
+	}
+	if now.Sub(l.lastSweep) > time.Minute {
+		l.sweep(now)
+	}
+	key := ip.String()
+	a, ok := l.addrs[key]
+	if !ok {
+		a = &addrLimits{}
+		l.addrs[key] = a
+	}
+	if limits.SessionsPerIP > 0 && a.sessions >= limits.SessionsPerIP {
+		return errTooManySessions
+	}
+	if limits.ConnectionsPerMinute > 0 {
+		if a.connections == nil {
+			n := float64(limits.ConnectionsPerMinute)
+			a.connections = newTokenBucket(n/60, n, now)
+		}
+		if !a.connections.take(1, now) {
+			return errConnectionRate
+		}
+	}
+	a.sessions++
+	return nil
+}
+
+func (l *ipLimiter) release(ip net.IP) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if a, ok := l.addrs[ip.String()]; ok {
+		a.sessions--
+	}
+}
+
+// sweep forgets addresses without sessions once they could connect again at
+// the full rate.
+func (l *ipLimiter) sweep(now time.Time) {
+	for key, a := range l.addrs {
+		if a.sessions == 0 && (a.connections == nil || a.connections.full(now)) {
+			delete(l.addrs, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// sessionLimiter limits the packets sent by a single session. It is only used
+// by the goroutine reading from the websocket, so is not safe for concurrent
+// use.
+type sessionLimiter struct {
+	packets *tokenBucket
+	bytes   *tokenBucket
+}
+
+func newSessionLimiter(limits Limits, now time.Time) *sessionLimiter {
+	l := &sessionLimiter{}
+	if limits.PacketsPerSecond > 0 {
+		n := float64(limits.PacketsPerSecond)
+		l.packets = newTokenBucket(n, n, now)
+	}
+	if limits.BytesPerSecond > 0 {
+		n := float64(limits.BytesPerSecond)
+		l.bytes = newTokenBucket(n, n, now)
+	}
+	return l
+}
+
+func (l *sessionLimiter) allow(size int, now time.Time) error {
+	if l.packets != nil && !l.packets.take(1, now) {
+		return errPacketRate
+	}
+	if l.bytes != nil && !l.bytes.take(float64(size), now) {
+		return errByteRate
+	}
+	return nil
+}
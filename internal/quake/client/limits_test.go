@@ -0,0 +1,65 @@
+package client
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestIPLimiter(t *testing.T) {
+	var l ipLimiter
+	limits := Limits{SessionsPerIP: 2, ConnectionsPerMinute: 3}
+	ip := net.ParseIP("203.0.113.7")
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if err := l.acquire(ip, limits, now); err != nil {
+			t.Fatalf("client: session %d refused: %v", i, err)
+		}
+	}
+	if err := l.acquire(ip, limits, now); err != errTooManySessions {
+		t.Fatalf("client: expected too many sessions, received %v", err)
+	}
+	if err := l.acquire(net.ParseIP("203.0.113.8"), limits, now); err != nil {
+		t.Fatalf("client: other address refused: %v", err)
+	}
+
+	l.release(ip)
+	if err := l.acquire(ip, limits, now); err != nil {
+		t.Fatalf("client: session refused after release: %v", err)
+	}
+	l.release(ip)
+	if err := l.acquire(ip, limits, now); err != errConnectionRate {
+		t.Fatalf("client: expected connection rate limit, received %v", err)
+	}
+	if err := l.acquire(ip, limits, now.Add(20*time.Second)); err != nil {
+		t.Fatalf("client: session refused after refill: %v", err)
+	}
+
+	l.release(ip)
+	l.release(ip)
+	l.sweep(now.Add(2 * time.Minute))
+	if _, ok := l.addrs[ip.String()]; ok {
+		t.Error("client: expected idle address to be forgotten")
+	}
+}
+
+func TestSessionLimiter(t *testing.T) {
+	now := time.Now()
+	l := newSessionLimiter(Limits{PacketsPerSecond: 10, BytesPerSecond: 1000}, now)
+	for i := 0; i < 10; i++ {
+		if err := l.allow(50, now); err != nil {
+			t.Fatalf("client: packet %d refused: %v", i, err)
+		}
+	}
+	if err := l.allow(50, now); err != errPacketRate {
+		t.Fatalf("client: expected packet rate limit, received %v", err)
+	}
+	now = now.Add(time.Second)
+	if err := l.allow(1001, now); err != errByteRate {
+		t.Fatalf("client: expected byte rate limit, received %v", err)
+	}
+	if err := l.allow(1000, now); err != nil {
+		t.Fatalf("client: packet refused after refill: %v", err)
+	}
+}
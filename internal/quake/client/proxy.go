@@ -4,17 +4,82 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"log"
+	"io"
 	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/pion/webrtc/v2"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+
+	"github.com/criticalstack/quake-kube/internal/quake/bans"
+)
+
+var (
+	activeSessions = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "quake_proxy_active_sessions",
+		Help: "The current number of websocket sessions",
+	})
+
+	openedSessions = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "quake_proxy_sessions_opened",
+		Help: "Websocket sessions opened",
+	})
+
+	closedSessions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "quake_proxy_sessions_closed",
+		Help: "Websocket sessions closed, by close code",
+	}, []string{"code"})
+
+	proxyPackets = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "quake_proxy_packets",
+		Help: "Packets forwarded by the proxy, by direction",
+	}, []string{"direction"})
+
+	proxyBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "quake_proxy_bytes",
+		Help: "Bytes forwarded by the proxy, by direction",
+	}, []string{"direction"})
+
+	backendWriteErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "quake_proxy_backend_write_errors",
+		Help: "Errors writing packets to the dedicated server",
+	})
+
+	sessionDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "quake_proxy_session_duration_seconds",
+		Help:    "Duration of websocket sessions",
+		Buckets: prometheus.ExponentialBuckets(10, 3, 8),
+	})
+
+	websocketWriteLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "quake_proxy_websocket_write_seconds",
+		Help:    "Time taken to write a packet to a websocket",
+		Buckets: prometheus.ExponentialBuckets(0.0001, 4, 8),
+	})
+
+	packetsFromClient = proxyPackets.WithLabelValues("client_to_server")
+	packetsToClient   = proxyPackets.WithLabelValues("server_to_client")
+	bytesFromClient   = proxyBytes.WithLabelValues("client_to_server")
+	bytesToClient     = proxyBytes.WithLabelValues("server_to_client")
 )
 
+// DefaultUpgrader shares write buffers between connections, which only hold
+// one while writing a packet. The write buffer fits the largest packet, so
+// that each packet is written with a single frame.
 var DefaultUpgrader = &websocket.Upgrader{
 	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
+	WriteBufferSize: maxPacketSize,
+	WriteBufferPool: &sync.Pool{},
 	CheckOrigin: func(r *http.Request) bool {
 		return true
 	},
@@ -23,21 +88,94 @@ var DefaultUpgrader = &websocket.Upgrader{
 type WebsocketUDPProxy struct {
 	Upgrader *websocket.Upgrader
 
-	addr net.Addr
+	// Bans are checked before a client is upgraded. CloseBanned must be
+	// registered with the list for changes to apply to connected clients.
+	Bans *bans.List
+
+	// Sessions is the table of connected clients.
+	Sessions *Sessions
+
+	// ClientIP returns the address of the client, which defaults to the
+	// remote address of the connection.
+	ClientIP echo.IPExtractor
+
+	// Limits bound the sessions and packets of each client, the zero value
+	// is unlimited.
+	Limits Limits
+
+	// ResumeGrace is how long a session is kept after the connection of a
+	// client that can resume sessions drops. Sessions are not resumed when
+	// it is zero.
+	ResumeGrace time.Duration
+
+	// Backends route each client to a dedicated server, when set. Clients
+	// that do not select a backend are sent to the address given to
+	// NewProxy, or refused when there is none.
+	Backends *Backends
+
+	// Log records sessions, with the session id and address of the client.
+	Log *zap.Logger
+
+	addr    *net.UDPAddr
+	limiter ipLimiter
+	rtc     *webrtc.API
+
+	// ctx is cancelled by Close, ending the sessions of every transport.
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
+// maxPacketSize is the largest packet sent by the game, MAX_MSGLEN in
+// ioquake3.
+const maxPacketSize = 16384
+
+// packetPool holds the buffers that packets are read into.
+var packetPool = sync.Pool{
+	New: func() interface{} {
+		return new([maxPacketSize]byte)
+	},
+}
+
+var connectResponsePrefix = []byte("\xff\xff\xff\xffconnectResponse")
+
+// NewProxy returns a proxy to the dedicated server at addr, which may be empty
+// when clients are routed with Backends.
 func NewProxy(addr string) (*WebsocketUDPProxy, error) {
-	raddr, err := net.ResolveUDPAddr("udp", addr)
-	if err != nil {
-		return nil, err
+	w := &WebsocketUDPProxy{Sessions: &Sessions{}, Log: zap.L().Named("proxy")}
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+	if addr != "" {
+		raddr, err := net.ResolveUDPAddr("udp", addr)
+		if err != nil {
+			return nil, err
+		}
+		w.addr = raddr
 	}
-	return &WebsocketUDPProxy{addr: raddr}, nil
+	return w, nil
 }
 
 func (w *WebsocketUDPProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
-	ctx, cancel := context.WithCancel(req.Context())
+	if isRTCPath(req.URL.Path) && !websocket.IsWebSocketUpgrade(req) {
+		w.ServeRTC(rw, req)
+		return
+	}
+	if w.ctx.Err() != nil {
+		http.Error(rw, errProxyClosed.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	// the request context is not cancelled when a hijacked connection is
+	// closed, so sessions only end with the proxy
+	ctx, cancel := context.WithCancel(w.ctx)
 	defer cancel()
 
+	ip, remoteAddr := w.remoteAddr(req)
+	log := w.Log.With(zap.String("remote_addr", remoteAddr))
+	if err := w.Bans.Check(ip); err != nil {
+		log.Info("refused", zap.Error(err))
+		http.Error(rw, err.Error(), http.StatusForbidden)
+		return
+	}
+
 	upgrader := w.Upgrader
 	if w.Upgrader == nil {
 		upgrader = DefaultUpgrader
@@ -46,71 +184,481 @@ func (w *WebsocketUDPProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request)
 	if hdr := req.Header.Get("Sec-Websocket-Protocol"); hdr != "" {
 		upgradeHeader.Set("Sec-Websocket-Protocol", hdr)
 	}
+
+	// a resumed session keeps its backend and the address limits taken by
+	// the original connection
+	token, resumable := resumeToken(req)
+	if token != "" {
+		sess, ok := w.Sessions.byToken(token)
+		if !ok {
+			http.Error(rw, ErrUnknownSession.Error(), http.StatusNotFound)
+			return
+		}
+		ws, err := upgrader.Upgrade(rw, req, upgradeHeader)
+		if err != nil {
+			log.Debug("cannot upgrade", zap.Error(err))
+			return
+		}
+		r := &resumption{conn: newWSConn(ws), remoteAddr: remoteAddr, ip: ip, transport: "websocket"}
+		if !sess.resume(r) {
+			r.conn.Close(websocket.CloseGoingAway, ErrUnknownSession.Error())
+		}
+		return
+	}
+
+	backend, addr, err := w.backend(req, req.URL.Path)
+	if err != nil {
+		log.Info("refused", zap.Error(err))
+		http.Error(rw, err.Error(), backendStatus(err))
+		return
+	}
+	limitErr := w.limiter.acquire(ip, w.Limits, time.Now())
+	if limitErr == nil {
+		defer w.limiter.release(ip)
+	}
 	ws, err := upgrader.Upgrade(rw, req, upgradeHeader)
 	if err != nil {
-		log.Printf("wsproxy: couldn't upgrade %v", err)
+		log.Debug("cannot upgrade", zap.Error(err))
 		return
 	}
 	defer ws.Close()
 
-	backend, err := net.ListenPacket("udp", "0.0.0.0:0")
+	// the connection is upgraded before being refused so that the client
+	// receives the close code
+	if limitErr != nil {
+		closeLimited(newWSConn(ws), log, limitErr)
+		return
+	}
+	w.serve(ctx, newWSConn(ws), &Session{
+		RemoteAddr: remoteAddr,
+		IP:         ip,
+		Transport:  "websocket",
+		Backend:    backend,
+		listenPort: listenPort(req),
+	}, addr, resumable)
+}
+
+// backend returns the name and address of the dedicated server for the
+// request.
+func (w *WebsocketUDPProxy) backend(req *http.Request, path string) (string, *net.UDPAddr, error) {
+	if w.Backends != nil {
+		b, err := w.Backends.Route(req, path)
+		if err != nil {
+			return "", nil, err
+		}
+		if b != nil {
+			addr, err := net.ResolveUDPAddr("udp", b.Addr)
+			if err != nil {
+				return "", nil, errors.Wrapf(err, "server %q", b.Name)
+			}
+			return b.Name, addr, nil
+		}
+	}
+	if w.addr == nil {
+		return "", nil, ErrUnknownBackend
+	}
+	return "", w.addr, nil
+}
+
+func backendStatus(err error) int {
+	if err == ErrUnknownBackend {
+		return http.StatusNotFound
+	}
+	return http.StatusBadGateway
+}
+
+// clientConn is the connection to a browser client, which carries a game
+// packet per message.
+type clientConn interface {
+	// ReadPacket returns the next packet from the client, which is only
+	// valid until the next call.
+	ReadPacket() ([]byte, error)
+	WritePacket(b []byte) error
+
+	// Close ends the connection, telling the client the websocket close code
+	// and reason.
+	Close(code int, text string) error
+}
+
+// wsConn is a client connected with a websocket.
+type wsConn struct {
+	*websocket.Conn
+
+	// buf holds the last packet read, and is only taken from the pool while
+	// there is one, so that idle clients do not hold a buffer.
+	buf *[maxPacketSize]byte
+}
+
+func newWSConn(ws *websocket.Conn) *wsConn {
+	// messages larger than any packet are refused with 1009 (message too big)
+	ws.SetReadLimit(maxPacketSize)
+	return &wsConn{Conn: ws}
+}
+
+func (c *wsConn) ReadPacket() ([]byte, error) {
+	c.release()
+	msg, err := c.read()
+	if err != nil {
+		c.release()
+		// reply to the close message of the client
+		m := websocket.FormatCloseMessage(websocket.CloseNormalClosure, fmt.Sprintf("%v", err))
+		if e, ok := err.(*websocket.CloseError); ok {
+			if e.Code != websocket.CloseNoStatusReceived {
+				m = websocket.FormatCloseMessage(e.Code, e.Text)
+			}
+		}
+		c.WriteControl(websocket.CloseMessage, m, time.Now().Add(time.Second))
+	}
+	return msg, err
+}
+
+// read reads the next message into a buffer from the pool, rather than
+// allocating one for each message as ReadMessage does.
+func (c *wsConn) read() ([]byte, error) {
+	_, r, err := c.NextReader()
+	if err != nil {
+		return nil, err
+	}
+	c.buf = packetPool.Get().(*[maxPacketSize]byte)
+	n, err := io.ReadFull(r, c.buf[:])
+	if err == nil {
+		// a full buffer is only a packet when the message ends there, the
+		// read limit is exceeded otherwise
+		var b [1]byte
+		if _, err = r.Read(b[:]); err == nil {
+			err = websocket.ErrReadLimit
+		}
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return c.buf[:n], nil
+	}
+	return nil, err
+}
+
+func (c *wsConn) release() {
+	if c.buf != nil {
+		packetPool.Put(c.buf)
+		c.buf = nil
+	}
+}
+
+func (c *wsConn) WritePacket(b []byte) error {
+	return c.WriteMessage(websocket.BinaryMessage, b)
+}
+
+func (c *wsConn) Close(code int, text string) error {
+	// abnormal closure is never sent, it is what the client sees when the
+	// connection is dropped
+	if code != websocket.CloseAbnormalClosure {
+		m := websocket.FormatCloseMessage(code, text)
+		c.WriteControl(websocket.CloseMessage, m, time.Now().Add(time.Second))
+	}
+	return c.Conn.Close()
+}
+
+// serve forwards packets between the client of the session and the dedicated
+// server at addr until either side fails or the context is done. When the
+// client can resume sessions, and its connection drops, the backend socket is
+// kept open for ResumeGrace waiting for the client to reconnect.
+func (w *WebsocketUDPProxy) serve(ctx context.Context, conn clientConn, sess *Session, addr *net.UDPAddr, resumable bool) {
+	remoteAddr := sess.RemoteAddr
+	log := w.Log.With(zap.String("remote_addr", remoteAddr))
+	// the socket is connected to the dedicated server, so that packets from
+	// any other address are dropped, and reading a packet does not allocate
+	// the address of the sender
+	backend, err := net.DialUDP("udp", nil, addr)
 	if err != nil {
+		log.Error("cannot connect to server", zap.Stringer("server", addr), zap.Error(err))
+		conn.Close(websocket.CloseInternalServerErr, "")
 		return
 	}
 	defer backend.Close()
 
-	errc := make(chan error, 1)
+	if resumable && w.ResumeGrace > 0 {
+		if sess.token, err = newResumeToken(); err != nil {
+			log.Error("cannot create resume token", zap.Error(err))
+		}
+	}
+	sess.Connected = time.Now()
+	sess.BackendPort = backend.LocalAddr().(*net.UDPAddr).Port
+	sess.conn = &sessionConn{}
+	sess.counters = &sessionCounters{}
+	sess.resumed = make(chan *resumption)
+	sess.done = make(chan struct{})
+	w.Sessions.add(sess)
+	activeSessions.Inc()
+	openedSessions.Inc()
+
+	// the address of the client changes when it resumes the session
+	sessLog := w.Log.With(zap.Uint64("session", sess.ID), zap.String("transport", sess.Transport))
+	if sess.Backend != "" {
+		sessLog = sessLog.With(zap.String("backend", sess.Backend))
+	}
+	log = sessLog.With(zap.String("remote_addr", remoteAddr))
+	log.Debug("opened session", zap.Int("backend_port", sess.BackendPort))
+	defer func() {
+		close(sess.done)
+		w.Sessions.remove(sess)
+		activeSessions.Dec()
+		sessionDuration.Observe(time.Since(sess.Connected).Seconds())
+		closedSessions.WithLabelValues(strconv.Itoa(sess.counters.closeCode())).Inc()
+	}()
+
+	backendErr := make(chan error, 1)
 
 	go func() {
+		buffer := packetPool.Get().(*[maxPacketSize]byte)
+		defer packetPool.Put(buffer)
 		for {
-			_, msg, err := ws.ReadMessage()
+			n, err := backend.Read(buffer[:])
+			if isRefused(err) {
+				continue
+			}
 			if err != nil {
-				m := websocket.FormatCloseMessage(websocket.CloseNormalClosure, fmt.Sprintf("%v", err))
-				if e, ok := err.(*websocket.CloseError); ok {
-					if e.Code != websocket.CloseNoStatusReceived {
-						m = websocket.FormatCloseMessage(e.Code, e.Text)
-					}
-				}
-				errc <- err
-				ws.WriteMessage(websocket.CloseMessage, m)
+				backendErr <- &backendError{err}
 				return
 			}
-			if bytes.HasPrefix(msg, []byte("\xff\xff\xff\xffport")) {
-				continue
+			if bytes.HasPrefix(buffer[:n], connectResponsePrefix) {
+				// the client has been given a slot
+				w.Sessions.refreshSoon()
 			}
-			if err := backend.SetWriteDeadline(time.Now().Add(5 * time.Second)); err != nil {
-				errc <- err
-				return
+			conn := sess.conn.get()
+			if conn == nil {
+				// lost while waiting for the client to resume, as it would
+				// have been in the network
+				continue
 			}
-			_, err = backend.WriteTo(msg, w.addr)
-			if err != nil {
-				errc <- err
-				return
+			start := time.Now()
+			if err := conn.WritePacket(buffer[:n]); err != nil {
+				// the connection has failed, which is noticed by its reader
+				conn.Close(websocket.CloseAbnormalClosure, "")
+				continue
 			}
+			latency := time.Since(start)
+			sess.counters.toClient(n, start, latency)
+			websocketWriteLatency.Observe(latency.Seconds())
+			packetsToClient.Inc()
+			bytesToClient.Add(float64(n))
 		}
 	}()
 
-	go func() {
-		buffer := make([]byte, 1024*1024)
-		for {
-			n, _, err := backend.ReadFrom(buffer)
-			if err != nil {
-				errc <- err
-				return
-			}
-			if err := ws.WriteMessage(websocket.BinaryMessage, buffer[:n]); err != nil {
-				errc <- err
-				return
+	// QuakeJS peers start by sending the port they listen on, which is only
+	// sent once as the client does not expect it again after resuming
+	if sess.listenPort != 0 {
+		conn.WritePacket(portMessage(sess.listenPort))
+	}
+	for {
+		// the connection is written to by the backend reader once it is set
+		if sess.token != "" {
+			conn.WritePacket(resumeMessage(sess.token))
+		}
+		sess.conn.set(conn)
+		readErr := make(chan error, 1)
+		go func(conn clientConn, log *zap.Logger) {
+			readErr <- w.readClient(conn, log, sess, backend)
+		}(conn, log)
+
+		select {
+		case err = <-readErr:
+		case err = <-backendErr:
+		case r := <-sess.resumed:
+			// the client reconnected before the old connection was noticed
+			// to be gone
+			conn.Close(websocket.CloseGoingAway, "resumed")
+			conn = r.conn
+			log = sessLog.With(zap.String("remote_addr", r.remoteAddr))
+			w.Sessions.resumed(sess, r)
+			log.Info("resumed session")
+			continue
+		case <-ctx.Done():
+			sess.counters.setCloseCode(websocket.CloseGoingAway)
+			conn.Close(websocket.CloseGoingAway, "")
+			return
+		}
+
+		if sess.token != "" && !sess.counters.closing() && isResumable(err) {
+			sess.conn.set(nil)
+			conn.Close(websocket.CloseAbnormalClosure, "")
+			log.Info("waiting to resume session", zap.Duration("grace", w.ResumeGrace), zap.Error(err))
+			if r, ok := w.waitResume(ctx, sess, backendErr); ok {
+				conn = r.conn
+				log = sessLog.With(zap.String("remote_addr", r.remoteAddr))
+				w.Sessions.resumed(sess, r)
+				log.Info("resumed session")
+				continue
 			}
 		}
-	}()
 
-	select {
-	case err = <-errc:
+		code := closeCode(err)
+		sess.counters.setCloseCode(code)
+		if e, ok := err.(*LimitError); ok {
+			closeLimited(conn, log, e)
+			return
+		}
 		if e, ok := err.(*websocket.CloseError); !ok || e.Code == websocket.CloseAbnormalClosure {
-			log.Printf("wsproxy: %v", err)
+			log.Info("closed session", zap.Int("code", code), zap.Error(err))
+		} else {
+			log.Debug("closed session", zap.Int("code", code))
 		}
-	case <-ctx.Done():
+		conn.Close(code, "")
 		return
 	}
 }
+
+// waitResume waits for the client to resume the session, returning false when
+// the grace period ends first.
+func (w *WebsocketUDPProxy) waitResume(ctx context.Context, sess *Session, backendErr <-chan error) (*resumption, bool) {
+	timer := time.NewTimer(w.ResumeGrace)
+	defer timer.Stop()
+	select {
+	case r := <-sess.resumed:
+		return r, true
+	case <-timer.C:
+	case <-backendErr:
+	case <-ctx.Done():
+	}
+	return nil, false
+}
+
+// readClient forwards packets from the client to the dedicated server until
+// the connection fails.
+func (w *WebsocketUDPProxy) readClient(conn clientConn, log *zap.Logger, sess *Session, backend *net.UDPConn) error {
+	limiter := newSessionLimiter(w.Limits, time.Now())
+	first, logged := true, false
+	for {
+		msg, err := conn.ReadPacket()
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		sess.counters.fromClient(len(msg), now)
+		packetsFromClient.Inc()
+		bytesFromClient.Add(float64(len(msg)))
+		if err := limiter.allow(len(msg), now); err != nil {
+			return err
+		}
+		// only the first message of QuakeJS clients is a port message
+		if port, ok := parsePortMessage(msg); ok && first {
+			first = false
+			w.Sessions.setClientPort(sess, port)
+			continue
+		}
+		first = false
+		if cmd, ok := connectionlessCommand(msg); ok && !isClientCommand(cmd) {
+			sess.counters.droppedFromClient()
+			if bytes.Equal(cmd, portCommand) {
+				droppedPort.Inc()
+			} else {
+				droppedUnknown.Inc()
+			}
+			// the rest are counted without logging, so that a client cannot
+			// flood the log
+			if !logged {
+				logged = true
+				log.Info("dropped connectionless packet", zap.ByteString("packet", truncate(msg, 32)))
+			}
+			continue
+		}
+		if err := backend.SetWriteDeadline(time.Now().Add(5 * time.Second)); err != nil {
+			return err
+		}
+		if _, err := backend.Write(msg); isRefused(err) {
+			// lost, as it would have been with an unconnected socket
+			continue
+		} else if err != nil {
+			sess.counters.backendWriteError()
+			backendWriteErrors.Inc()
+			return &backendError{err}
+		}
+	}
+}
+
+// backendError is an error reading from or writing to the dedicated server.
+type backendError struct {
+	err error
+}
+
+func (e *backendError) Error() string {
+	return fmt.Sprintf("backend: %v", e.err)
+}
+
+// isRefused reports whether the error is the ICMP port unreachable sent while
+// the dedicated server is not listening, such as when it restarts, which is
+// only reported for connected sockets.
+func isRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// closeCode returns the websocket close code for the error that ended a
+// session.
+func closeCode(err error) int {
+	if err == websocket.ErrReadLimit {
+		return websocket.CloseMessageTooBig
+	}
+	switch e := err.(type) {
+	case *websocket.CloseError:
+		return e.Code
+	case *LimitError:
+		return e.Code
+	case *backendError:
+		return websocket.CloseInternalServerErr
+	}
+	return websocket.CloseAbnormalClosure
+}
+
+// closeLimited closes a session that is over one of the limits.
+func closeLimited(conn clientConn, log *zap.Logger, e *LimitError) {
+	limitedSessions.WithLabelValues(e.Limit).Inc()
+	log.Info("closing session", zap.String("limit", e.Limit), zap.Error(e))
+	conn.Close(e.Code, e.Text)
+}
+
+// errProxyClosed refuses clients that connect after the proxy is closed.
+var errProxyClosed = errors.New("proxy closed")
+
+// Close closes the connections of all clients, websocket and WebRTC, and
+// refuses any that connect after.
+func (w *WebsocketUDPProxy) Close() {
+	w.cancel()
+}
+
+// CloseBanned closes the connections of any clients that are no longer
+// allowed by the ban and allow lists.
+func (w *WebsocketUDPProxy) CloseBanned() {
+	w.Sessions.each(func(s *Session) {
+		err := w.Bans.Check(s.IP)
+		if err == nil {
+			return
+		}
+		w.Log.Info("closing session",
+			zap.Uint64("session", s.ID),
+			zap.String("remote_addr", s.RemoteAddr),
+			zap.Error(err),
+		)
+		s.counters.setCloseCode(websocket.ClosePolicyViolation)
+		if conn := s.conn.get(); conn != nil {
+			conn.Close(websocket.ClosePolicyViolation, err.Error())
+		}
+	})
+}
+
+// remoteAddr returns the address of the client that sent the request. The
+// port is only known when the client is connected directly, or through a load
+// balancer using the PROXY protocol.
+func (w *WebsocketUDPProxy) remoteAddr(req *http.Request) (net.IP, string) {
+	clientIP := w.ClientIP
+	if clientIP == nil {
+		clientIP = echo.ExtractIPDirect()
+	}
+	ip := net.ParseIP(clientIP(req))
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil && ip.Equal(net.ParseIP(host)) {
+		return ip, req.RemoteAddr
+	}
+	return ip, ip.String()
+}
+
+// isRTCPath reports whether the path is for WebRTC signalling, which is /rtc
+// optionally followed by the path that selects a backend.
+func isRTCPath(path string) bool {
+	return path == "/rtc" || strings.HasPrefix(path, "/rtc/")
+}
@@ -0,0 +1,385 @@
+package client
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// benchClient is a websocket client that reads packets into its own buffer.
+// It still allocates in the same process as the proxy, which TestProxyAllocs
+// measures against benchEcho.
+type benchClient struct {
+	ws  *websocket.Conn
+	msg []byte
+	buf []byte
+}
+
+func dialBenchClient(tb testing.TB, url string, size int) *benchClient {
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	// the proxy starts by sending its port
+	if _, _, err := ws.ReadMessage(); err != nil {
+		tb.Fatal(err)
+	}
+	// sequenced packets, rather than connectionless packets which are checked
+	// by the proxy
+	return &benchClient{ws: ws, msg: make([]byte, size), buf: make([]byte, size)}
+}
+
+// roundTrip sends a packet through the proxy to the echo backend, and reads
+// it back.
+func (c *benchClient) roundTrip() error {
+	if err := c.ws.WriteMessage(websocket.BinaryMessage, c.msg); err != nil {
+		return err
+	}
+	_, r, err := c.ws.NextReader()
+	if err != nil {
+		return err
+	}
+	_, err = io.ReadFull(r, c.buf[:len(c.msg)])
+	return err
+}
+
+func (c *benchClient) close() {
+	m := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	c.ws.WriteControl(websocket.CloseMessage, m, time.Now().Add(time.Second))
+	c.ws.Close()
+}
+
+// benchProxy starts a proxy to a backend that echoes packets without
+// allocating.
+func benchProxy(tb testing.TB) (string, func()) {
+	backend, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, maxPacketSize)
+		for {
+			n, addr, err := backend.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			backend.WriteToUDP(buf[:n], addr)
+		}
+	}()
+	p, err := NewProxy(backend.LocalAddr().String())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	srv := httptest.NewServer(p)
+	return "ws" + strings.TrimPrefix(srv.URL, "http"), func() {
+		srv.Close()
+		backend.Close()
+	}
+}
+
+func BenchmarkProxy(b *testing.B) {
+	for _, size := range []int{64, 1400, maxPacketSize} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			url, done := benchProxy(b)
+			defer done()
+			c := dialBenchClient(b, url, size)
+			defer c.close()
+
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := c.roundTrip(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkProxyParallel(b *testing.B) {
+	url, done := benchProxy(b)
+	defer done()
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		c := dialBenchClient(b, url, 1400)
+		defer c.close()
+		for pb.Next() {
+			if err := c.roundTrip(); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+// benchEcho starts a websocket server that echoes messages without the proxy,
+// reading them into buffers from the pool like the proxy does, to measure what the test
+// client and websocket server alone allocate.
+func benchEcho(tb testing.TB) (string, func()) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := DefaultUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		if err := ws.WriteMessage(websocket.BinaryMessage, portMessage(27960)); err != nil {
+			return
+		}
+		for {
+			_, r, err := ws.NextReader()
+			if err != nil {
+				return
+			}
+			buf := packetPool.Get().(*[maxPacketSize]byte)
+			n, err := io.ReadFull(r, buf[:])
+			if err == io.ErrUnexpectedEOF {
+				err = ws.WriteMessage(websocket.BinaryMessage, buf[:n])
+			}
+			packetPool.Put(buf)
+			if err != nil {
+				return
+			}
+		}
+	}))
+	return "ws" + strings.TrimPrefix(srv.URL, "http"), srv.Close
+}
+
+// allocStats are the memory held by each session, and allocated for each
+// packet sent and echoed back.
+type allocStats struct {
+	sessionBytes int64
+	packetAllocs float64
+	packetBytes  int64
+}
+
+// measureAllocs connects budgetSessions clients to the websocket server at
+// url, then sends budgetRoundTrips packets from each.
+func measureAllocs(t *testing.T, url string) allocStats {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	clients := make([]*benchClient, budgetSessions)
+	for i := range clients {
+		clients[i] = dialBenchClient(t, url, budgetPacketBytes)
+		defer clients[i].close()
+		if err := clients[i].roundTrip(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	var stats allocStats
+	stats.sessionBytes = (int64(after.HeapInuse+after.StackInuse) - int64(before.HeapInuse+before.StackInuse)) / budgetSessions
+
+	runtime.ReadMemStats(&before)
+	allocs := testing.AllocsPerRun(budgetRoundTrips, func() {
+		for _, c := range clients {
+			if err := c.roundTrip(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+	runtime.ReadMemStats(&after)
+
+	// AllocsPerRun makes an extra run to warm up
+	stats.packetAllocs = allocs / budgetSessions
+	stats.packetBytes = int64(after.TotalAlloc-before.TotalAlloc) / ((budgetRoundTrips + 1) * budgetSessions)
+	return stats
+}
+
+// The budgets are what the proxy and the echo backend add to a websocket echo
+// server, since the test client is in the same process. The backend allocates
+// the address of each sender.
+const (
+	maxSessionBytes   = 32 * 1024
+	maxPacketAllocs   = 3
+	maxPacketBytes    = 128
+	budgetSessions    = 50
+	budgetRoundTrips  = 20
+	budgetPacketBytes = 1400
+)
+
+func TestProxyAllocs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping allocation test in short mode")
+	}
+	if raceEnabled {
+		t.Skip("skipping allocation test with the race detector")
+	}
+	echoURL, echoDone := benchEcho(t)
+	baseline := measureAllocs(t, echoURL)
+	echoDone()
+	url, done := benchProxy(t)
+	defer done()
+	proxied := measureAllocs(t, url)
+
+	perSession := proxied.sessionBytes - baseline.sessionBytes
+	perPacket := proxied.packetAllocs - baseline.packetAllocs
+	bytesPerPacket := proxied.packetBytes - baseline.packetBytes
+	t.Logf("baseline %d bytes per session, %.1f allocations, %d bytes per packet", baseline.sessionBytes, baseline.packetAllocs, baseline.packetBytes)
+	t.Logf("proxy %d bytes per session, %.1f allocations, %d bytes per packet", perSession, perPacket, bytesPerPacket)
+	if perSession > maxSessionBytes {
+		t.Errorf("client: expected no more than %d bytes per session, received %d", maxSessionBytes, perSession)
+	}
+	if perPacket > maxPacketAllocs {
+		t.Errorf("client: expected no more than %d allocations per packet, received %.1f", maxPacketAllocs, perPacket)
+	}
+	if bytesPerPacket > maxPacketBytes {
+		t.Errorf("client: expected no more than %d bytes allocated per packet, received %d", maxPacketBytes, bytesPerPacket)
+	}
+}
+
+func TestProxyPacketTooBig(t *testing.T) {
+	url, done := benchProxy(t)
+	defer done()
+
+	closed := closedSessions.WithLabelValues("1009")
+	before := testutil.ToFloat64(closed)
+
+	// the close message may be lost to the reset of the connection, since
+	// the rest of the message is not read
+	c := dialBenchClient(t, url, maxPacketSize+1)
+	defer c.close()
+	if err := c.roundTrip(); err == nil {
+		t.Fatal("client: expected message larger than any packet to be refused")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(closed) == before {
+		if time.Now().After(deadline) {
+			t.Fatal("client: expected session to be closed with 1009")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestProxyBackendDown(t *testing.T) {
+	backend, _ := echoBackend(t)
+	addr := backend.LocalAddr().String()
+	p, err := NewProxy(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(p)
+	defer srv.Close()
+
+	c := dialBenchClient(t, "ws"+strings.TrimPrefix(srv.URL, "http"), 64)
+	defer c.close()
+	if err := c.roundTrip(); err != nil {
+		t.Fatal(err)
+	}
+
+	// packets sent while the dedicated server restarts are lost, rather than
+	// ending the session
+	backend.Close()
+	for i := 0; i < 3; i++ {
+		if err := c.ws.WriteMessage(websocket.BinaryMessage, c.msg); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	backend, err = net.ListenPacket("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	go func() {
+		buf := make([]byte, maxPacketSize)
+		for {
+			n, addr, err := backend.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			backend.WriteTo(buf[:n], addr)
+		}
+	}()
+	if err := c.ws.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.roundTrip(); err != nil {
+		t.Fatalf("client: expected session to survive the restart: %v", err)
+	}
+}
+
+func TestProxySessionStats(t *testing.T) {
+	backend, _ := echoBackend(t)
+	defer backend.Close()
+	p, err := NewProxy(backend.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(p)
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	e := echo.New()
+	registerAdminAPI(e, &Config{
+		Admin:    &AdminConfig{Token: "secret", Console: &fakeConsole{}},
+		Sessions: p.Sessions,
+	})
+	sessions := func() []Session {
+		req := httptest.NewRequest(http.MethodGet, "/api/sessions", nil)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer secret")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("client: expected %d, received %d", http.StatusOK, rec.Code)
+		}
+		var list []Session
+		if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+			t.Fatal(err)
+		}
+		return list
+	}
+
+	closed := closedSessions.WithLabelValues("1000")
+	before := testutil.ToFloat64(closed)
+
+	c := dialBenchClient(t, url, 64)
+	for i := 0; i < 3; i++ {
+		if err := c.roundTrip(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list := sessions()
+	if len(list) != 1 {
+		t.Fatalf("client: expected 1 session, received %d", len(list))
+	}
+	st := list[0].Stats
+	expected := SessionStats{PacketsFromClient: 3, BytesFromClient: 192, PacketsToClient: 3, BytesToClient: 192}
+	got := SessionStats{
+		PacketsFromClient: st.PacketsFromClient,
+		BytesFromClient:   st.BytesFromClient,
+		PacketsToClient:   st.PacketsToClient,
+		BytesToClient:     st.BytesToClient,
+	}
+	if diff := cmp.Diff(expected, got); diff != "" {
+		t.Errorf("client: session stats differ: (-want +got)\n%s", diff)
+	}
+	if st.LastFromClient == nil || st.LastFromServer == nil {
+		t.Errorf("client: expected the last packet times to be set, received %+v", st)
+	}
+
+	// a normal close from the client is counted with its close code
+	c.close()
+	deadline := time.Now().Add(5 * time.Second)
+	for testutil.ToFloat64(closed) == before || len(sessions()) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("client: expected session to be closed with 1000")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
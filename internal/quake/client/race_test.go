@@ -0,0 +1,8 @@
+//go:build race
+// +build race
+
+package client
+
+// raceEnabled is set when testing with the race detector, which makes
+// sync.Pool drop buffers at random.
+const raceEnabled = true
@@ -0,0 +1,130 @@
+package client
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var resumedSessions = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "quake_proxy_sessions_resumed",
+	Help: "Sessions resumed by a client after its connection dropped",
+})
+
+// ErrUnknownSession is returned for resume tokens that do not belong to a
+// session, such as one that has ended.
+var ErrUnknownSession = errors.New("unknown session")
+
+// resumePrefix starts the out-of-band packet that gives the client its resume
+// token, e.g. "\xff\xff\xff\xffresume 3f2a...".
+const resumePrefix = "\xff\xff\xff\xffresume "
+
+// resumeParam is the query parameter of a client that can resume sessions. It
+// is empty for a new session, and the resume token when reconnecting.
+const resumeParam = "resume"
+
+// resumeToken returns the resume token of the request, and whether the client
+// asked to resume sessions at all.
+func resumeToken(req *http.Request) (string, bool) {
+	v, ok := req.URL.Query()[resumeParam]
+	if !ok {
+		return "", false
+	}
+	return v[0], true
+}
+
+func newResumeToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func resumeMessage(token string) []byte {
+	return []byte(resumePrefix + token)
+}
+
+// isResumable reports whether the error that ended a connection is the
+// connection dropping, rather than the client closing it.
+func isResumable(err error) bool {
+	if err == websocket.ErrReadLimit {
+		return false
+	}
+	switch e := err.(type) {
+	case *websocket.CloseError:
+		return e.Code == websocket.CloseAbnormalClosure
+	case *LimitError, *backendError:
+		return false
+	}
+	return err != nil
+}
+
+// resumption is a new connection for a session.
+type resumption struct {
+	conn       clientConn
+	remoteAddr string
+	ip         net.IP
+	transport  string
+}
+
+// sessionConn is the current connection of a session, which is nil while
+// waiting for the client to resume.
+type sessionConn struct {
+	mu   sync.Mutex
+	conn clientConn
+}
+
+func (c *sessionConn) get() clientConn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn
+}
+
+func (c *sessionConn) set(conn clientConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn = conn
+}
+
+// resume hands the connection to the session, returning false when the
+// session has already ended.
+func (s *Session) resume(r *resumption) bool {
+	select {
+	case s.resumed <- r:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+// byToken returns the session with the resume token.
+func (s *Sessions) byToken(token string) (*Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sess := range s.sessions {
+		if sess.token != "" && subtle.ConstantTimeCompare([]byte(sess.token), []byte(token)) == 1 {
+			return sess, true
+		}
+	}
+	return nil, false
+}
+
+// resumed records the new address of a session that has been resumed.
+func (s *Sessions) resumed(sess *Session, r *resumption) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess.RemoteAddr = r.remoteAddr
+	sess.IP = r.ip
+	sess.Transport = r.transport
+	sess.Resumes++
+	resumedSessions.Inc()
+}
@@ -0,0 +1,149 @@
+package client
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// echoBackend is a dedicated server that echoes packets, reporting the
+// address of each sender.
+func echoBackend(t *testing.T) (net.PacketConn, <-chan net.Addr) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	senders := make(chan net.Addr, 16)
+	go func() {
+		buf := make([]byte, maxPacketSize)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			select {
+			case senders <- addr:
+			default:
+			}
+			conn.WriteTo(buf[:n], addr)
+		}
+	}()
+	return conn, senders
+}
+
+func readPacket(t *testing.T, ws *websocket.Conn) string {
+	t.Helper()
+	if err := ws.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	_, msg, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(msg)
+}
+
+func TestResumeSession(t *testing.T) {
+	backend, senders := echoBackend(t)
+	defer backend.Close()
+
+	p, err := NewProxy(backend.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.ResumeGrace = 5 * time.Second
+	srv := httptest.NewServer(p)
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/?resume="
+
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg := readPacket(t, ws); !isPortMessage([]byte(msg)) {
+		t.Fatalf("client: expected port message, received %q", msg)
+	}
+	msg := readPacket(t, ws)
+	if !strings.HasPrefix(msg, resumePrefix) {
+		t.Fatalf("client: expected resume token, received %q", msg)
+	}
+	token := strings.TrimPrefix(msg, resumePrefix)
+	if err := ws.WriteMessage(websocket.BinaryMessage, []byte("\xff\xff\xff\xffgetinfo")); err != nil {
+		t.Fatal(err)
+	}
+	readPacket(t, ws)
+	first := <-senders
+
+	// drop the connection without a close message
+	ws.UnderlyingConn().Close()
+
+	ws, _, err = websocket.DefaultDialer.Dial(url+token, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	// the port message is only sent when the session starts
+	if msg := readPacket(t, ws); msg != resumePrefix+token {
+		t.Fatalf("client: expected resume token, received %q", msg)
+	}
+	if err := ws.WriteMessage(websocket.BinaryMessage, []byte("\xff\xff\xff\xffgetinfo")); err != nil {
+		t.Fatal(err)
+	}
+	readPacket(t, ws)
+	if second := <-senders; second.String() != first.String() {
+		t.Fatalf("client: expected backend address %s, received %s", first, second)
+	}
+	sessions := p.Sessions.List()
+	if len(sessions) != 1 || sessions[0].Resumes != 1 {
+		t.Fatalf("client: expected one resumed session, received %+v", sessions)
+	}
+
+	// closing the connection ends the session
+	m := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	if err := ws.WriteControl(websocket.CloseMessage, m, time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(p.Sessions.List()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("client: session not ended")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	_, resp, err := websocket.DefaultDialer.Dial(url+token, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("client: expected ended session to be refused, received %v", err)
+	}
+}
+
+func TestResumeDisabled(t *testing.T) {
+	backend, _ := echoBackend(t)
+	defer backend.Close()
+
+	p, err := NewProxy(backend.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(p)
+	defer srv.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/?resume=", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	if msg := readPacket(t, ws); !isPortMessage([]byte(msg)) {
+		t.Fatalf("client: expected port message, received %q", msg)
+	}
+	if err := ws.WriteMessage(websocket.BinaryMessage, []byte("\xff\xff\xff\xffgetinfo")); err != nil {
+		t.Fatal(err)
+	}
+	if msg := readPacket(t, ws); msg != "\xff\xff\xff\xffgetinfo" {
+		t.Fatalf("client: expected echo without resume token, received %q", msg)
+	}
+}
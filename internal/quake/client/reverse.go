@@ -0,0 +1,338 @@
+package client
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// UDPWebsocketProxy lets native clients join a server that is only reachable
+// with websockets, such as a QuakeJS server. Each client address is given its
+// own websocket to the server, so the server sees every client separately.
+type UDPWebsocketProxy struct {
+	// URL is the websocket server, e.g. ws://quakejs.example.com:27960.
+	URL string
+
+	Dialer *websocket.Dialer
+
+	// IdleTimeout is how long a client can go without sending a packet
+	// before its websocket is closed, which defaults to 2 minutes.
+	IdleTimeout time.Duration
+
+	// QueueSize is the number of packets from a client held while its
+	// websocket is connecting, or is slow to send, which defaults to 64.
+	QueueSize int
+
+	// MaxClients is the number of client addresses with a websocket at a
+	// time, which defaults to DefaultMaxReverseClients. Packets from new
+	// addresses are dropped once it is reached, so that spoofed source
+	// addresses cannot open unbounded websockets.
+	MaxClients int
+
+	// RetryBackoff is how long packets from a client are dropped after its
+	// websocket cannot be connected, before the server is dialed again, which
+	// defaults to 1 second. It doubles with each failure in a row, up to
+	// maxRetryBackoff.
+	RetryBackoff time.Duration
+
+	// Log records the websocket of each native client.
+	Log *zap.Logger
+
+	mu      sync.Mutex
+	conn    net.PacketConn
+	clients map[string]*reverseClient
+}
+
+// DefaultMaxReverseClients is the default MaxClients of the reverse proxy.
+const DefaultMaxReverseClients = 64
+
+// maxRetryBackoff is the longest a client waits to dial the server again.
+const maxRetryBackoff = time.Minute
+
+// NewReverseProxy returns a proxy to the websocket server at addr, which is
+// either a ws:// or wss:// URL, or <host>:<port>.
+func NewReverseProxy(addr string) (*UDPWebsocketProxy, error) {
+	if u, err := url.Parse(addr); err == nil && (u.Scheme == "ws" || u.Scheme == "wss") {
+		return &UDPWebsocketProxy{URL: addr, Log: zap.L().Named("reverse")}, nil
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, errors.Errorf("invalid websocket server: %q", addr)
+	}
+	return &UDPWebsocketProxy{URL: "ws://" + addr, Log: zap.L().Named("reverse")}, nil
+}
+
+func (p *UDPWebsocketProxy) ListenAndServe(addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	return p.Serve(conn)
+}
+
+// Serve forwards the packets received on conn until it is closed.
+func (p *UDPWebsocketProxy) Serve(conn net.PacketConn) error {
+	p.mu.Lock()
+	p.conn = conn
+	p.clients = make(map[string]*reverseClient)
+	p.mu.Unlock()
+	defer p.closeAll()
+
+	done := make(chan struct{})
+	defer close(done)
+	go p.closeIdle(done)
+
+	buffer := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := conn.ReadFrom(buffer)
+		if err != nil {
+			return err
+		}
+		msg := make([]byte, n)
+		copy(msg, buffer[:n])
+		if c := p.client(addr); c != nil {
+			c.queue(msg)
+		}
+	}
+}
+
+// client returns the client for the address, connecting a websocket for new
+// clients, or for clients that failed to connect once their backoff expires.
+// It returns nil when there are already MaxClients clients.
+func (p *UDPWebsocketProxy) client(addr net.Addr) *reverseClient {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	failures := 0
+	if c, ok := p.clients[addr.String()]; ok {
+		if !c.retryDue(now) {
+			return c
+		}
+		failures = c.failures + 1
+		delete(p.clients, addr.String())
+	}
+	max := p.MaxClients
+	if max == 0 {
+		max = DefaultMaxReverseClients
+	}
+	if len(p.clients) >= max {
+		return nil
+	}
+	size := p.QueueSize
+	if size == 0 {
+		size = 64
+	}
+	c := &reverseClient{
+		addr:     addr,
+		send:     make(chan []byte, size),
+		done:     make(chan struct{}),
+		failures: failures,
+	}
+	c.touch(now)
+	p.clients[addr.String()] = c
+	go p.serveClient(c)
+	return c
+}
+
+func (p *UDPWebsocketProxy) remove(c *reverseClient) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.clients[c.addr.String()] == c {
+		delete(p.clients, c.addr.String())
+	}
+}
+
+func (p *UDPWebsocketProxy) closeIdle(done <-chan struct{}) {
+	timeout := p.IdleTimeout
+	if timeout == 0 {
+		timeout = 2 * time.Minute
+	}
+	ticker := time.NewTicker(timeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			p.mu.Lock()
+			for key, c := range p.clients {
+				if now.Sub(c.lastSeen()) > timeout {
+					delete(p.clients, key)
+					c.close()
+				}
+			}
+			p.mu.Unlock()
+		case <-done:
+			return
+		}
+	}
+}
+
+func (p *UDPWebsocketProxy) closeAll() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, c := range p.clients {
+		delete(p.clients, key)
+		c.close()
+	}
+}
+
+// retryBackoff returns how long to wait after the given number of failures
+// in a row.
+func (p *UDPWebsocketProxy) retryBackoff(failures int) time.Duration {
+	backoff := p.RetryBackoff
+	if backoff == 0 {
+		backoff = time.Second
+	}
+	for i := 1; i < failures && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRetryBackoff {
+		backoff = maxRetryBackoff
+	}
+	return backoff
+}
+
+// serveClient connects the websocket of a client, then forwards packets in
+// both directions until either side is done.
+func (p *UDPWebsocketProxy) serveClient(c *reverseClient) {
+	defer c.close()
+	log := p.Log.With(zap.Stringer("client", c.addr))
+
+	dialer := p.Dialer
+	if dialer == nil {
+		dialer = websocket.DefaultDialer
+	}
+	ws, _, err := dialer.Dial(p.URL, http.Header{
+		"Sec-Websocket-Protocol": []string{"binary"},
+	})
+	if err != nil {
+		// the client is kept until the backoff expires, so that each packet
+		// of a client that keeps sending does not dial the server again
+		backoff := p.retryBackoff(c.failures + 1)
+		log.Warn("cannot connect",
+			zap.String("url", p.URL),
+			zap.Int("failures", c.failures+1),
+			zap.Duration("retry", backoff),
+			zap.Error(err),
+		)
+		c.fail(time.Now().Add(backoff))
+		return
+	}
+	defer p.remove(c)
+	defer ws.Close()
+
+	// The server knows clients by the address of the websocket, and the port
+	// message overrides the port. The local port of the websocket is sent so
+	// that clients sharing a port on different hosts are not confused.
+	if addr, ok := ws.UnderlyingConn().LocalAddr().(*net.TCPAddr); ok {
+		if err := ws.WriteMessage(websocket.BinaryMessage, portMessage(addr.Port)); err != nil {
+			log.Info("cannot send port message", zap.Error(err))
+			return
+		}
+	}
+
+	errc := make(chan error, 2)
+	go func() {
+		first := true
+		for {
+			_, msg, err := ws.ReadMessage()
+			if err != nil {
+				errc <- err
+				return
+			}
+			// the port message of the server means nothing to a native
+			// client
+			if first && isPortMessage(msg) {
+				first = false
+				continue
+			}
+			first = false
+			if _, err := p.conn.WriteTo(msg, c.addr); err != nil {
+				errc <- err
+				return
+			}
+		}
+	}()
+	go func() {
+		for {
+			select {
+			case msg := <-c.send:
+				if err := ws.WriteMessage(websocket.BinaryMessage, msg); err != nil {
+					errc <- err
+					return
+				}
+			case <-c.done:
+				errc <- nil
+				return
+			}
+		}
+	}()
+
+	if err := <-errc; err != nil {
+		if e, ok := err.(*websocket.CloseError); !ok || e.Code != websocket.CloseNormalClosure {
+			log.Info("closed websocket", zap.Error(err))
+		}
+	}
+	m := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	ws.WriteControl(websocket.CloseMessage, m, time.Now().Add(time.Second))
+}
+
+// reverseClient is a native client and its websocket.
+type reverseClient struct {
+	last int64
+
+	// retryAt is when the server is dialed again after the websocket cannot
+	// be connected, and is zero until then. failures is the number of times
+	// in a row the address failed to connect before this client.
+	retryAt  int64
+	failures int
+
+	addr net.Addr
+	send chan []byte
+
+	once sync.Once
+	done chan struct{}
+}
+
+// queue sends the packet to the server, dropping it when the queue is full
+// as would happen to a UDP packet, or when the websocket cannot be connected.
+func (c *reverseClient) queue(msg []byte) {
+	c.touch(time.Now())
+	if atomic.LoadInt64(&c.retryAt) != 0 {
+		return
+	}
+	select {
+	case c.send <- msg:
+	default:
+	}
+}
+
+func (c *reverseClient) touch(now time.Time) {
+	atomic.StoreInt64(&c.last, now.UnixNano())
+}
+
+func (c *reverseClient) lastSeen() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.last))
+}
+
+// fail drops the packets of the client until the server is dialed again at
+// retryAt.
+func (c *reverseClient) fail(retryAt time.Time) {
+	atomic.StoreInt64(&c.retryAt, retryAt.UnixNano())
+}
+
+// retryDue reports whether the client failed to connect, and its backoff has
+// expired.
+func (c *reverseClient) retryDue(now time.Time) bool {
+	retryAt := atomic.LoadInt64(&c.retryAt)
+	return retryAt != 0 && now.UnixNano() >= retryAt
+}
+
+func (c *reverseClient) close() {
+	c.once.Do(func() { close(c.done) })
+}
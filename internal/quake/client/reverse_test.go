@@ -0,0 +1,215 @@
+package client
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestReverseProxy(t *testing.T) {
+	// the websocket server sends its own port message, then echoes packets
+	// after the port message of the client
+	ports := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := DefaultUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		if err := ws.WriteMessage(websocket.BinaryMessage, portMessage(27960)); err != nil {
+			return
+		}
+		_, msg, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		ports <- msg
+		for {
+			_, msg, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := ws.WriteMessage(websocket.BinaryMessage, msg); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	p, err := NewReverseProxy(strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go p.Serve(conn)
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := client.Write([]byte("\xff\xff\xff\xffgetchallenge")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-ports:
+		if !isPortMessage(msg) {
+			t.Fatalf("client: expected port message, received %q", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("client: websocket not connected")
+	}
+
+	if err := client.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, maxPacketSize)
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "\xff\xff\xff\xffgetchallenge" {
+		t.Fatalf("client: unexpected packet %q", buf[:n])
+	}
+}
+
+func TestNewReverseProxy(t *testing.T) {
+	cases := []struct {
+		addr     string
+		expected string
+	}{
+		{"quakejs.example.com:27960", "ws://quakejs.example.com:27960"},
+		{"wss://quakejs.example.com:443", "wss://quakejs.example.com:443"},
+	}
+	for _, tc := range cases {
+		p, err := NewReverseProxy(tc.addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.URL != tc.expected {
+			t.Errorf("client: expected %q, received %q", tc.expected, p.URL)
+		}
+	}
+	if _, err := NewReverseProxy("quakejs.example.com"); err == nil {
+		t.Error("client: expected error for address without port")
+	}
+}
+
+func TestReverseProxyMaxClients(t *testing.T) {
+	connected := make(chan struct{}, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := DefaultUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+		connected <- struct{}{}
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer srv.Close()
+
+	p, err := NewReverseProxy(strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.MaxClients = 1
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go p.Serve(conn)
+
+	for i := 0; i < 3; i++ {
+		client, err := net.Dial("udp", conn.LocalAddr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		if _, err := client.Write([]byte("\xff\xff\xff\xffgetchallenge")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case <-connected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("client: websocket not connected")
+	}
+	select {
+	case <-connected:
+		t.Fatal("client: expected packets from other addresses to be dropped")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestReverseProxyRetryBackoff(t *testing.T) {
+	var dials int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&dials, 1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	p, err := NewReverseProxy(strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.RetryBackoff = 300 * time.Millisecond
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go p.Serve(conn)
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	send := func(n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			if _, err := client.Write([]byte("\xff\xff\xff\xffgetchallenge")); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitDials := func(expected int32) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for atomic.LoadInt32(&dials) < expected && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if n := atomic.LoadInt32(&dials); n != expected {
+			t.Fatalf("client: expected %d dials, received %d", expected, n)
+		}
+	}
+
+	// packets are dropped until the backoff expires
+	send(10)
+	waitDials(1)
+	time.Sleep(400 * time.Millisecond)
+	send(1)
+	waitDials(2)
+
+	// the backoff doubles with each failure in a row
+	send(30)
+	waitDials(2)
+}
@@ -4,14 +4,21 @@ import (
 	"html/template"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
 
+	"github.com/criticalstack/quake-kube/internal/quake/bans"
 	quakenet "github.com/criticalstack/quake-kube/internal/quake/net"
+	"github.com/criticalstack/quake-kube/internal/quake/stats"
+	logutil "github.com/criticalstack/quake-kube/internal/util/log"
 )
 
 type Config struct {
@@ -19,28 +26,40 @@ type Config struct {
 	ServerAddr       string
 
 	Files http.FileSystem
+
+	// Stats is the match history, the stats API is only served when set.
+	Stats *stats.Store
+
+	// Admin configures the admin API, which is only served when credentials
+	// are set.
+	Admin *AdminConfig
+
+	// Bans are managed with the admin API, when set.
+	Bans *bans.List
+
+	// Sessions is the table of websocket clients, which is served to admins
+	// at /api/sessions when set.
+	Sessions *Sessions
+
+	// TrustedProxies are the load balancers allowed to set X-Forwarded-For.
+	TrustedProxies []*net.IPNet
+
+	// Health is served at /healthz and /readyz, when set.
+	Health *Health
 }
 
 func NewRouter(cfg *Config) (*echo.Echo, error) {
 	e := echo.New()
-	e.Use(middleware.Logger())
+	e.IPExtractor = NewIPExtractor(cfg.TrustedProxies)
+	// probes are too frequent to log
+	e.Use(logutil.Requests(zap.L().Named("client"), isHealthPath))
 	e.Use(middleware.Recover())
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
 		AllowOrigins: []string{"*"},
 		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept},
 	}))
 
-	f, err := cfg.Files.Open("index.html")
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-
-	data, err := ioutil.ReadAll(f)
-	if err != nil {
-		return nil, err
-	}
-	templates, err := template.New("index").Parse(string(data))
+	templates, err := parseTemplates(cfg.Files, "index.html", "leaderboard.html", "player.html")
 	if err != nil {
 		return nil, err
 	}
@@ -66,6 +85,10 @@ func NewRouter(cfg *Config) (*echo.Echo, error) {
 
 	e.GET("/metrics", echo.WrapHandler(promhttp.Handler()))
 
+	if cfg.Health != nil {
+		registerHealth(e, cfg.Health)
+	}
+
 	e.GET("/info", func(c echo.Context) error {
 		m, err := quakenet.GetInfo(cfg.ServerAddr)
 		if err != nil {
@@ -82,6 +105,85 @@ func NewRouter(cfg *Config) (*echo.Echo, error) {
 		return c.JSON(http.StatusOK, m)
 	})
 
+	if cfg.Stats != nil {
+		e.GET("/api/matches", func(c echo.Context) error {
+			limit := 20
+			if v := c.QueryParam("limit"); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil || n < 0 {
+					return echo.NewHTTPError(http.StatusBadRequest, "invalid limit")
+				}
+				limit = n
+			}
+			matches, err := cfg.Stats.Matches(limit)
+			if err != nil {
+				return err
+			}
+			return c.JSON(http.StatusOK, matches)
+		})
+
+		e.GET("/api/players/:name", func(c echo.Context) error {
+			name, err := url.PathUnescape(c.Param("name"))
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+			}
+			p, ok := cfg.Stats.Player(name)
+			if !ok {
+				return echo.NewHTTPError(http.StatusNotFound, "player not found")
+			}
+			return c.JSON(http.StatusOK, p)
+		})
+
+		e.GET("/leaderboard", func(c echo.Context) error {
+			weekly, err := cfg.Stats.TopFraggers(time.Now().AddDate(0, 0, -7), 10)
+			if err != nil {
+				return err
+			}
+			allTime, err := cfg.Stats.TopFraggers(time.Time{}, 10)
+			if err != nil {
+				return err
+			}
+			return c.Render(http.StatusOK, "leaderboard", map[string]interface{}{
+				"Weekly":   weekly,
+				"AllTime":  allTime,
+				"WinRates": cfg.Stats.WinRates(3, 10),
+			})
+		})
+
+		e.GET("/players/:name", func(c echo.Context) error {
+			name, err := url.PathUnescape(c.Param("name"))
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+			}
+			p, ok := cfg.Stats.Player(name)
+			if !ok {
+				return echo.NewHTTPError(http.StatusNotFound, "player not found")
+			}
+			matches, err := cfg.Stats.PlayerMatches(name, 10)
+			if err != nil {
+				return err
+			}
+			return c.Render(http.StatusOK, "player", map[string]interface{}{
+				"Player":        p,
+				"FavouriteMaps": p.FavouriteMaps(5),
+				"Matches":       matches,
+			})
+		})
+	}
+
+	if cfg.Admin.enabled() {
+		registerAdminAPI(e, cfg)
+
+		e.GET("/admin", func(c echo.Context) error {
+			f, err := cfg.Files.Open("admin.html")
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			return c.Stream(http.StatusOK, echo.MIMETextHTMLCharsetUTF8, f)
+		})
+	}
+
 	// static files
 	e.GET("/*", echo.WrapHandler(http.FileServer(cfg.Files)))
 
@@ -112,6 +214,33 @@ func (t *HostHeaderTransport) RoundTrip(req *http.Request) (*http.Response, erro
 	return t.RoundTripper.RoundTrip(req)
 }
 
+var templateFuncs = template.FuncMap{
+	"inc": func(i int) int {
+		return i + 1
+	},
+}
+
+// parseTemplates parses the named template files into a single template set.
+// Each file provides its templates using define.
+func parseTemplates(files http.FileSystem, names ...string) (*template.Template, error) {
+	templates := template.New("").Funcs(templateFuncs)
+	for _, name := range names {
+		f, err := files.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := templates.New(name).Parse(string(data)); err != nil {
+			return nil, err
+		}
+	}
+	return templates, nil
+}
+
 type TemplateRenderer struct {
 	*template.Template
 }
@@ -1,37 +1,82 @@
 package client
 
 import (
+	"context"
+	"crypto/tls"
 	"net"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/cockroachdb/cmux"
+	"github.com/pkg/errors"
+
+	"github.com/criticalstack/quake-kube/internal/quake/bans"
+	netutil "github.com/criticalstack/quake-kube/internal/util/net"
 )
 
 type Server struct {
 	Addr       string
 	Handler    http.Handler
 	ServerAddr string
+
+	// Bans are enforced for websocket clients, when set.
+	Bans *bans.List
+
+	// Sessions is the table of websocket clients, which is shared with the
+	// router to serve /api/sessions.
+	Sessions *Sessions
+
+	// TrustedProxies are the load balancers allowed to set X-Forwarded-For,
+	// or send a PROXY protocol header when ProxyProtocol is set.
+	TrustedProxies []*net.IPNet
+	ProxyProtocol  bool
+
+	// Limits bound the sessions and packets of each websocket client.
+	Limits Limits
+
+	// ResumeGrace is how long the session of a client is kept after its
+	// connection drops, waiting for it to reconnect.
+	ResumeGrace time.Duration
+
+	// WebRTC allows clients to connect with a WebRTC data channel, when set.
+	WebRTC *WebRTCConfig
+
+	// Backends route clients to other dedicated servers, when set. Clients
+	// that do not select a backend are sent to ServerAddr.
+	Backends *Backends
+
+	// TLSConfig serves HTTPS and WSS, when set. The handshake is done before
+	// websocket and HTTP traffic are split, so both share the address.
+	TLSConfig *tls.Config
+
+	// RedirectAddr is a plain HTTP address that redirects to HTTPS, when
+	// TLSConfig is set.
+	RedirectAddr string
+
+	// HTTPSPort is the port in redirects to HTTPS, which defaults to the
+	// port of Addr. It differs when a load balancer forwards another port.
+	HTTPSPort string
+
+	mu sync.Mutex
+
+	// addr is the address being served, and err is why serving stopped.
+	addr net.Addr
+	err  error
 }
 
 func (s *Server) Serve(l net.Listener) error {
+	if s.TLSConfig != nil {
+		cfg := s.TLSConfig.Clone()
+		// HTTP/2 cannot be served once cmux has wrapped the connection
+		cfg.NextProtos = []string{"http/1.1"}
+		l = tls.NewListener(l, cfg)
+	}
 	m := cmux.New(l)
 	websocketL := m.Match(cmux.HTTP1HeaderField("Upgrade", "websocket"))
 	httpL := m.Match(cmux.Any())
 
-	go func() {
-		s := &http.Server{
-			Addr:           s.Addr,
-			Handler:        s.Handler,
-			ReadTimeout:    5 * time.Minute,
-			WriteTimeout:   5 * time.Minute,
-			MaxHeaderBytes: 1 << 20,
-		}
-		if err := s.Serve(httpL); err != cmux.ErrListenerClosed {
-			panic(err)
-		}
-	}()
-
 	host, port, err := net.SplitHostPort(s.ServerAddr)
 	if err != nil {
 		return err
@@ -45,17 +90,95 @@ func (s *Server) Serve(l net.Listener) error {
 	if err != nil {
 		return err
 	}
+	defer wsproxy.Close()
+	wsproxy.ClientIP = NewIPExtractor(s.TrustedProxies)
+	wsproxy.Limits = s.Limits
+	wsproxy.ResumeGrace = s.ResumeGrace
+	wsproxy.Backends = s.Backends
+	if s.Sessions != nil {
+		wsproxy.Sessions = s.Sessions
+	}
+	if s.Bans != nil {
+		wsproxy.Bans = s.Bans
+		s.Bans.OnChange(wsproxy.CloseBanned)
+	}
+	if s.WebRTC != nil {
+		if err := wsproxy.EnableWebRTC(*s.WebRTC); err != nil {
+			return err
+		}
+	}
+
+	// WebRTC clients are signalled over plain HTTP, then send packets
+	// directly to the proxy
+	httpHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.WebRTC != nil && isRTCPath(r.URL.Path) {
+			wsproxy.ServeRTC(w, r)
+			return
+		}
+		s.Handler.ServeHTTP(w, r)
+	})
+
+	go func() {
+		s := &http.Server{
+			Addr:           s.Addr,
+			Handler:        withTLSState(httpHandler),
+			ReadTimeout:    5 * time.Minute,
+			WriteTimeout:   5 * time.Minute,
+			MaxHeaderBytes: 1 << 20,
+			ConnContext:    tlsConnContext,
+		}
+		if err := s.Serve(httpL); err != cmux.ErrListenerClosed {
+			panic(err)
+		}
+	}()
+
+	// websocket requests for the admin console are handled by the router,
+	// everything else is a game client
+	wsHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/admin/") {
+			s.Handler.ServeHTTP(w, r)
+			return
+		}
+		wsproxy.ServeHTTP(w, r)
+	})
 
 	go func() {
 		s := &http.Server{
-			Handler: wsproxy,
+			Handler:     withTLSState(wsHandler),
+			ConnContext: tlsConnContext,
 		}
 		if err := s.Serve(websocketL); err != cmux.ErrListenerClosed {
 			panic(err)
 		}
 	}()
 
-	return m.Serve()
+	s.setListener(l.Addr(), nil)
+	err = m.Serve()
+	if err == nil {
+		err = errors.New("listener closed")
+	}
+	s.setListener(nil, err)
+	return err
+}
+
+func (s *Server) setListener(addr net.Addr, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addr, s.err = addr, err
+}
+
+// CheckListener reports whether websocket and HTTP connections are being
+// accepted.
+func (s *Server) CheckListener(ctx context.Context) CheckResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.err != nil:
+		return CheckResult{Status: StatusFailed, Detail: s.err.Error()}
+	case s.addr == nil:
+		return CheckResult{Status: StatusWaiting, Detail: "not listening"}
+	}
+	return CheckResult{Status: StatusOK, Detail: "listening on " + s.addr.String()}
 }
 
 func (s *Server) ListenAndServe() error {
@@ -63,5 +186,82 @@ func (s *Server) ListenAndServe() error {
 	if err != nil {
 		return err
 	}
+	if s.ProxyProtocol {
+		l = &netutil.ProxyProtocolListener{Listener: l, Trusted: s.trusted}
+	}
+	if s.TLSConfig != nil && s.RedirectAddr != "" {
+		rl, err := net.Listen("tcp", s.RedirectAddr)
+		if err != nil {
+			l.Close()
+			return err
+		}
+		port := s.HTTPSPort
+		if port == "" {
+			if _, port, err = net.SplitHostPort(s.Addr); err != nil {
+				l.Close()
+				return err
+			}
+		}
+		go func() {
+			s := &http.Server{
+				Handler:     RedirectHTTPS(port),
+				ReadTimeout: 10 * time.Second,
+			}
+			if err := s.Serve(rl); err != nil {
+				panic(err)
+			}
+		}()
+	}
 	return s.Serve(l)
 }
+
+// RedirectHTTPS redirects requests to the same host and path using HTTPS on
+// the port.
+func RedirectHTTPS(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		host = strings.Trim(host, "[]")
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			// an IPv6 address
+			host = "[" + host + "]"
+		}
+		u := *r.URL
+		u.Scheme, u.Host = "https", host
+		http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+	})
+}
+
+type tlsStateKey struct{}
+
+// tlsConnContext records the TLS state of connections, which net/http does
+// not see once the connection has been wrapped by cmux.
+func tlsConnContext(ctx context.Context, c net.Conn) context.Context {
+	if mc, ok := c.(*cmux.MuxConn); ok {
+		c = mc.Conn
+	}
+	if tc, ok := c.(*tls.Conn); ok {
+		state := tc.ConnectionState()
+		return context.WithValue(ctx, tlsStateKey{}, &state)
+	}
+	return ctx
+}
+
+// withTLSState sets the TLS state of the request, so that handlers can check
+// the scheme and client certificate as usual.
+func withTLSState(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if state, ok := r.Context().Value(tlsStateKey{}).(*tls.ConnectionState); ok && r.TLS == nil {
+			r.TLS = state
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) trusted(ip net.IP) bool {
+	return netutil.ContainsIP(s.TrustedProxies, ip)
+}
@@ -0,0 +1,350 @@
+package client
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	quakenet "github.com/criticalstack/quake-kube/internal/quake/net"
+)
+
+// NewIPExtractor returns the client address of a request. X-Forwarded-For is
+// only used when the request comes from one of the trusted proxies, and the
+// address returned is the nearest one that is not a trusted proxy.
+func NewIPExtractor(trusted []*net.IPNet) echo.IPExtractor {
+	if len(trusted) == 0 {
+		return echo.ExtractIPDirect()
+	}
+	opts := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+	for _, n := range trusted {
+		opts = append(opts, echo.TrustIPRange(n))
+	}
+	return echo.ExtractIPFromXFFHeader(opts...)
+}
+
+// Session is a client connected through the proxy.
+type Session struct {
+	ID uint64 `json:"id"`
+
+	// RemoteAddr is the address of the client, rather than any load balancer
+	// in front of the proxy.
+	RemoteAddr string    `json:"remoteAddr"`
+	IP         net.IP    `json:"-"`
+	Connected  time.Time `json:"connected"`
+
+	// Transport is how the client is connected, either websocket or webrtc.
+	Transport string `json:"transport"`
+
+	// Backend is the name of the dedicated server the client is routed to,
+	// when the proxy has more than one.
+	Backend string `json:"backend,omitempty"`
+
+	// BackendPort is the local UDP port used to reach the dedicated server,
+	// which is the port the dedicated server reports for the client.
+	BackendPort int `json:"backendPort"`
+
+	// ClientPort is the port the client said it listens on with the port
+	// message of QuakeJS, which is the net_port of the client.
+	ClientPort int `json:"clientPort,omitempty"`
+
+	// Slot, Name and Ping are the in-game client, once the client has joined
+	// the game. Ping is measured by the dedicated server.
+	Slot *int   `json:"slot,omitempty"`
+	Name string `json:"name,omitempty"`
+	Ping *int   `json:"ping,omitempty"`
+
+	// Resumes is the number of times the client has reconnected to the
+	// session after its connection dropped.
+	Resumes int `json:"resumes,omitempty"`
+
+	Stats SessionStats `json:"stats"`
+
+	conn     *sessionConn
+	counters *sessionCounters
+
+	// listenPort is the port the client connected to, which is sent to the
+	// client in a port message
+	listenPort int
+
+	// token resumes the session, when the client supports it
+	token   string
+	resumed chan *resumption
+	done    chan struct{}
+}
+
+// SessionStats are the traffic counters of a session.
+type SessionStats struct {
+	PacketsFromClient  uint64 `json:"packetsFromClient"`
+	BytesFromClient    uint64 `json:"bytesFromClient"`
+	PacketsToClient    uint64 `json:"packetsToClient"`
+	BytesToClient      uint64 `json:"bytesToClient"`
+	BackendWriteErrors uint64 `json:"backendWriteErrors"`
+
+	// PacketsDropped are connectionless packets from the client that the
+	// dedicated server does not handle, which are not forwarded.
+	PacketsDropped uint64 `json:"packetsDropped"`
+
+	// LastFromClient and LastFromServer are when a packet was last received
+	// from each side. Gaps from the client point to its network, while gaps
+	// from the server point to the server.
+	LastFromClient *time.Time `json:"lastFromClient,omitempty"`
+	LastFromServer *time.Time `json:"lastFromServer,omitempty"`
+
+	// AvgWriteLatency and MaxWriteLatency are the time taken to write packets
+	// to the websocket, which grows when the client cannot keep up.
+	AvgWriteLatency metav1.Duration `json:"avgWriteLatency"`
+	MaxWriteLatency metav1.Duration `json:"maxWriteLatency"`
+}
+
+// sessionCounters are updated atomically by the goroutines of a session.
+type sessionCounters struct {
+	packetsIn   uint64
+	bytesIn     uint64
+	packetsOut  uint64
+	bytesOut    uint64
+	writeErrors uint64
+	dropped     uint64
+	lastIn      int64
+	lastOut     int64
+	writeNanos  int64
+	maxWrite    int64
+	code        int32
+}
+
+func (c *sessionCounters) fromClient(n int, now time.Time) {
+	atomic.AddUint64(&c.packetsIn, 1)
+	atomic.AddUint64(&c.bytesIn, uint64(n))
+	atomic.StoreInt64(&c.lastIn, now.UnixNano())
+}
+
+func (c *sessionCounters) toClient(n int, now time.Time, latency time.Duration) {
+	atomic.AddUint64(&c.packetsOut, 1)
+	atomic.AddUint64(&c.bytesOut, uint64(n))
+	atomic.StoreInt64(&c.lastOut, now.UnixNano())
+	atomic.AddInt64(&c.writeNanos, int64(latency))
+	for {
+		max := atomic.LoadInt64(&c.maxWrite)
+		if int64(latency) <= max || atomic.CompareAndSwapInt64(&c.maxWrite, max, int64(latency)) {
+			break
+		}
+	}
+}
+
+func (c *sessionCounters) backendWriteError() {
+	atomic.AddUint64(&c.writeErrors, 1)
+}
+
+func (c *sessionCounters) droppedFromClient() {
+	atomic.AddUint64(&c.dropped, 1)
+}
+
+// setCloseCode records why the session was closed, keeping the first reason
+// when there are several.
+func (c *sessionCounters) setCloseCode(code int) {
+	atomic.CompareAndSwapInt32(&c.code, 0, int32(code))
+}
+
+// closing reports whether a reason to close the session has been recorded.
+func (c *sessionCounters) closing() bool {
+	return atomic.LoadInt32(&c.code) != 0
+}
+
+func (c *sessionCounters) closeCode() int {
+	if code := atomic.LoadInt32(&c.code); code != 0 {
+		return int(code)
+	}
+	return websocket.CloseAbnormalClosure
+}
+
+func (c *sessionCounters) stats() SessionStats {
+	st := SessionStats{
+		PacketsFromClient:  atomic.LoadUint64(&c.packetsIn),
+		BytesFromClient:    atomic.LoadUint64(&c.bytesIn),
+		PacketsToClient:    atomic.LoadUint64(&c.packetsOut),
+		BytesToClient:      atomic.LoadUint64(&c.bytesOut),
+		BackendWriteErrors: atomic.LoadUint64(&c.writeErrors),
+		PacketsDropped:     atomic.LoadUint64(&c.dropped),
+	}
+	if t := atomic.LoadInt64(&c.lastIn); t != 0 {
+		last := time.Unix(0, t).UTC()
+		st.LastFromClient = &last
+	}
+	if t := atomic.LoadInt64(&c.lastOut); t != 0 {
+		last := time.Unix(0, t).UTC()
+		st.LastFromServer = &last
+	}
+	if st.PacketsToClient > 0 {
+		st.AvgWriteLatency.Duration = time.Duration(atomic.LoadInt64(&c.writeNanos) / int64(st.PacketsToClient))
+	}
+	st.MaxWriteLatency.Duration = time.Duration(atomic.LoadInt64(&c.maxWrite))
+	return st
+}
+
+// Sessions is the table of clients connected through the websocket proxy. The
+// zero value is ready to use.
+type Sessions struct {
+	mu       sync.Mutex
+	nextID   uint64
+	sessions map[uint64]*Session
+	refresh  chan struct{}
+}
+
+func (s *Sessions) add(sess *Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sessions == nil {
+		s.sessions = make(map[uint64]*Session)
+	}
+	s.nextID++
+	sess.ID = s.nextID
+	if sess.counters == nil {
+		sess.counters = &sessionCounters{}
+	}
+	s.sessions[sess.ID] = sess
+}
+
+func (s *Sessions) remove(sess *Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, sess.ID)
+}
+
+// List returns the current sessions, oldest first.
+func (s *Sessions) List() []Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]Session, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		cp := *sess
+		cp.Stats = sess.counters.stats()
+		result = append(result, cp)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+	return result
+}
+
+// setClientPort records the port from the port message of the client.
+func (s *Sessions) setClientPort(sess *Session, port int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sess.ClientPort = port
+}
+
+// BySlot returns the session of the in-game client slot.
+func (s *Sessions) BySlot(slot int) (Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sess := range s.sessions {
+		if sess.Slot != nil && *sess.Slot == slot {
+			return *sess, true
+		}
+	}
+	return Session{}, false
+}
+
+// each calls fn for every session while holding the lock.
+func (s *Sessions) each(fn func(*Session)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sess := range s.sessions {
+		fn(sess)
+	}
+}
+
+// update sets the in-game client of each session from the server status. The
+// dedicated server sees every proxied client at the address of the proxy, so
+// clients are matched by the port of the backend socket.
+func (s *Sessions) update(status *quakenet.ServerStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sess := range s.sessions {
+		sess.Slot, sess.Name, sess.Ping = nil, "", nil
+		for _, c := range status.Clients {
+			if c.IsBot() {
+				continue
+			}
+			_, port, err := net.SplitHostPort(c.Address)
+			if err != nil || port != strconv.Itoa(sess.BackendPort) {
+				continue
+			}
+			slot, ping := c.Num, c.Ping
+			sess.Slot, sess.Name, sess.Ping = &slot, c.Name, &ping
+			break
+		}
+	}
+}
+
+// refreshSoon asks Track to update the in-game clients without waiting for
+// the next interval, e.g. when a client has just joined.
+func (s *Sessions) refreshSoon() {
+	s.mu.Lock()
+	ch := s.refresh
+	s.mu.Unlock()
+	if ch == nil {
+		return
+	}
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// Track updates the in-game client of each session from the rcon status of
+// the dedicated server, until the context is done.
+func (s *Sessions) Track(ctx context.Context, console Console, interval time.Duration) {
+	s.mu.Lock()
+	s.refresh = make(chan struct{}, 1)
+	ch := s.refresh
+	s.mu.Unlock()
+
+	log := zap.L().Named("sessions")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ch:
+			// give the server a moment to assign the slot
+			time.Sleep(500 * time.Millisecond)
+		case <-ctx.Done():
+			return
+		}
+		s.mu.Lock()
+		n := len(s.sessions)
+		s.mu.Unlock()
+		if n == 0 {
+			continue
+		}
+		out, err := console.Exec("status")
+		if err != nil {
+			log.Info("cannot get status", zap.Error(err))
+			continue
+		}
+		if out == "" {
+			// requires an rcon password
+			continue
+		}
+		status, err := quakenet.ParseServerStatus(out)
+		if err != nil {
+			log.Info("cannot parse status", zap.Error(err))
+			continue
+		}
+		s.update(status)
+	}
+}
@@ -0,0 +1,66 @@
+package client
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	quakenet "github.com/criticalstack/quake-kube/internal/quake/net"
+)
+
+func TestSessionsUpdate(t *testing.T) {
+	var s Sessions
+	a := &Session{BackendPort: 50001}
+	b := &Session{BackendPort: 50002}
+	s.add(a)
+	s.add(b)
+
+	s.update(&quakenet.ServerStatus{
+		Clients: []quakenet.Client{
+			{Num: 0, Name: "Sarge", Address: "bot"},
+			{Num: 1, Name: "player", Address: "127.0.0.1:50002"},
+		},
+	})
+	if _, ok := s.BySlot(0); ok {
+		t.Error("client: bots should not match a session")
+	}
+	sess, ok := s.BySlot(1)
+	if !ok || sess.ID != b.ID || sess.Name != "player" {
+		t.Errorf("client: expected session %d for slot 1, received %+v", b.ID, sess)
+	}
+	if list := s.List(); len(list) != 2 || list[0].Slot != nil {
+		t.Errorf("client: expected unmatched first session, received %+v", list)
+	}
+
+	s.remove(b)
+	if _, ok := s.BySlot(1); ok {
+		t.Error("client: expected session to be removed")
+	}
+}
+
+func TestIPExtractor(t *testing.T) {
+	_, lb, _ := net.ParseCIDR("10.0.0.0/8")
+	cases := []struct {
+		name       string
+		trusted    []*net.IPNet
+		remoteAddr string
+		xff        string
+		expected   string
+	}{
+		{"direct", nil, "203.0.113.7:1234", "198.51.100.1", "203.0.113.7"},
+		{"trusted", []*net.IPNet{lb}, "10.0.0.2:1234", "198.51.100.1", "198.51.100.1"},
+		{"trusted chain", []*net.IPNet{lb}, "10.0.0.2:1234", "198.51.100.1, 10.0.0.3", "198.51.100.1"},
+		{"spoofed", []*net.IPNet{lb}, "10.0.0.2:1234", "10.0.0.9, 198.51.100.1", "198.51.100.1"},
+		{"untrusted", []*net.IPNet{lb}, "203.0.113.7:1234", "198.51.100.1", "203.0.113.7"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = c.remoteAddr
+			req.Header.Set("X-Forwarded-For", c.xff)
+			if ip := NewIPExtractor(c.trusted)(req); ip != c.expected {
+				t.Errorf("expected %s, received %s", c.expected, ip)
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+)
+
+// testCA issues certificates for the servers and clients of tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "quake-kube test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+func (ca *testCA) issue(t *testing.T, name string, usage x509.ExtKeyUsage) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestServerTLS(t *testing.T) {
+	backend, _ := echoBackend(t)
+	defer backend.Close()
+
+	ca := newTestCA(t)
+	s := &Server{
+		ServerAddr: backend.LocalAddr().String(),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.TLS == nil:
+				w.Write([]byte("plain"))
+			case len(r.TLS.VerifiedChains) > 0:
+				w.Write([]byte("verified"))
+			default:
+				w.Write([]byte("anonymous"))
+			}
+		}),
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{ca.issue(t, "server", x509.ExtKeyUsageServerAuth)},
+			ClientCAs:    ca.pool,
+			ClientAuth:   tls.VerifyClientCertIfGiven,
+		},
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go s.Serve(l)
+	addr := l.Addr().String()
+
+	// the client certificate is seen by the handler through cmux
+	for _, c := range []struct {
+		certs    []tls.Certificate
+		expected string
+	}{
+		{nil, "anonymous"},
+		{[]tls.Certificate{ca.issue(t, "admin", x509.ExtKeyUsageClientAuth)}, "verified"},
+	} {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: ca.pool, Certificates: c.certs},
+		}}
+		resp, err := client.Get("https://" + addr + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != c.expected {
+			t.Errorf("client: expected %q, received %q", c.expected, body)
+		}
+	}
+
+	dialer := &websocket.Dialer{TLSClientConfig: &tls.Config{RootCAs: ca.pool}}
+	ws, _, err := dialer.Dial("wss://"+addr+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	port := l.Addr().(*net.TCPAddr).Port
+	if msg := readPacket(t, ws); msg != string(portMessage(port)) {
+		t.Fatalf("client: expected port message over wss, received %q", msg)
+	}
+}
+
+func TestRedirectHTTPS(t *testing.T) {
+	cases := []struct {
+		name     string
+		url      string
+		port     string
+		expected string
+	}{
+		{"default port", "http://quake.example.com/play?map=q3dm17", "443", "https://quake.example.com/play?map=q3dm17"},
+		{"other port", "http://quake.example.com:8080/", "8443", "https://quake.example.com:8443/"},
+		{"ipv6", "http://[::1]:8080/", "443", "https://[::1]/"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			RedirectHTTPS(c.port).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, c.url, nil))
+			if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != c.expected {
+				t.Errorf("client: expected redirect to %s, received %d %s", c.expected, rec.Code, rec.Header().Get("Location"))
+			}
+		})
+	}
+}
+
+func TestAdminClientCerts(t *testing.T) {
+	verified := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}
+	cases := []struct {
+		name        string
+		clientCerts bool
+		state       *tls.ConnectionState
+		expected    int
+	}{
+		{"verified", true, verified, http.StatusOK},
+		{"no certificate", true, &tls.ConnectionState{}, http.StatusUnauthorized},
+		{"plain", true, nil, http.StatusUnauthorized},
+		{"disabled", false, verified, http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			a := &AdminConfig{Token: "secret", ClientCerts: c.clientCerts}
+			e := echo.New()
+			e.GET("/admin/api/players", func(c echo.Context) error {
+				return c.NoContent(http.StatusOK)
+			}, a.authenticate)
+			req := httptest.NewRequest(http.MethodGet, "/admin/api/players", nil)
+			req.TLS = c.state
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != c.expected {
+				t.Errorf("client: expected %d, received %d", c.expected, rec.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,330 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/datachannel"
+	"github.com/pion/webrtc/v2"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	netutil "github.com/criticalstack/quake-kube/internal/util/net"
+)
+
+const (
+	// rtcOpenTimeout is how long a client has to open the data channel after
+	// signalling, before the peer connection is closed.
+	rtcOpenTimeout = 30 * time.Second
+
+	// rtcGatherTimeout bounds the time taken to gather the candidates
+	// included in the answer.
+	rtcGatherTimeout = 5 * time.Second
+)
+
+// WebRTCConfig configures the WebRTC transport of the proxy.
+type WebRTCConfig struct {
+	// PublicIPs are the addresses advertised to clients in place of the
+	// addresses of the host, such as the external address of a node behind
+	// NAT.
+	PublicIPs []string
+
+	// PortMin and PortMax are the range of UDP ports used by data channels,
+	// which must be reachable by clients. Any ephemeral port is used when
+	// they are zero.
+	PortMin uint16
+	PortMax uint16
+}
+
+// ParseWebRTCFlags returns the WebRTC transport configured by the webrtc
+// flags of the commands serving the proxy, which is nil when disabled.
+func ParseWebRTCFlags(enabled bool, publicIPs []string, portRange string) (*WebRTCConfig, error) {
+	if !enabled {
+		return nil, nil
+	}
+	min, max, err := netutil.ParsePortRange(portRange)
+	if err != nil {
+		return nil, err
+	}
+	return &WebRTCConfig{
+		PublicIPs: publicIPs,
+		PortMin:   min,
+		PortMax:   max,
+	}, nil
+}
+
+// EnableWebRTC allows clients to connect with a WebRTC data channel, signalled
+// with POST /rtc, as well as a websocket.
+func (w *WebsocketUDPProxy) EnableWebRTC(cfg WebRTCConfig) error {
+	var s webrtc.SettingEngine
+	s.DetachDataChannels()
+	if len(cfg.PublicIPs) > 0 {
+		s.SetNAT1To1IPs(cfg.PublicIPs, webrtc.ICECandidateTypeHost)
+	}
+	if cfg.PortMin != 0 || cfg.PortMax != 0 {
+		if err := s.SetEphemeralUDPPortRange(cfg.PortMin, cfg.PortMax); err != nil {
+			return errors.Wrap(err, "webrtc port range")
+		}
+	}
+	w.rtc = webrtc.NewAPI(webrtc.WithSettingEngine(s))
+	return nil
+}
+
+// ServeRTC answers the SDP offer of a client, given as JSON in the body of
+// the request. The client is expected to open an unordered data channel
+// without retransmits, which carries a game packet per message like the
+// websocket.
+func (w *WebsocketUDPProxy) ServeRTC(rw http.ResponseWriter, req *http.Request) {
+	if w.rtc == nil {
+		http.NotFound(rw, req)
+		return
+	}
+	if req.Method != http.MethodPost {
+		rw.Header().Set("Allow", http.MethodPost)
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if w.ctx.Err() != nil {
+		http.Error(rw, errProxyClosed.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	// unlike a websocket, the client can be refused before it is connected,
+	// and falls back to a websocket which is then closed with the reason
+	ip, remoteAddr := w.remoteAddr(req)
+	log := w.Log.With(zap.String("remote_addr", remoteAddr), zap.String("transport", "webrtc"))
+	if err := w.Bans.Check(ip); err != nil {
+		log.Info("refused", zap.Error(err))
+		http.Error(rw, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	// a resumed session keeps its backend and the address limits taken by
+	// the original connection
+	var (
+		resuming *Session
+		backend  string
+		addr     *net.UDPAddr
+	)
+	token, resumable := resumeToken(req)
+	if token != "" {
+		sess, ok := w.Sessions.byToken(token)
+		if !ok {
+			http.Error(rw, ErrUnknownSession.Error(), http.StatusNotFound)
+			return
+		}
+		resuming = sess
+	} else {
+		var err error
+		backend, addr, err = w.backend(req, strings.TrimPrefix(req.URL.Path, "/rtc"))
+		if err != nil {
+			log.Info("refused", zap.Error(err))
+			http.Error(rw, err.Error(), backendStatus(err))
+			return
+		}
+	}
+	var offer webrtc.SessionDescription
+	if err := json.NewDecoder(io.LimitReader(req.Body, 64*1024)).Decode(&offer); err != nil {
+		http.Error(rw, "invalid offer", http.StatusBadRequest)
+		return
+	}
+	if offer.Type != webrtc.SDPTypeOffer {
+		http.Error(rw, "invalid offer", http.StatusBadRequest)
+		return
+	}
+	release := func() {}
+	if resuming == nil {
+		if err := w.limiter.acquire(ip, w.Limits, time.Now()); err != nil {
+			limitedSessions.WithLabelValues(err.Limit).Inc()
+			log.Info("refused", zap.String("limit", err.Limit), zap.Error(err))
+			http.Error(rw, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		var releaseOnce sync.Once
+		release = func() {
+			releaseOnce.Do(func() { w.limiter.release(ip) })
+		}
+	}
+
+	pc, err := w.rtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		release()
+		log.Error("cannot create peer connection", zap.Error(err))
+		http.Error(rw, "cannot create peer connection", http.StatusInternalServerError)
+		return
+	}
+
+	// only the first data channel is used, and the peer connection is closed
+	// if it is not opened in time
+	opened := make(chan struct{})
+	var openOnce sync.Once
+	pc.OnDataChannel(func(dc *webrtc.DataChannel) {
+		dc.OnOpen(func() {
+			openOnce.Do(func() {
+				close(opened)
+				raw, err := dc.Detach()
+				if err != nil {
+					log.Error("cannot detach data channel", zap.Error(err))
+					pc.Close()
+					release()
+					return
+				}
+				conn := newRTCConn(pc, raw)
+				if resuming != nil {
+					go func() {
+						r := &resumption{conn: conn, remoteAddr: remoteAddr, ip: ip, transport: "webrtc"}
+						if !resuming.resume(r) {
+							conn.Close(websocket.CloseGoingAway, ErrUnknownSession.Error())
+						}
+					}()
+					return
+				}
+				go func() {
+					defer release()
+					w.serve(w.ctx, conn, &Session{
+						RemoteAddr: remoteAddr,
+						IP:         ip,
+						Transport:  "webrtc",
+						Backend:    backend,
+						listenPort: listenPort(req),
+					}, addr, resumable)
+				}()
+			})
+		})
+	})
+	go func() {
+		select {
+		case <-opened:
+		case <-time.After(rtcOpenTimeout):
+			openOnce.Do(func() {
+				log.Info("data channel not opened", zap.Duration("timeout", rtcOpenTimeout))
+				pc.Close()
+				release()
+			})
+		}
+	}()
+
+	answer, err := answerOffer(req.Context(), pc, offer)
+	if err != nil {
+		log.Info("cannot answer offer", zap.Error(err))
+		openOnce.Do(func() {
+			pc.Close()
+			release()
+		})
+		http.Error(rw, "cannot answer offer", http.StatusBadRequest)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(rw).Encode(answer)
+}
+
+// answerOffer returns the answer to the offer, including all of the local
+// candidates, so that the client does not need to trickle candidates.
+func answerOffer(ctx context.Context, pc *webrtc.PeerConnection, offer webrtc.SessionDescription) (*webrtc.SessionDescription, error) {
+	gathered := make(chan struct{})
+	var once sync.Once
+	pc.OnICECandidate(func(c *webrtc.ICECandidate) {
+		if c == nil {
+			once.Do(func() { close(gathered) })
+		}
+	})
+	if err := pc.SetRemoteDescription(offer); err != nil {
+		return nil, errors.Wrap(err, "cannot set offer")
+	}
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create answer")
+	}
+	if err := pc.SetLocalDescription(answer); err != nil {
+		return nil, errors.Wrap(err, "cannot set answer")
+	}
+	select {
+	case <-gathered:
+	case <-time.After(rtcGatherTimeout):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return pc.LocalDescription(), nil
+}
+
+// rtcConn is a client connected with a data channel.
+type rtcConn struct {
+	failed int32
+
+	pc  *webrtc.PeerConnection
+	dc  datachannel.ReadWriteCloser
+	buf []byte
+
+	closeOnce sync.Once
+}
+
+func newRTCConn(pc *webrtc.PeerConnection, dc datachannel.ReadWriteCloser) *rtcConn {
+	c := &rtcConn{pc: pc, dc: dc, buf: make([]byte, maxPacketSize)}
+	pc.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
+		// unblock ReadPacket when the client goes away without closing the
+		// data channel
+		if state == webrtc.ICEConnectionStateFailed || state == webrtc.ICEConnectionStateClosed {
+			atomic.StoreInt32(&c.failed, 1)
+			c.dc.Close()
+		}
+	})
+	return c
+}
+
+// ReadPacket returns the next binary message, which is only valid until the
+// next call. Text messages are ignored.
+func (c *rtcConn) ReadPacket() ([]byte, error) {
+	for {
+		n, isString, err := c.dc.ReadDataChannel(c.buf)
+		if err == io.EOF {
+			// the connection dropping is reported like a websocket that
+			// closed without a close message
+			if atomic.LoadInt32(&c.failed) != 0 {
+				return nil, &websocket.CloseError{Code: websocket.CloseAbnormalClosure, Text: "connection failed"}
+			}
+			return nil, &websocket.CloseError{Code: websocket.CloseNormalClosure, Text: "data channel closed"}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !isString {
+			return c.buf[:n], nil
+		}
+	}
+}
+
+func (c *rtcConn) WritePacket(b []byte) error {
+	_, err := c.dc.WriteDataChannel(b, false)
+	return err
+}
+
+// rtcCloseMessage is sent as a text message before the data channel is
+// closed, since data channels do not have close codes.
+type rtcCloseMessage struct {
+	Code   int    `json:"code"`
+	Reason string `json:"reason"`
+}
+
+func (c *rtcConn) Close(code int, text string) error {
+	var err error
+	c.closeOnce.Do(func() {
+		if msg, merr := json.Marshal(rtcCloseMessage{Code: code, Reason: text}); merr == nil {
+			_, err = c.dc.WriteDataChannel(msg, true)
+		}
+		// give the close message a moment to be sent before the association
+		// is torn down
+		time.AfterFunc(time.Second, func() {
+			c.dc.Close()
+			c.pc.Close()
+		})
+	})
+	return err
+}
@@ -0,0 +1,241 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v2"
+
+	"github.com/criticalstack/quake-kube/internal/quake/bans"
+)
+
+func TestWebRTC(t *testing.T) {
+	// the backend echoes packets back to the proxy
+	backend, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	go func() {
+		buf := make([]byte, maxPacketSize)
+		for {
+			n, addr, err := backend.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			backend.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	p, err := NewProxy(backend.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.EnableWebRTC(WebRTCConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	bl, err := bans.Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Bans = bl
+	bl.OnChange(p.CloseBanned)
+	srv := httptest.NewServer(p)
+	defer srv.Close()
+
+	pc, dc, received := openRTC(t, srv.URL)
+	defer pc.Close()
+
+	// the proxy sends its port first, and the port message of the client is
+	// not forwarded, so only the second packet is echoed
+	port := srv.Listener.Addr().(*net.TCPAddr).Port
+	select {
+	case msg := <-received:
+		if string(msg) != string(portMessage(port)) {
+			t.Fatalf("client: expected port message, received %q", msg)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("client: port message not received")
+	}
+	if err := dc.Send([]byte("\xff\xff\xff\xffport\x6d\x38")); err != nil {
+		t.Fatal(err)
+	}
+	if err := dc.Send([]byte("\xff\xff\xff\xffgetinfo")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-received:
+		if string(msg) != "\xff\xff\xff\xffgetinfo" {
+			t.Fatalf("client: unexpected packet %q", msg)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("client: packet not echoed")
+	}
+
+	sessions := p.Sessions.List()
+	if len(sessions) != 1 || sessions[0].Transport != "webrtc" || sessions[0].ClientPort != 27960 {
+		t.Fatalf("client: expected a webrtc session, received %+v", sessions)
+	}
+
+	// data channels have no close code, so it is sent as text
+	if _, err := bl.Ban("127.0.0.1", "test", 0); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-received:
+		var m rtcCloseMessage
+		if err := json.Unmarshal(msg, &m); err != nil {
+			t.Fatalf("client: unexpected message %q", msg)
+		}
+		if m.Code != websocket.ClosePolicyViolation {
+			t.Fatalf("client: expected close code %d, received %d", websocket.ClosePolicyViolation, m.Code)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("client: close message not received")
+	}
+}
+
+// openRTC connects a WebRTC client to the proxy at url, returning the data
+// channel once it is open and the messages received on it.
+func openRTC(t *testing.T, url string) (*webrtc.PeerConnection, *webrtc.DataChannel, <-chan []byte) {
+	t.Helper()
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ordered := false
+	var retransmits uint16
+	dc, err := pc.CreateDataChannel("quake", &webrtc.DataChannelInit{Ordered: &ordered, MaxRetransmits: &retransmits})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened := make(chan struct{})
+	received := make(chan []byte, 4)
+	dc.OnOpen(func() { close(opened) })
+	dc.OnMessage(func(msg webrtc.DataChannelMessage) {
+		received <- msg.Data
+	})
+
+	gathered := make(chan struct{})
+	pc.OnICECandidate(func(c *webrtc.ICECandidate) {
+		if c == nil {
+			close(gathered)
+		}
+	})
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pc.SetLocalDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	<-gathered
+	body, err := json.Marshal(pc.LocalDescription())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(url+"/rtc", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("client: expected %d, received %d", http.StatusOK, resp.StatusCode)
+	}
+	var answer webrtc.SessionDescription
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		t.Fatal(err)
+	}
+	if err := pc.SetRemoteDescription(answer); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-opened:
+	case <-time.After(10 * time.Second):
+		t.Fatal("client: data channel not opened")
+	}
+
+	return pc, dc, received
+}
+
+func TestWebRTCClose(t *testing.T) {
+	backend, _ := echoBackend(t)
+	defer backend.Close()
+	p, err := NewProxy(backend.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.EnableWebRTC(WebRTCConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(p)
+	defer srv.Close()
+
+	pc, _, received := openRTC(t, srv.URL)
+	defer pc.Close()
+	select {
+	case <-received:
+	case <-time.After(10 * time.Second):
+		t.Fatal("client: port message not received")
+	}
+
+	// sessions end with the proxy rather than the signalling request
+	p.Close()
+	select {
+	case msg := <-received:
+		var m rtcCloseMessage
+		if err := json.Unmarshal(msg, &m); err != nil {
+			t.Fatalf("client: unexpected message %q", msg)
+		}
+		if m.Code != websocket.CloseGoingAway {
+			t.Fatalf("client: expected close code %d, received %d", websocket.CloseGoingAway, m.Code)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("client: close message not received")
+	}
+
+	resp, err := http.Post(srv.URL+"/rtc", "application/json", bytes.NewReader([]byte("{}")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("client: expected %d, received %d", http.StatusServiceUnavailable, resp.StatusCode)
+	}
+}
+
+func TestWebRTCDisabled(t *testing.T) {
+	p, err := NewProxy("127.0.0.1:27960")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/rtc", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("client: expected %d, received %d", http.StatusNotFound, rec.Code)
+	}
+}
+
+func TestParseWebRTCFlags(t *testing.T) {
+	cfg, err := ParseWebRTCFlags(false, []string{"203.0.113.7"}, "50000-50100")
+	if err != nil || cfg != nil {
+		t.Fatalf("client: expected no config when disabled, received %+v, %v", cfg, err)
+	}
+	cfg, err = ParseWebRTCFlags(true, []string{"203.0.113.7"}, "50000-50100")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.PortMin != 50000 || cfg.PortMax != 50100 || len(cfg.PublicIPs) != 1 {
+		t.Errorf("client: unexpected config %+v", cfg)
+	}
+	if _, err := ParseWebRTCFlags(true, nil, "50100-50000"); err == nil {
+		t.Error("client: expected invalid port range to be refused")
+	}
+}
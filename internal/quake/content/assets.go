@@ -0,0 +1,104 @@
+package content
+
+import (
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// immutable is the Cache-Control of assets requested by checksum, which never
+// change as a different file has a different URL.
+const immutable = "public, max-age=31536000, immutable"
+
+// parseAssetName returns the name of an asset requested as
+// <dir>/<checksum>-<name>, and the checksum. The name is returned unchanged
+// when it is not prefixed with a checksum.
+func parseAssetName(s string) (string, uint32, bool) {
+	dir, file := path.Split(s)
+	i := strings.Index(file, "-")
+	if i <= 0 {
+		return s, 0, false
+	}
+	n, err := strconv.ParseUint(file[:i], 10, 32)
+	if err != nil {
+		return s, 0, false
+	}
+	return path.Join(dir, file[i+1:]), uint32(n), true
+}
+
+// assetURL is the URL of an asset with the checksum.
+func assetURL(name string, checksum uint32) string {
+	dir, file := path.Split(name)
+	return "/assets/" + dir + fmt.Sprintf("%d-%s", checksum, file)
+}
+
+// fileChecksum returns the CRC-32 of the file, as listed in the manifest.
+func fileChecksum(path string) (uint32, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	h := crc32.NewIEEE()
+	if _, err := io.Copy(h, f); err != nil {
+		return 0, err
+	}
+	return h.Sum32(), nil
+}
+
+// serveAsset serves the asset requested by checksum. A checksum that does not
+// match is redirected to the URL of the current file, as the client has an
+// old manifest. Range and conditional requests are handled by
+// http.ServeContent using the checksum as the ETag.
+func serveAsset(c echo.Context, index *Index) error {
+	name, checksum, ok := parseAssetName(c.Param("*"))
+	// the name cannot leave the assets directory, which also holds files
+	// that are not assets such as the bans of the game server
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if !hasExts(name, assetExts...) {
+		return c.String(http.StatusNotFound, "file not found")
+	}
+	p := filepath.Join(index.dir, filepath.FromSlash(name))
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return c.String(http.StatusNotFound, "file not found")
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		return c.String(http.StatusNotFound, "file not found")
+	}
+	actual, err := index.checksum(name, fi)
+	if err != nil {
+		return err
+	}
+
+	h := c.Response().Header()
+	if ok && actual != checksum {
+		h.Set("Cache-Control", "no-cache")
+		return c.Redirect(http.StatusFound, assetURL(name, actual))
+	}
+	h.Set("ETag", fmt.Sprintf(`"%d"`, actual))
+	if ok {
+		h.Set("Cache-Control", immutable)
+	} else {
+		// the same URL serves the file after it changes
+		h.Set("Cache-Control", "no-cache")
+	}
+	http.ServeContent(c.Response(), c.Request(), fi.Name(), fi.ModTime(), f)
+	return nil
+}
@@ -0,0 +1,132 @@
+package content
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// DefaultAuditFile is the name of the audit log in the assets directory.
+const DefaultAuditFile = ".audit.jsonl"
+
+// AuditRecord is an upload or deletion of a map pack, written to the audit
+// log as a line of JSON.
+type AuditRecord struct {
+	Time     time.Time `json:"time"`
+	Action   string    `json:"action"`
+	Uploader string    `json:"uploader"`
+	File     string    `json:"file"`
+	Size     int64     `json:"size,omitempty"`
+	Checksum uint32    `json:"checksum,omitempty"`
+	RemoteIP string    `json:"remote_ip"`
+}
+
+const (
+	auditUpload = "upload"
+	auditDelete = "delete"
+)
+
+// auditLog appends each upload and deletion to a file, which is replayed on
+// start to know which uploader owns each pak for its quotas.
+type auditLog struct {
+	file string
+
+	mu     sync.Mutex
+	owners map[string]string
+}
+
+// openAuditLog replays the audit log in file. The log is only kept in memory
+// when file is empty.
+func openAuditLog(file string) (*auditLog, error) {
+	a := &auditLog{file: file, owners: make(map[string]string)}
+	if file == "" {
+		return a, nil
+	}
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		var r AuditRecord
+		if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+			// a line cut short by a crash is skipped
+			zap.L().Named("content").Warn("cannot read audit record", zap.String("file", file), zap.Int("line", line), zap.Error(err))
+			continue
+		}
+		a.apply(r)
+	}
+	if err := s.Err(); err != nil {
+		return nil, errors.Wrapf(err, "cannot read %s", file)
+	}
+	return a, nil
+}
+
+func (a *auditLog) apply(r AuditRecord) {
+	switch r.Action {
+	case auditUpload:
+		a.owners[r.File] = r.Uploader
+	case auditDelete:
+		delete(a.owners, r.File)
+	}
+}
+
+// record appends the record to the log. The caller must hold mu.
+func (a *auditLog) record(r AuditRecord) error {
+	a.apply(r)
+	zap.L().Named("content").Info("audit",
+		zap.String("action", r.Action),
+		zap.String("uploader", r.Uploader),
+		zap.String("file", r.File),
+		zap.Int64("size", r.Size),
+		zap.Uint32("checksum", r.Checksum),
+		zap.String("remote_ip", r.RemoteIP),
+	)
+	if a.file == "" {
+		return nil
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(a.file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.Wrap(err, "cannot write audit log")
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return errors.Wrap(err, "cannot write audit log")
+	}
+	return f.Close()
+}
+
+// owner returns the uploader of the pak. The caller must hold mu.
+func (a *auditLog) owner(name string) (string, bool) {
+	owner, ok := a.owners[name]
+	return owner, ok
+}
+
+// usage returns the total size and number of the paks of the uploader that
+// are still in the index. The caller must hold mu.
+func (a *auditLog) usage(uploader string, index *Index) (size int64, files int) {
+	for name, owner := range a.owners {
+		if owner != uploader {
+			continue
+		}
+		if e, ok := index.entry(name); ok {
+			size += e.Size
+			files++
+		}
+	}
+	return size, files
+}
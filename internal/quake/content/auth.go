@@ -0,0 +1,128 @@
+package content
+
+import (
+	"crypto/subtle"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+)
+
+// Uploader is allowed to upload map packs, and to delete the map packs it
+// uploaded, authenticating with a bearer token or with basic auth using its
+// name and password.
+type Uploader struct {
+	Name     string `json:"name"`
+	Token    string `json:"token,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	// MaxBytes and MaxFiles limit the total size and number of the paks
+	// uploaded by the uploader that are still on the server. There is no
+	// limit when they are zero.
+	MaxBytes int64 `json:"maxBytes,omitempty"`
+	MaxFiles int   `json:"maxFiles,omitempty"`
+}
+
+type uploadersFile struct {
+	Uploaders []*Uploader `json:"uploaders"`
+}
+
+// LoadUploaders reads the uploaders from a YAML or JSON file, e.g.:
+//
+//	uploaders:
+//	- name: mapper
+//	  token: 0123456789abcdef
+//	  maxBytes: 1073741824
+//	  maxFiles: 20
+//	- name: admin
+//	  password: secret
+func LoadUploaders(path string) ([]*Uploader, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f uploadersFile
+	if err := yaml.Unmarshal(data, &f); err != nil {
+		return nil, errors.Wrapf(err, "cannot read %s", path)
+	}
+	if err := validateUploaders(f.Uploaders); err != nil {
+		return nil, errors.Wrapf(err, "cannot read %s", path)
+	}
+	return f.Uploaders, nil
+}
+
+func validateUploaders(uploaders []*Uploader) error {
+	names := make(map[string]bool)
+	tokens := make(map[string]bool)
+	for _, u := range uploaders {
+		if u.Name == "" || strings.ContainsAny(u.Name, ":\r\n") {
+			return errors.Errorf("invalid uploader name: %q", u.Name)
+		}
+		if names[u.Name] {
+			return errors.Errorf("duplicate uploader: %q", u.Name)
+		}
+		names[u.Name] = true
+		if u.Token == "" && u.Password == "" {
+			return errors.Errorf("uploader %q has no token or password", u.Name)
+		}
+		if u.Token != "" {
+			if tokens[u.Token] {
+				return errors.Errorf("uploader %q has the token of another uploader", u.Name)
+			}
+			tokens[u.Token] = true
+		}
+		if u.MaxBytes < 0 || u.MaxFiles < 0 {
+			return errors.Errorf("uploader %q has a negative quota", u.Name)
+		}
+	}
+	return nil
+}
+
+// uploaderKey is the context key of the authenticated uploader.
+const uploaderKey = "uploader"
+
+// authenticate checks the request for the bearer token or basic auth
+// credentials of an uploader. Uploads are disabled when there are no
+// uploaders.
+func authenticate(uploaders []*Uploader) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if len(uploaders) == 0 {
+				return echo.NewHTTPError(http.StatusForbidden, "uploads are disabled")
+			}
+			auth := c.Request().Header.Get(echo.HeaderAuthorization)
+			if strings.HasPrefix(auth, "Bearer ") {
+				token := strings.TrimPrefix(auth, "Bearer ")
+				// every token is compared, so the time taken does not
+				// depend on which uploader matched
+				var found *Uploader
+				for _, u := range uploaders {
+					if u.Token != "" && secureCompare(token, u.Token) {
+						found = u
+					}
+				}
+				if found != nil {
+					c.Set(uploaderKey, found)
+					return next(c)
+				}
+			}
+			if name, pass, ok := c.Request().BasicAuth(); ok {
+				for _, u := range uploaders {
+					if u.Password != "" && secureCompare(name, u.Name) && secureCompare(pass, u.Password) {
+						c.Set(uploaderKey, u)
+						return next(c)
+					}
+				}
+			}
+			c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="quake-kube content"`)
+			return echo.NewHTTPError(http.StatusUnauthorized, "invalid or missing credentials")
+		}
+	}
+}
+
+func secureCompare(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,78 @@
+package content
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLoadUploaders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "content")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		name     string
+		data     string
+		expected []*Uploader
+		err      bool
+	}{
+		{
+			name: "uploaders",
+			data: `
+uploaders:
+- name: mapper
+  token: abc
+  maxBytes: 1024
+  maxFiles: 2
+- name: admin
+  password: secret
+`,
+			expected: []*Uploader{
+				{Name: "mapper", Token: "abc", MaxBytes: 1024, MaxFiles: 2},
+				{Name: "admin", Password: "secret"},
+			},
+		},
+		{
+			name: "no credentials",
+			data: "uploaders:\n- name: mapper\n",
+			err:  true,
+		},
+		{
+			name: "duplicate token",
+			data: "uploaders:\n- name: a\n  token: abc\n- name: b\n  token: abc\n",
+			err:  true,
+		},
+		{
+			name: "negative quota",
+			data: "uploaders:\n- name: a\n  token: abc\n  maxFiles: -1\n",
+			err:  true,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			path := filepath.Join(dir, "uploaders.yaml")
+			if err := ioutil.WriteFile(path, []byte(c.data), 0600); err != nil {
+				t.Fatal(err)
+			}
+			uploaders, err := LoadUploaders(path)
+			if c.err {
+				if err == nil {
+					t.Fatal("content: expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(c.expected, uploaders); diff != "" {
+				t.Errorf("content: after LoadUploaders differs: (-want +got)\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package content
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ListBots returns the names of all bots defined by the pk3 files found in
+// dir. Bots are defined in scripts/bots.txt, or in any scripts/*.bot files
+// provided by add-on packs.
+func ListBots(dir string) (result []string, err error) {
+	seen := make(map[string]bool)
+	err = walk(dir, func(path string, info os.FileInfo, err error) error {
+		mp, err := OpenMapPack(path)
+		if err != nil {
+			return err
+		}
+		defer mp.Close()
+
+		bots, err := mp.Bots()
+		if err != nil {
+			return err
+		}
+		for _, name := range bots {
+			if seen[strings.ToLower(name)] {
+				continue
+			}
+			seen[strings.ToLower(name)] = true
+			result = append(result, name)
+		}
+		return nil
+	}, ".pk3")
+	return
+}
+
+// Bots returns the names of the bots defined in the pack.
+func (m *MapPack) Bots() ([]string, error) {
+	bots := make([]string, 0)
+	for _, f := range m.Reader.File {
+		name := strings.ToLower(f.Name)
+		if filepath.Dir(name) != "scripts" {
+			continue
+		}
+		if filepath.Base(name) != "bots.txt" && !hasExts(name, ".bot") {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		names, err := parseBotNames(r)
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+		bots = append(bots, names...)
+	}
+	return bots, nil
+}
+
+// parseBotNames reads the bot definitions in the bots.txt format, returning
+// the value of every name key:
+//
+//	{
+//	name  Sarge
+//	model sarge
+//	}
+func parseBotNames(r io.Reader) ([]string, error) {
+	names := make([]string, 0)
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.EqualFold(fields[0], "name") {
+			continue
+		}
+		names = append(names, strings.Trim(strings.Join(fields[1:], " "), `"`))
+	}
+	return names, s.Err()
+}
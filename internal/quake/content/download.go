@@ -12,21 +12,69 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 
-	httputil "github.com/criticalstack/quake-kube/internal/util/net/http"
 	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	httputil "github.com/criticalstack/quake-kube/internal/util/net/http"
 )
 
-func CopyAssets(u *url.URL, dir string) error {
+// Progress is the progress of CopyAssets, which can be read while the assets
+// are downloading.
+type Progress struct {
+	mu       sync.Mutex
+	done     int
+	total    int
+	finished bool
+	err      error
+}
+
+// Files returns how many files in the manifest have been copied, out of the
+// total. The total is 0 until the manifest has been downloaded.
+func (p *Progress) Files() (done, total int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.done, p.total
+}
+
+// Finished returns whether CopyAssets has returned, and the error it returned.
+func (p *Progress) Finished() (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.finished, p.err
+}
+
+func (p *Progress) update(f func()) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f()
+}
+
+// CopyAssets downloads the files in the manifest of the content server that
+// are not already in dir. The progress is updated as each file is copied,
+// when set.
+func CopyAssets(u *url.URL, dir string, progress *Progress) (err error) {
+	defer func() {
+		progress.update(func() { progress.finished, progress.err = true, err })
+	}()
+
+	log := zap.L().Named("content")
 	url := strings.TrimSuffix(u.String(), "/")
 	files, err := getManifest(url)
 	if err != nil {
 		return err
 	}
+	progress.update(func() { progress.total = len(files) })
+	log.Info("copying assets", zap.String("url", url), zap.Int("files", len(files)))
 
 	for _, f := range files {
 		path := filepath.Join(dir, f.Name)
 		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			progress.update(func() { progress.done++ })
 			continue
 		}
 		data, err := httputil.GetBody(url + fmt.Sprintf("/assets/%d-%s", f.Checksum, f.Name))
@@ -39,6 +87,7 @@ func CopyAssets(u *url.URL, dir string) error {
 		if err := ioutil.WriteFile(path, data, 0644); err != nil {
 			return err
 		}
+		log.Info("downloaded", zap.String("file", f.Name), zap.Int("bytes", len(data)))
 		if strings.HasPrefix(f.Name, "linuxq3ademo") {
 			if err := extractDemoPack(path, dir); err != nil {
 				return err
@@ -49,6 +98,7 @@ func CopyAssets(u *url.URL, dir string) error {
 				return err
 			}
 		}
+		progress.update(func() { progress.done++ })
 	}
 	return nil
 }
@@ -95,7 +145,7 @@ func extractDemoPack(path, dir string) error {
 			return err
 		}
 		if strings.HasSuffix(hdr.Name, ".pk3") {
-			fmt.Printf("Downloaded %s\n", hdr.Name)
+			zap.L().Named("content").Info("extracted", zap.String("file", hdr.Name), zap.String("archive", filepath.Base(path)))
 			data, err := ioutil.ReadAll(tr)
 			if err != nil {
 				return err
@@ -139,7 +189,7 @@ func extractPointPacks(path, dir string) error {
 			return err
 		}
 		if strings.HasSuffix(hdr.Name, ".pk3") {
-			fmt.Printf("Downloaded %s\n", hdr.Name)
+			zap.L().Named("content").Info("extracted", zap.String("file", hdr.Name), zap.String("archive", filepath.Base(path)))
 			data, err := ioutil.ReadAll(tr)
 			if err != nil {
 				return err
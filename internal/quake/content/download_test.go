@@ -0,0 +1,70 @@
+package content
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopyAssetsProgress(t *testing.T) {
+	files := []*File{
+		{Name: "baseq3/pak0.pk3", Checksum: 1},
+		{Name: "baseq3/map-q3dm17.pk3", Checksum: 2},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/assets/manifest.json":
+			json.NewEncoder(w).Encode(files)
+		case "/assets/2-baseq3/map-q3dm17.pk3":
+			w.Write([]byte("map"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "assets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// pak0.pk3 has already been copied
+	if err := os.MkdirAll(filepath.Join(dir, "baseq3"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "baseq3/pak0.pk3"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := &Progress{}
+	if finished, _ := p.Finished(); finished {
+		t.Fatal("content: expected progress to be unfinished")
+	}
+	if err := CopyAssets(u, dir, p); err != nil {
+		t.Fatal(err)
+	}
+	if done, total := p.Files(); done != 2 || total != 2 {
+		t.Errorf("content: expected 2/2 files, received %d/%d", done, total)
+	}
+	if finished, err := p.Finished(); !finished || err != nil {
+		t.Errorf("content: expected progress to be finished, received %v, %v", finished, err)
+	}
+
+	files = append(files, &File{Name: "baseq3/missing.pk3", Checksum: 3})
+	if err := CopyAssets(u, dir, p); err == nil {
+		t.Fatal("content: expected missing file to fail")
+	}
+	if finished, err := p.Finished(); !finished || err == nil {
+		t.Errorf("content: expected progress to record the error, received %v, %v", finished, err)
+	}
+}
@@ -1,8 +1,6 @@
 package content
 
 import (
-	"hash/crc32"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
@@ -14,20 +12,6 @@ type File struct {
 	Checksum   uint32 `json:"checksum"`
 }
 
-func getAssets(dir string) (files []*File, err error) {
-	err = walk(dir, func(path string, info os.FileInfo, err error) error {
-		data, err := ioutil.ReadFile(path)
-		if err != nil {
-			return err
-		}
-		n := crc32.ChecksumIEEE(data)
-		path = strings.TrimPrefix(path, dir+"/")
-		files = append(files, &File{path, info.Size(), n})
-		return nil
-	}, ".pk3", ".sh", ".run")
-	return
-}
-
 func hasExts(path string, exts ...string) bool {
 	for _, ext := range exts {
 		if strings.HasSuffix(path, ext) {
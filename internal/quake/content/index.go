@@ -0,0 +1,246 @@
+package content
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// assetExts are the files listed in the manifest and served as assets.
+var assetExts = []string{".pk3", ".sh", ".run"}
+
+// DefaultIndexFile is the name of the index in the assets directory.
+const DefaultIndexFile = ".index.json"
+
+// Index is the checksum of each asset, cached by path, size and modification
+// time so that a file is only hashed again when it changes. The index is
+// saved to a file, so that assets are not hashed again on restart, and the
+// manifest is kept in memory.
+type Index struct {
+	dir  string
+	file string
+
+	// refreshMu serializes refreshes, which hash files without holding mu.
+	refreshMu sync.Mutex
+
+	mu       sync.RWMutex
+	entries  map[string]indexEntry
+	manifest []byte
+	etag     string
+}
+
+type indexEntry struct {
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"modTime"`
+	Checksum uint32    `json:"checksum"`
+}
+
+func (e indexEntry) matches(fi os.FileInfo) bool {
+	return e.Size == fi.Size() && e.ModTime.Equal(fi.ModTime())
+}
+
+// OpenIndex loads the index saved in file, then brings it up to date with
+// the assets in dir. The index is only kept in memory when file is empty.
+func OpenIndex(dir, file string) (*Index, error) {
+	x := &Index{dir: dir, file: file, entries: make(map[string]indexEntry)}
+	if file != "" {
+		data, err := ioutil.ReadFile(file)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			if err := json.Unmarshal(data, &x.entries); err != nil {
+				// rebuilt from the assets
+				zap.L().Named("content").Warn("cannot read index", zap.String("file", file), zap.Error(err))
+				x.entries = make(map[string]indexEntry)
+			}
+		}
+	}
+	if err := x.Refresh(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// Refresh hashes the assets that are new or have changed since they were
+// last hashed, and forgets those that have been removed.
+func (x *Index) Refresh() error {
+	x.refreshMu.Lock()
+	defer x.refreshMu.Unlock()
+
+	x.mu.RLock()
+	prev := x.entries
+	x.mu.RUnlock()
+
+	entries := make(map[string]indexEntry)
+	changed := false
+	err := walk(x.dir, func(path string, info os.FileInfo, err error) error {
+		rel, err := filepath.Rel(x.dir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if e, ok := prev[name]; ok && e.matches(info) {
+			entries[name] = e
+			return nil
+		}
+		n, err := fileChecksum(path)
+		if err != nil {
+			return err
+		}
+		entries[name] = indexEntry{Size: info.Size(), ModTime: info.ModTime(), Checksum: n}
+		changed = true
+		zap.L().Named("content").Debug("indexed", zap.String("file", name), zap.Uint32("checksum", n))
+		return nil
+	}, assetExts...)
+	if err != nil {
+		return err
+	}
+	if len(entries) != len(prev) {
+		changed = true
+	}
+	if !changed && x.manifest != nil {
+		return nil
+	}
+	return x.replace(entries)
+}
+
+// update hashes a single asset, such as one that has just been uploaded.
+func (x *Index) update(name string) (indexEntry, error) {
+	x.refreshMu.Lock()
+	defer x.refreshMu.Unlock()
+
+	path := filepath.Join(x.dir, filepath.FromSlash(name))
+	fi, err := os.Stat(path)
+	if err != nil {
+		return indexEntry{}, err
+	}
+	n, err := fileChecksum(path)
+	if err != nil {
+		return indexEntry{}, err
+	}
+	e := indexEntry{Size: fi.Size(), ModTime: fi.ModTime(), Checksum: n}
+
+	x.mu.RLock()
+	entries := make(map[string]indexEntry, len(x.entries)+1)
+	for k, v := range x.entries {
+		entries[k] = v
+	}
+	x.mu.RUnlock()
+	entries[name] = e
+	return e, x.replace(entries)
+}
+
+// remove forgets an asset that has been deleted.
+func (x *Index) remove(name string) error {
+	x.refreshMu.Lock()
+	defer x.refreshMu.Unlock()
+
+	x.mu.RLock()
+	entries := make(map[string]indexEntry, len(x.entries))
+	for k, v := range x.entries {
+		if k != name {
+			entries[k] = v
+		}
+	}
+	x.mu.RUnlock()
+	return x.replace(entries)
+}
+
+// replace swaps in the entries, rebuilding the manifest and saving the index.
+// The caller must hold refreshMu.
+func (x *Index) replace(entries map[string]indexEntry) error {
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	files := make([]*File, 0, len(names))
+	for _, name := range names {
+		e := entries[name]
+		files = append(files, &File{Name: name, Compressed: e.Size, Checksum: e.Checksum})
+	}
+	manifest, err := json.MarshalIndent(files, "", "   ")
+	if err != nil {
+		return err
+	}
+
+	x.mu.Lock()
+	x.entries = entries
+	x.manifest = manifest
+	x.etag = fmt.Sprintf(`"%08x"`, crc32.ChecksumIEEE(manifest))
+	x.mu.Unlock()
+	return x.save(entries)
+}
+
+func (x *Index) save(entries map[string]indexEntry) error {
+	if x.file == "" {
+		return nil
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(x.file+".tmp", data, 0644); err != nil {
+		return errors.Wrapf(err, "cannot save index")
+	}
+	return os.Rename(x.file+".tmp", x.file)
+}
+
+// Manifest returns the manifest of the assets as JSON, and its ETag.
+func (x *Index) Manifest() ([]byte, string) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return x.manifest, x.etag
+}
+
+func (x *Index) entry(name string) (indexEntry, bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	e, ok := x.entries[name]
+	return e, ok
+}
+
+// checksum returns the checksum of the asset, hashing it again when it has
+// changed since it was indexed.
+func (x *Index) checksum(name string, fi os.FileInfo) (uint32, error) {
+	x.mu.RLock()
+	e, ok := x.entries[name]
+	x.mu.RUnlock()
+	if ok && e.matches(fi) {
+		return e.Checksum, nil
+	}
+	e, err := x.update(name)
+	if err != nil {
+		return 0, err
+	}
+	return e.Checksum, nil
+}
+
+// Watch refreshes the index until the context is done, to notice assets that
+// are added, changed or removed by other processes.
+func (x *Index) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := x.Refresh(); err != nil {
+				zap.L().Named("content").Error("cannot refresh index", zap.Error(err))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
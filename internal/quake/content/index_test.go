@@ -0,0 +1,155 @@
+package content
+
+import (
+	"encoding/json"
+	"hash/crc32"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "content")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "baseq3"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"baseq3/pak0.pk3":         "pak0",
+		"baseq3/map-q3dm17.pk3":   "q3dm17",
+		"linuxq3ademo-1.11-6.sh":  "demo",
+		"bans.json":               "[]",
+		"baseq3/server.cfg.extra": "seta",
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	file := filepath.Join(dir, DefaultIndexFile)
+
+	manifest := func(x *Index) []*File {
+		data, _ := x.Manifest()
+		var files []*File
+		if err := json.Unmarshal(data, &files); err != nil {
+			t.Fatal(err)
+		}
+		return files
+	}
+	asset := func(name, data string) *File {
+		return &File{Name: name, Compressed: int64(len(data)), Checksum: crc32.ChecksumIEEE([]byte(data))}
+	}
+
+	x, err := OpenIndex(dir, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []*File{
+		asset("baseq3/map-q3dm17.pk3", "q3dm17"),
+		asset("baseq3/pak0.pk3", "pak0"),
+		asset("linuxq3ademo-1.11-6.sh", "demo"),
+	}
+	if diff := cmp.Diff(expected, manifest(x)); diff != "" {
+		t.Errorf("content: manifest differs: (-want +got)\n%s", diff)
+	}
+	_, etag := x.Manifest()
+
+	// a saved entry is trusted while the size and modification time of the
+	// file are unchanged, so a reopened index does not hash the file again
+	var saved map[string]indexEntry
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	e := saved["baseq3/pak0.pk3"]
+	e.Checksum = 1
+	saved["baseq3/pak0.pk3"] = e
+	data, _ = json.Marshal(saved)
+	if err := ioutil.WriteFile(file, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	x, err = OpenIndex(dir, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files := manifest(x); files[1].Checksum != 1 {
+		t.Errorf("content: expected saved checksum to be reused, received %d", files[1].Checksum)
+	}
+	if _, reopened := x.Manifest(); reopened == etag {
+		t.Error("content: expected ETag to change with the manifest")
+	}
+
+	// changed and removed files are noticed by a refresh
+	if err := ioutil.WriteFile(filepath.Join(dir, "baseq3/pak0.pk3"), []byte("pak0 v2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "linuxq3ademo-1.11-6.sh")); err != nil {
+		t.Fatal(err)
+	}
+	if err := x.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	expected = []*File{
+		asset("baseq3/map-q3dm17.pk3", "q3dm17"),
+		asset("baseq3/pak0.pk3", "pak0 v2"),
+	}
+	if diff := cmp.Diff(expected, manifest(x)); diff != "" {
+		t.Errorf("content: after refresh manifest differs: (-want +got)\n%s", diff)
+	}
+}
+
+func TestServeManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "content")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, data := range map[string]string{"pak0.pk3": "pak0", "bans.json": "[]"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	e, err := NewRouter(&Config{AssetsDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(url string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/assets/manifest.json")
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" {
+		t.Fatalf("content: expected manifest with an ETag, received %d %v", rec.Code, rec.Header())
+	}
+	var files []*File
+	if err := json.Unmarshal(rec.Body.Bytes(), &files); err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name != "pak0.pk3" {
+		t.Errorf("content: expected only pak0.pk3 in manifest, received %+v", files)
+	}
+	if rec := get("/assets/manifest.json", "If-None-Match", etag); rec.Code != http.StatusNotModified {
+		t.Errorf("content: expected 304 for matching ETag, received %d", rec.Code)
+	}
+	if rec := get("/assets/bans.json"); rec.Code != http.StatusNotFound {
+		t.Errorf("content: expected files that are not assets to be 404, received %d", rec.Code)
+	}
+}
@@ -44,10 +44,12 @@ func OpenMapPack(path string) (*MapPack, error) {
 	}
 	info, err := f.Stat()
 	if err != nil {
+		f.Close()
 		return nil, err
 	}
 	r, err := zip.NewReader(f, info.Size())
 	if err != nil {
+		f.Close()
 		return nil, err
 	}
 	mp := &MapPack{
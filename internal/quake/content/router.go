@@ -1,25 +1,75 @@
 package content
 
 import (
-	"archive/zip"
-	"fmt"
-	"io"
 	"net/http"
-	"os"
-	"path/filepath"
-	"strings"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"go.uber.org/zap"
+
+	logutil "github.com/criticalstack/quake-kube/internal/util/log"
+	httputil "github.com/criticalstack/quake-kube/internal/util/net/http"
 )
 
 type Config struct {
 	AssetsDir string
+
+	// Index is the checksum of each asset, which is built when the router is
+	// created if nil.
+	Index *Index
+
+	// GameDirs are the game directories that map packs can be uploaded to,
+	// DefaultGameDirs if empty.
+	GameDirs []string
+
+	// MaxUploadSize and MaxUnpackedSize limit the size of an upload, and how
+	// much it may decompress to. DefaultMaxUploadSize and
+	// DefaultMaxUnpackedSize are used if zero.
+	MaxUploadSize   int64
+	MaxUnpackedSize int64
+
+	// Uploaders can upload and delete map packs, which is disabled when
+	// there are none.
+	Uploaders []*Uploader
+
+	// AuditFile is where uploads and deletions are recorded, which is only
+	// kept in memory if empty.
+	AuditFile string
 }
 
 func NewRouter(cfg *Config) (*echo.Echo, error) {
+	log := zap.L().Named("content")
+	index := cfg.Index
+	if index == nil {
+		var err error
+		index, err = OpenIndex(cfg.AssetsDir, "")
+		if err != nil {
+			return nil, err
+		}
+	}
+	audit, err := openAuditLog(cfg.AuditFile)
+	if err != nil {
+		return nil, err
+	}
+	u := &uploader{
+		dir:             cfg.AssetsDir,
+		gameDirs:        cfg.GameDirs,
+		maxUploadSize:   cfg.MaxUploadSize,
+		maxUnpackedSize: cfg.MaxUnpackedSize,
+		index:           index,
+		audit:           audit,
+	}
+	if len(u.gameDirs) == 0 {
+		u.gameDirs = DefaultGameDirs
+	}
+	if u.maxUploadSize == 0 {
+		u.maxUploadSize = DefaultMaxUploadSize
+	}
+	if u.maxUnpackedSize == 0 {
+		u.maxUnpackedSize = DefaultMaxUnpackedSize
+	}
 	e := echo.New()
-	e.Use(middleware.Logger())
+	e.Use(logutil.Requests(log, nil))
 	e.Use(middleware.Recover())
 	//e.Use(middleware.BodyLimit("100M"))
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
@@ -41,25 +91,25 @@ func NewRouter(cfg *Config) (*echo.Echo, error) {
 
 <form action="/maps" method="post" enctype="multipart/form-data">
 	GameName: <input type="text" name="name" value="baseq3" /><br>
-    Files: <input type="file" name="file"><br><br>
+    Files: <input type="file" name="file" accept=".pk3,.zip"><br><br>
     <input type="submit" value="Submit">
 </form>
 </body>
 </html>`)
 	})
 	e.GET("/assets/manifest.json", func(c echo.Context) error {
-		files, err := getAssets(cfg.AssetsDir)
-		if err != nil {
-			return err
+		manifest, etag := index.Manifest()
+		h := c.Response().Header()
+		h.Set("ETag", etag)
+		// clients check for new assets each time
+		h.Set("Cache-Control", "no-cache")
+		if c.Request().Header.Get("If-None-Match") == etag {
+			return c.NoContent(http.StatusNotModified)
 		}
-		return c.JSONPretty(http.StatusOK, files, "   ")
+		return c.Blob(http.StatusOK, echo.MIMEApplicationJSONCharsetUTF8, manifest)
 	})
-	e.GET("/assets/*", func(c echo.Context) error {
-		path := filepath.Join(cfg.AssetsDir, trimAssetName(c.Param("*")))
-		if _, err := os.Stat(path); os.IsNotExist(err) {
-			return c.String(http.StatusNotFound, "file not found")
-		}
-		return c.File(path)
+	e.Match([]string{http.MethodGet, http.MethodHead}, "/assets/*", func(c echo.Context) error {
+		return serveAsset(c, index)
 	})
 	e.GET("/maps", func(c echo.Context) error {
 		maps, err := getMaps(cfg.AssetsDir)
@@ -68,71 +118,8 @@ func NewRouter(cfg *Config) (*echo.Echo, error) {
 		}
 		return c.JSONPretty(http.StatusOK, maps, "    ")
 	})
-	e.POST("/maps", func(c echo.Context) error {
-		name := c.FormValue("name")
-		file, err := c.FormFile("file")
-		if err != nil {
-			return err
-		}
-		src, err := file.Open()
-		if err != nil {
-			return err
-		}
-		defer src.Close()
-
-		if hasExts(file.Filename, ".zip") {
-			r, err := zip.NewReader(src, file.Size)
-			if err != nil {
-				return err
-			}
-			files := make([]string, 0)
-			for _, f := range r.File {
-				if !hasExts(f.Name, ".pk3") {
-					continue
-				}
-				pak, err := f.Open()
-				if err != nil {
-					return err
-				}
-				defer pak.Close()
-
-				dst, err := os.Create(filepath.Join(cfg.AssetsDir, name, filepath.Base(f.Name)))
-				if err != nil {
-					return err
-				}
-				defer dst.Close()
-
-				if _, err = io.Copy(dst, pak); err != nil {
-					return err
-				}
-				files = append(files, filepath.Base(f.Name))
-			}
-			if len(files) == 0 {
-				return c.HTML(http.StatusOK, fmt.Sprintf("<p>File %s did not contain any map pack files.</p>", file.Filename))
-			}
-			for i, _ := range files {
-				files[i] = "<li>" + files[i] + "</li>"
-			}
-			return c.HTML(http.StatusOK, fmt.Sprintf("<p>Loaded the following map packs from file %s:</p><ul>%s</ul>", file.Filename, strings.Join(files, "")))
-		}
-		dst, err := os.Create(filepath.Join(cfg.AssetsDir, name, file.Filename))
-		if err != nil {
-			return err
-		}
-		defer dst.Close()
-
-		if _, err = io.Copy(dst, src); err != nil {
-			return err
-		}
-		return c.HTML(http.StatusOK, fmt.Sprintf("<p>File %s uploaded successfully.</p>", filepath.Join(name, file.Filename)))
-	})
+	auth := authenticate(cfg.Uploaders)
+	e.POST("/maps", u.upload, httputil.JSONErrors, auth)
+	e.DELETE("/maps/:dir/:file", u.delete, httputil.JSONErrors, auth)
 	return e, nil
 }
-
-// trimAssetName returns a path string that has been prefixed with a crc32
-// checksum.
-func trimAssetName(s string) string {
-	d, f := filepath.Split(s)
-	f = f[strings.Index(f, "-")+1:]
-	return filepath.Join(d, f)
-}
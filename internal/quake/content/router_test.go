@@ -1,35 +1,138 @@
 package content
 
 import (
+	"hash/crc32"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 )
 
-func TestTrimAssetName(t *testing.T) {
+func TestParseAssetName(t *testing.T) {
 	cases := []struct {
 		name     string
 		input    string
 		expected string
+		checksum uint32
+		ok       bool
 	}{
 		{
 			name:     "root folder",
 			input:    "857908472-linuxq3ademo-1.11-6.x86.gz.sh",
 			expected: "linuxq3ademo-1.11-6.x86.gz.sh",
+			checksum: 857908472,
+			ok:       true,
 		},
 		{
 			name:     "pak file",
 			input:    "baseq3/2483777038-pak0.pk3",
 			expected: "baseq3/pak0.pk3",
+			checksum: 2483777038,
+			ok:       true,
+		},
+		{
+			name:     "no checksum",
+			input:    "baseq3/map-q3dm17.pk3",
+			expected: "baseq3/map-q3dm17.pk3",
+		},
+		{
+			name:     "checksum too large",
+			input:    "baseq3/4294967296-pak0.pk3",
+			expected: "baseq3/4294967296-pak0.pk3",
 		},
 	}
 
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
-			result := trimAssetName(c.input)
+			result, checksum, ok := parseAssetName(c.input)
 			if diff := cmp.Diff(c.expected, result); diff != "" {
-				t.Errorf("content: after trimAssetName differs: (-want +got)\n%s", diff)
+				t.Errorf("content: after parseAssetName differs: (-want +got)\n%s", diff)
+			}
+			if checksum != c.checksum || ok != c.ok {
+				t.Errorf("content: expected checksum %d, %v, received %d, %v", c.checksum, c.ok, checksum, ok)
 			}
 		})
 	}
 }
+
+func TestServeAsset(t *testing.T) {
+	root, err := ioutil.TempDir("", "content")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	if err := ioutil.WriteFile(filepath.Join(root, "secret"), []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(root, "assets")
+	data := []byte("PK\x03\x04 pak0")
+	if err := os.MkdirAll(filepath.Join(dir, "baseq3"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "baseq3/pak0.pk3"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	checksum := crc32.ChecksumIEEE(data)
+	url := assetURL("baseq3/pak0.pk3", checksum)
+
+	e, err := NewRouter(&Config{AssetsDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(url string, header ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get(url)
+	if rec.Code != http.StatusOK || rec.Body.String() != string(data) {
+		t.Fatalf("content: expected file, received %d %q", rec.Code, rec.Body)
+	}
+	etag := rec.Header().Get("ETag")
+	if rec.Header().Get("Cache-Control") != immutable || etag == "" {
+		t.Errorf("content: expected immutable caching and an ETag, received %v", rec.Header())
+	}
+
+	if rec := get(url, "If-None-Match", etag); rec.Code != http.StatusNotModified {
+		t.Errorf("content: expected 304 for matching ETag, received %d", rec.Code)
+	}
+
+	rec = get(url, "Range", "bytes=0-3")
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "PK\x03\x04" {
+		t.Errorf("content: expected the first 4 bytes, received %d %q", rec.Code, rec.Body)
+	}
+
+	// a stale manifest is sent to the current file
+	rec = get(assetURL("baseq3/pak0.pk3", checksum+1))
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != url {
+		t.Errorf("content: expected redirect to %s, received %d %s", url, rec.Code, rec.Header().Get("Location"))
+	}
+	if rec.Header().Get("Cache-Control") == immutable {
+		t.Error("content: expected redirect not to be cached")
+	}
+
+	rec = get("/assets/baseq3/pak0.pk3")
+	if rec.Code != http.StatusOK || rec.Header().Get("Cache-Control") == immutable {
+		t.Errorf("content: expected file without checksum not to be immutable, received %d %v", rec.Code, rec.Header())
+	}
+
+	for _, url := range []string{
+		assetURL("baseq3/pak1.pk3", checksum),
+		"/assets/baseq3",
+		"/assets/../secret",
+		"/assets/baseq3/../../secret",
+	} {
+		if rec := get(url); rec.Code != http.StatusNotFound {
+			t.Errorf("content: %s: expected 404, received %d", url, rec.Code)
+		}
+	}
+}
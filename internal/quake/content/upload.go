@@ -0,0 +1,436 @@
+package content
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultMaxUploadSize is the largest request accepted by POST /maps.
+	DefaultMaxUploadSize = 512 << 20
+
+	// DefaultMaxUnpackedSize is the most that an upload may decompress to,
+	// which guards against zip bombs.
+	DefaultMaxUnpackedSize = 2 << 30
+
+	// maxZipFiles is the most files in an uploaded zip or pk3. The paks of
+	// the full game have fewer than 4000.
+	maxZipFiles = 16384
+)
+
+// DefaultGameDirs are the game directories that map packs can be uploaded to.
+var DefaultGameDirs = []string{"baseq3", "missionpack"}
+
+// pakContentExts are the files expected in a pk3, which must contain at least
+// one of them.
+var pakContentExts = []string{
+	".bsp", ".aas", ".arena", ".bot", ".cfg", ".jpg", ".md3", ".menu", ".ogg",
+	".shader", ".skin", ".tga", ".txt", ".wav",
+}
+
+// pakName is a safe name for an uploaded pk3, which is also short enough for
+// the engine (MAX_QPATH).
+var pakName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,58}\.pk3$`)
+
+// uploadError is an upload that was refused, with the status returned to the
+// client.
+func uploadError(code int, format string, args ...interface{}) error {
+	return echo.NewHTTPError(code, fmt.Sprintf(format, args...))
+}
+
+type uploadResult struct {
+	Files []string `json:"files"`
+}
+
+type uploader struct {
+	dir             string
+	gameDirs        []string
+	maxUploadSize   int64
+	maxUnpackedSize int64
+	index           *Index
+	audit           *auditLog
+}
+
+// uploadRequest is who is uploading or deleting paks.
+type uploadRequest struct {
+	uploader *Uploader
+	remoteIP string
+}
+
+func newUploadRequest(c echo.Context) *uploadRequest {
+	// the address of the connection is recorded rather than RealIP, which
+	// trusts headers set by the client
+	ip, _, err := net.SplitHostPort(c.Request().RemoteAddr)
+	if err != nil {
+		ip = c.Request().RemoteAddr
+	}
+	up, _ := c.Get(uploaderKey).(*Uploader)
+	return &uploadRequest{uploader: up, remoteIP: ip}
+}
+
+// upload saves a pk3, or the pk3s in a zip, sent as the file form value to
+// the game directory in the name form value. Files are written to temporary
+// files and validated before any of them are moved into place, and existing
+// files are never replaced.
+func (u *uploader) upload(c echo.Context) error {
+	r := newUploadRequest(c)
+	req := c.Request()
+	if req.ContentLength > u.maxUploadSize {
+		return uploadError(http.StatusRequestEntityTooLarge, "upload is larger than %d bytes", u.maxUploadSize)
+	}
+	req.Body = http.MaxBytesReader(c.Response(), req.Body, u.maxUploadSize)
+
+	gameDir := c.FormValue("name")
+	file, err := c.FormFile("file")
+	if err != nil {
+		if isTooLarge(err) {
+			return uploadError(http.StatusRequestEntityTooLarge, "upload is larger than %d bytes", u.maxUploadSize)
+		}
+		return uploadError(http.StatusBadRequest, "file is required")
+	}
+	if !u.allowedGameDir(gameDir) {
+		return uploadError(http.StatusBadRequest, "game directory %q is not one of %s", gameDir, strings.Join(u.gameDirs, ", "))
+	}
+	if err := os.MkdirAll(filepath.Join(u.dir, gameDir), 0755); err != nil {
+		return err
+	}
+	src, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	var paks []*stagedPak
+	switch {
+	case hasExts(strings.ToLower(file.Filename), ".zip"):
+		paks, err = u.stageZip(gameDir, src, file.Size)
+	case isPak(file.Filename):
+		var p *stagedPak
+		if p, err = u.stage(gameDir, file.Filename, src, u.maxUploadSize); err == nil {
+			paks = []*stagedPak{p}
+			_, err = p.validate(u.maxUnpackedSize)
+		}
+	default:
+		return uploadError(http.StatusBadRequest, "%s is not a pk3 or zip file", file.Filename)
+	}
+	for _, p := range paks {
+		defer p.remove()
+	}
+	if err != nil {
+		return err
+	}
+	files, err := u.commit(r, paks)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusCreated, uploadResult{Files: files})
+}
+
+func (u *uploader) allowedGameDir(name string) bool {
+	for _, dir := range u.gameDirs {
+		if name == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// stageZip stages each pk3 in the zip, which together may not decompress to
+// more than the unpacked limit, counting both the paks and the files in them.
+// The staged paks are returned even on error, so that they can be removed.
+func (u *uploader) stageZip(gameDir string, src io.ReaderAt, size int64) ([]*stagedPak, error) {
+	zr, err := zip.NewReader(src, size)
+	if err != nil {
+		return nil, uploadError(http.StatusBadRequest, "invalid zip file: %v", err)
+	}
+	if len(zr.File) > maxZipFiles {
+		return nil, uploadError(http.StatusBadRequest, "zip file contains more than %d files", maxZipFiles)
+	}
+	paks := make([]*stagedPak, 0)
+	names := make(map[string]bool)
+	remaining := u.maxUnpackedSize
+	for _, f := range zr.File {
+		if !isPak(f.Name) {
+			continue
+		}
+		// only the name of the pak is kept, never the directories in the zip
+		filename := path.Base(f.Name)
+		if names[filename] {
+			return paks, uploadError(http.StatusBadRequest, "zip file contains %s more than once", filename)
+		}
+		names[filename] = true
+		p, err := u.stageZipFile(gameDir, filename, f, remaining)
+		if err != nil {
+			return paks, err
+		}
+		paks = append(paks, p)
+		remaining -= p.size
+		n, err := p.validate(remaining)
+		if err != nil {
+			return paks, err
+		}
+		remaining -= n
+	}
+	if len(paks) == 0 {
+		return nil, uploadError(http.StatusBadRequest, "zip file does not contain any pk3 files")
+	}
+	return paks, nil
+}
+
+func (u *uploader) stageZipFile(gameDir, filename string, f *zip.File, limit int64) (*stagedPak, error) {
+	// the size in the zip is checked to fail early, but cannot be trusted
+	if f.UncompressedSize64 > uint64(limit) {
+		return nil, uploadError(http.StatusRequestEntityTooLarge, "zip file unpacks to more than %d bytes", u.maxUnpackedSize)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, uploadError(http.StatusBadRequest, "invalid zip file: %v", err)
+	}
+	defer rc.Close()
+	return u.stage(gameDir, filename, rc, limit)
+}
+
+// stagedPak is an uploaded pk3 in a temporary file, which is moved into place
+// once every pak in the upload has been validated.
+type stagedPak struct {
+	name string
+	tmp  string
+	dst  string
+	size int64
+}
+
+// validate checks the pak, returning the number of bytes that its files
+// unpack to, which may not be more than limit.
+func (p *stagedPak) validate(limit int64) (int64, error) {
+	n, err := validatePak(p.tmp, limit)
+	if err != nil {
+		return 0, uploadError(http.StatusBadRequest, "%s: %v", p.name, err)
+	}
+	return n, nil
+}
+
+func (p *stagedPak) remove() {
+	os.Remove(p.tmp)
+}
+
+// isPak reports whether the file is a pk3, whatever the case of its
+// extension, so that paks the asset index would not list are refused rather
+// than ignored.
+func isPak(filename string) bool {
+	return hasExts(strings.ToLower(filename), ".pk3")
+}
+
+// stage writes the pk3 to a temporary file in the game directory, which may
+// be no larger than limit.
+func (u *uploader) stage(gameDir, filename string, src io.Reader, limit int64) (*stagedPak, error) {
+	if !hasExts(filename, ".pk3") {
+		return nil, uploadError(http.StatusBadRequest, "%s: the extension must be lowercase .pk3", filename)
+	}
+	if !pakName.MatchString(filename) {
+		return nil, uploadError(http.StatusBadRequest, "invalid file name %q", filename)
+	}
+	name := path.Join(gameDir, filename)
+	dst := filepath.Join(u.dir, filepath.FromSlash(name))
+	if _, err := os.Lstat(dst); err == nil {
+		return nil, uploadError(http.StatusConflict, "%s already exists", name)
+	}
+
+	// the temporary file is not an asset, so it is not indexed or served
+	tmp, err := ioutil.TempFile(filepath.Dir(dst), ".upload-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	p := &stagedPak{name: name, tmp: tmp.Name(), dst: dst}
+	p.size, err = io.Copy(tmp, io.LimitReader(src, limit+1))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && p.size > limit {
+		err = uploadError(http.StatusRequestEntityTooLarge, "%s is larger than %d bytes", name, limit)
+	}
+	switch {
+	case err == nil:
+		return p, nil
+	case isTooLarge(err):
+		err = uploadError(http.StatusRequestEntityTooLarge, "upload is larger than %d bytes", u.maxUploadSize)
+	case err == zip.ErrChecksum || err == zip.ErrFormat:
+		err = uploadError(http.StatusBadRequest, "invalid zip file: %v", err)
+	}
+	p.remove()
+	return nil, err
+}
+
+// commit moves the validated paks into place when they are within the quotas
+// of the uploader, and records the upload. Either every pak is moved into
+// place, or none are.
+func (u *uploader) commit(r *uploadRequest, paks []*stagedPak) ([]string, error) {
+	u.audit.mu.Lock()
+	defer u.audit.mu.Unlock()
+
+	var size int64
+	for _, p := range paks {
+		size += p.size
+	}
+	used, files := u.audit.usage(r.uploader.Name, u.index)
+	if max := r.uploader.MaxFiles; max > 0 && files+len(paks) > max {
+		return nil, uploadError(http.StatusForbidden, "quota exceeded: %s has uploaded %d of %d files", r.uploader.Name, files, max)
+	}
+	if max := r.uploader.MaxBytes; max > 0 && used+size > max {
+		return nil, uploadError(http.StatusForbidden, "quota exceeded: %s has uploaded %d of %d bytes", r.uploader.Name, used, max)
+	}
+
+	// a hard link fails when the file exists, unlike a rename
+	for i, p := range paks {
+		if err := os.Link(p.tmp, p.dst); err != nil {
+			for _, linked := range paks[:i] {
+				os.Remove(linked.dst)
+			}
+			if os.IsExist(err) {
+				return nil, uploadError(http.StatusConflict, "%s already exists", p.name)
+			}
+			return nil, err
+		}
+	}
+	names := make([]string, 0, len(paks))
+	for _, p := range paks {
+		e, err := u.index.update(p.name)
+		if err != nil {
+			return nil, err
+		}
+		if err := u.audit.record(AuditRecord{
+			Time:     time.Now().UTC(),
+			Action:   auditUpload,
+			Uploader: r.uploader.Name,
+			File:     p.name,
+			Size:     e.Size,
+			Checksum: e.Checksum,
+			RemoteIP: r.remoteIP,
+		}); err != nil {
+			return nil, err
+		}
+		names = append(names, p.name)
+	}
+	return names, nil
+}
+
+// delete removes a pak, which only the uploader that uploaded it can delete.
+func (u *uploader) delete(c echo.Context) error {
+	r := newUploadRequest(c)
+	gameDir, filename := c.Param("dir"), c.Param("file")
+	if !u.allowedGameDir(gameDir) || !pakName.MatchString(filename) {
+		return uploadError(http.StatusNotFound, "file not found")
+	}
+	name := path.Join(gameDir, filename)
+
+	u.audit.mu.Lock()
+	defer u.audit.mu.Unlock()
+
+	owner, ok := u.audit.owner(name)
+	if !ok {
+		if _, err := os.Lstat(filepath.Join(u.dir, filepath.FromSlash(name))); os.IsNotExist(err) {
+			return uploadError(http.StatusNotFound, "file not found")
+		}
+	}
+	if owner != r.uploader.Name {
+		return uploadError(http.StatusForbidden, "%s was not uploaded by %s", name, r.uploader.Name)
+	}
+	e, _ := u.index.entry(name)
+	if err := os.Remove(filepath.Join(u.dir, filepath.FromSlash(name))); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := u.index.remove(name); err != nil {
+		return err
+	}
+	if err := u.audit.record(AuditRecord{
+		Time:     time.Now().UTC(),
+		Action:   auditDelete,
+		Uploader: r.uploader.Name,
+		File:     name,
+		Size:     e.Size,
+		Checksum: e.Checksum,
+		RemoteIP: r.remoteIP,
+	}); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// validatePak checks that the file is a pk3 containing maps or other game
+// files, returning the number of bytes its files unpack to. Every file in the
+// pak is read to verify its checksum, and the pak may not decompress to more
+// than limit bytes.
+func validatePak(path string, limit int64) (int64, error) {
+	mp, err := OpenMapPack(path)
+	if err != nil {
+		return 0, errors.Wrap(err, "not a valid pk3")
+	}
+	defer mp.Close()
+
+	if len(mp.Reader.File) > maxZipFiles {
+		return 0, errors.Errorf("contains more than %d files", maxZipFiles)
+	}
+	found := false
+	var total int64
+	for _, f := range mp.Reader.File {
+		if strings.HasPrefix(f.Name, "/") || strings.Contains(f.Name, "\\") || hasDotDot(f.Name) {
+			return 0, errors.Errorf("invalid path %q", f.Name)
+		}
+		if hasExts(strings.ToLower(f.Name), pakContentExts...) {
+			found = true
+		}
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		n, err := readZipFile(f, limit-total)
+		if err != nil {
+			return 0, errors.Wrapf(err, "cannot read %s", f.Name)
+		}
+		total += n
+		if total > limit {
+			return 0, errors.New("unpacks to too many bytes")
+		}
+	}
+	if !found {
+		return 0, errors.New("does not contain any maps or game files")
+	}
+	return total, nil
+}
+
+// readZipFile reads up to limit+1 bytes of the file, verifying its checksum
+// when the whole file is read.
+func readZipFile(f *zip.File, limit int64) (int64, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	return io.Copy(ioutil.Discard, io.LimitReader(rc, limit+1))
+}
+
+func hasDotDot(name string) bool {
+	for _, s := range strings.Split(name, "/") {
+		if s == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// isTooLarge returns whether the error is from reading more of the request
+// than http.MaxBytesReader allows.
+func isTooLarge(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "http: request body too large")
+}
@@ -0,0 +1,325 @@
+package content
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"hash/crc32"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func zipFiles(t *testing.T, files map[string][]byte) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, data := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func uploadForm(t *testing.T, gameDir, filename string, data []byte) *http.Request {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	w.WriteField("name", gameDir)
+	f, err := w.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(data)
+	w.Close()
+	req := httptest.NewRequest(http.MethodPost, "/maps", &body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	return req
+}
+
+func TestUpload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "content")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	e, err := NewRouter(&Config{
+		AssetsDir:       dir,
+		MaxUploadSize:   64 << 10,
+		MaxUnpackedSize: 256 << 10,
+		Uploaders:       []*Uploader{{Name: "mapper", Token: "token"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upload := func(gameDir, filename string, data []byte) *httptest.ResponseRecorder {
+		req := uploadForm(t, gameDir, filename, data)
+		req.Header.Set("Authorization", "Bearer token")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	pak := zipFiles(t, map[string][]byte{"maps/q3dm17.bsp": []byte("IBSP")})
+	rec := upload("baseq3", "map-q3dm17.pk3", pak)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("content: expected upload, received %d %s", rec.Code, rec.Body)
+	}
+	var result uploadResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Files) != 1 || result.Files[0] != "baseq3/map-q3dm17.pk3" {
+		t.Errorf("content: unexpected upload result %+v", result)
+	}
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/assets/manifest.json", nil))
+	if !strings.Contains(rec.Body.String(), `"baseq3/map-q3dm17.pk3"`) {
+		t.Errorf("content: expected upload in manifest, received %s", rec.Body)
+	}
+
+	// the paks in a zip are saved without their directories
+	archive := zipFiles(t, map[string][]byte{
+		"../../q3dm1.pk3": zipFiles(t, map[string][]byte{"maps/q3dm1.bsp": []byte("IBSP")}),
+		"readme.txt":      []byte("maps"),
+	})
+	if rec := upload("baseq3", "maps.zip", archive); rec.Code != http.StatusCreated {
+		t.Errorf("content: expected zip upload, received %d %s", rec.Code, rec.Body)
+	}
+	for _, name := range []string{"baseq3/map-q3dm17.pk3", "baseq3/q3dm1.pk3"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("content: expected %s to be saved: %v", name, err)
+		}
+	}
+
+	bomb := zipFiles(t, map[string][]byte{"maps/bomb.bsp": bytes.Repeat([]byte{0}, 512<<10)})
+	// each of the paks is within the unpacked limit, but not together
+	half := func(name string) []byte {
+		return zipFiles(t, map[string][]byte{"maps/" + name + ".bsp": bytes.Repeat([]byte{0}, 160<<10)})
+	}
+	halves := zipFiles(t, map[string][]byte{"a.pk3": half("a"), "b.pk3": half("b")})
+	// the valid pak is not saved when another pak in the zip is refused
+	mixed := zipFiles(t, map[string][]byte{
+		"good.pk3": zipFiles(t, map[string][]byte{"maps/good.bsp": []byte("IBSP")}),
+		"bad.pk3":  []byte("not a zip"),
+	})
+	cases := []struct {
+		name     string
+		gameDir  string
+		filename string
+		data     []byte
+		code     int
+	}{
+		{"existing file", "baseq3", "map-q3dm17.pk3", pak, http.StatusConflict},
+		{"game dir not allowed", "../..", "map-q3dm17.pk3", pak, http.StatusBadRequest},
+		{"invalid name", "baseq3", ".hidden.pk3", pak, http.StatusBadRequest},
+		{"not a pk3", "baseq3", "bans.json", []byte("[]"), http.StatusBadRequest},
+		{"not a zip", "baseq3", "fake.pk3", []byte("not a zip"), http.StatusBadRequest},
+		{"no game files", "baseq3", "empty.pk3", zipFiles(t, map[string][]byte{"x.exe": nil}), http.StatusBadRequest},
+		{"path in pak", "baseq3", "evil.pk3", zipFiles(t, map[string][]byte{"../maps/evil.bsp": nil}), http.StatusBadRequest},
+		{"zip without paks", "baseq3", "maps.zip", zipFiles(t, map[string][]byte{"readme.txt": nil}), http.StatusBadRequest},
+		{"zip bomb", "baseq3", "bomb.pk3", bomb, http.StatusBadRequest},
+		{"zip bomb in paks", "baseq3", "halves.zip", halves, http.StatusBadRequest},
+		{"zip with invalid pak", "baseq3", "mixed.zip", mixed, http.StatusBadRequest},
+		{"zip with duplicate paks", "baseq3", "dup.zip", zipFiles(t, map[string][]byte{"a/q3dm2.pk3": pak, "b/q3dm2.pk3": pak}), http.StatusBadRequest},
+		{"too large", "baseq3", "large.pk3", bytes.Repeat([]byte{0}, 128<<10), http.StatusRequestEntityTooLarge},
+		{"uppercase extension", "baseq3", "MAP.PK3", pak, http.StatusBadRequest},
+		{"uppercase extension in zip", "baseq3", "MAPS.ZIP", zipFiles(t, map[string][]byte{"Q3DM3.Pk3": pak, "q3dm4.pk3": pak}), http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rec := upload(c.gameDir, c.filename, c.data)
+			if rec.Code != c.code {
+				t.Fatalf("content: expected %d, received %d %s", c.code, rec.Code, rec.Body)
+			}
+			var resp map[string]string
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp["error"] == "" {
+				t.Errorf("content: expected JSON error, received %q", rec.Body)
+			}
+		})
+	}
+
+	// a pak with an uppercase extension is not mistaken for another file
+	if rec := upload("baseq3", "MAP.PK3", pak); !strings.Contains(rec.Body.String(), "lowercase .pk3") {
+		t.Errorf("content: expected lowercase extension error, received %d %s", rec.Code, rec.Body)
+	}
+
+	// nothing is left behind by refused uploads
+	files, err := ioutil.ReadDir(filepath.Join(dir, "baseq3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0)
+	for _, fi := range files {
+		names = append(names, fi.Name())
+	}
+	if strings.Join(names, ",") != "map-q3dm17.pk3,q3dm1.pk3" {
+		t.Errorf("content: expected only uploaded paks, received %v", names)
+	}
+	data, _ := ioutil.ReadFile(filepath.Join(dir, "baseq3/map-q3dm17.pk3"))
+	if !bytes.Equal(data, pak) {
+		t.Error("content: expected existing pak not to be replaced")
+	}
+}
+
+func TestUploadAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "content")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pak := func(name string) []byte {
+		return zipFiles(t, map[string][]byte{"maps/" + name + ".bsp": []byte("IBSP")})
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "baseq3"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "baseq3/pak0.pk3"), pak("q3dm0"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{
+		AssetsDir: dir,
+		AuditFile: filepath.Join(dir, DefaultAuditFile),
+		Uploaders: []*Uploader{
+			{Name: "mapper", Token: "mapper-token", MaxFiles: 2},
+			{Name: "admin", Password: "secret"},
+		},
+	}
+	e, err := NewRouter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	do := func(req *http.Request, auth ...string) *httptest.ResponseRecorder {
+		if len(auth) == 1 {
+			req.Header.Set("Authorization", "Bearer "+auth[0])
+		} else if len(auth) == 2 {
+			req.SetBasicAuth(auth[0], auth[1])
+		}
+		req.RemoteAddr = "192.0.2.1:1234"
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+	upload := func(filename string, auth ...string) int {
+		return do(uploadForm(t, "baseq3", filename, pak(filename)), auth...).Code
+	}
+	remove := func(name string, auth ...string) int {
+		return do(httptest.NewRequest(http.MethodDelete, "/maps/"+name, nil), auth...).Code
+	}
+
+	if code := upload("a.pk3"); code != http.StatusUnauthorized {
+		t.Errorf("content: expected 401 without credentials, received %d", code)
+	}
+	if code := upload("a.pk3", "wrong-token"); code != http.StatusUnauthorized {
+		t.Errorf("content: expected 401 with invalid token, received %d", code)
+	}
+	if code := upload("a.pk3", "admin", "wrong"); code != http.StatusUnauthorized {
+		t.Errorf("content: expected 401 with invalid password, received %d", code)
+	}
+	if code := upload("a.pk3", "mapper-token"); code != http.StatusCreated {
+		t.Errorf("content: expected upload with token, received %d", code)
+	}
+	if code := upload("b.pk3", "admin", "secret"); code != http.StatusCreated {
+		t.Errorf("content: expected upload with basic auth, received %d", code)
+	}
+	if code := upload("c.pk3", "mapper-token"); code != http.StatusCreated {
+		t.Errorf("content: expected upload with token, received %d", code)
+	}
+	if code := upload("d.pk3", "mapper-token"); code != http.StatusForbidden {
+		t.Errorf("content: expected file quota to be exceeded, received %d", code)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "baseq3/d.pk3")); !os.IsNotExist(err) {
+		t.Error("content: expected upload over quota not to be saved")
+	}
+
+	// assets are still served without credentials
+	if rec := do(httptest.NewRequest(http.MethodGet, "/assets/baseq3/a.pk3", nil)); rec.Code != http.StatusOK {
+		t.Errorf("content: expected anonymous download, received %d", rec.Code)
+	}
+
+	// uploaders can only delete their own paks
+	if code := remove("baseq3/b.pk3", "mapper-token"); code != http.StatusForbidden {
+		t.Errorf("content: expected 403 deleting the pak of another uploader, received %d", code)
+	}
+	if code := remove("baseq3/pak0.pk3", "mapper-token"); code != http.StatusForbidden {
+		t.Errorf("content: expected 403 deleting a pak that was not uploaded, received %d", code)
+	}
+	if code := remove("baseq3/missing.pk3", "mapper-token"); code != http.StatusNotFound {
+		t.Errorf("content: expected 404 deleting a missing pak, received %d", code)
+	}
+	if code := remove("baseq3/a.pk3"); code != http.StatusUnauthorized {
+		t.Errorf("content: expected 401 deleting without credentials, received %d", code)
+	}
+	if code := remove("baseq3/a.pk3", "mapper-token"); code != http.StatusNoContent {
+		t.Errorf("content: expected delete, received %d", code)
+	}
+	if rec := do(httptest.NewRequest(http.MethodGet, "/assets/baseq3/a.pk3", nil)); rec.Code != http.StatusNotFound {
+		t.Errorf("content: expected deleted pak to be 404, received %d", rec.Code)
+	}
+
+	// the audit log is replayed, so the quota is kept across restarts
+	e, err = NewRouter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := upload("d.pk3", "mapper-token"); code != http.StatusCreated {
+		t.Errorf("content: expected upload after delete, received %d", code)
+	}
+	if code := upload("e.pk3", "mapper-token"); code != http.StatusForbidden {
+		t.Errorf("content: expected file quota to be exceeded after restart, received %d", code)
+	}
+
+	data, err := ioutil.ReadFile(cfg.AuditFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("content: expected 5 audit records, received %q", data)
+	}
+	var r AuditRecord
+	if err := json.Unmarshal([]byte(lines[0]), &r); err != nil {
+		t.Fatal(err)
+	}
+	expected := AuditRecord{
+		Time:     r.Time,
+		Action:   "upload",
+		Uploader: "mapper",
+		File:     "baseq3/a.pk3",
+		Size:     int64(len(pak("a.pk3"))),
+		Checksum: crc32.ChecksumIEEE(pak("a.pk3")),
+		RemoteIP: "192.0.2.1",
+	}
+	if r != expected || r.Time.IsZero() {
+		t.Errorf("content: expected audit record %+v, received %+v", expected, r)
+	}
+}
+
+func TestUploadsDisabled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "content")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	e, err := NewRouter(&Config{AssetsDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, uploadForm(t, "baseq3", "a.pk3", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("content: expected uploads to be disabled, received %d", rec.Code)
+	}
+}
@@ -2,6 +2,7 @@ package net
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"net"
 	"strconv"
@@ -16,7 +17,16 @@ const (
 	GetStatusCommand = "getstatus"
 )
 
+// DefaultTimeout is how long a command waits for a response.
+const DefaultTimeout = 5 * time.Second
+
 func SendCommand(addr, cmd string) ([]byte, error) {
+	return SendCommandContext(context.Background(), addr, cmd)
+}
+
+// SendCommandContext sends the command, waiting for a response until the
+// deadline of the context, or DefaultTimeout when it has none.
+func SendCommandContext(ctx context.Context, addr, cmd string) ([]byte, error) {
 	raddr, err := net.ResolveUDPAddr("udp4", addr)
 	if err != nil {
 		return nil, err
@@ -28,7 +38,11 @@ func SendCommand(addr, cmd string) ([]byte, error) {
 	defer conn.Close()
 
 	buffer := make([]byte, 1024*1024)
-	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(DefaultTimeout)
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
 		return nil, err
 	}
 	n, err := conn.WriteTo([]byte(fmt.Sprintf("%s%s", OutOfBandHeader, cmd)), raddr)
@@ -91,7 +105,11 @@ func parsePlayers(data []byte) ([]Player, error) {
 }
 
 func GetInfo(addr string) (map[string]string, error) {
-	resp, err := SendCommand(addr, GetInfoCommand)
+	return GetInfoContext(context.Background(), addr)
+}
+
+func GetInfoContext(ctx context.Context, addr string) (map[string]string, error) {
+	resp, err := SendCommandContext(ctx, addr, GetInfoCommand)
 	if err != nil {
 		return nil, err
 	}
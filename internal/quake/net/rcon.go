@@ -0,0 +1,180 @@
+package net
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	RconCommand  = "rcon"
+	PrintCommand = "print"
+)
+
+var (
+	ErrNoRconPassword  = errors.New("no rconpassword set on the server")
+	ErrBadRconPassword = errors.New("bad rconpassword")
+)
+
+// Rcon sends a remote console command to the server, returning the output of
+// the command. Long output is split by the server across several packets,
+// which are read until no more arrive.
+func Rcon(addr, password, cmd string) (string, error) {
+	raddr, err := net.ResolveUDPAddr("udp4", addr)
+	if err != nil {
+		return "", err
+	}
+	conn, err := net.ListenPacket("udp4", "0.0.0.0:0")
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		return "", err
+	}
+	_, err = conn.WriteTo([]byte(fmt.Sprintf("%s%s %s %s", OutOfBandHeader, RconCommand, password, cmd)), raddr)
+	if err != nil {
+		return "", err
+	}
+
+	var out bytes.Buffer
+	buffer := make([]byte, 16384)
+	for {
+		n, _, err := conn.ReadFrom(buffer)
+		if err != nil {
+			if e, ok := err.(net.Error); ok && e.Timeout() && out.Len() > 0 {
+				break
+			}
+			return "", err
+		}
+		data := bytes.TrimPrefix(buffer[:n], []byte(OutOfBandHeader+PrintCommand+"\n"))
+		out.Write(data)
+
+		// subsequent packets are expected to follow closely
+		if err := conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond)); err != nil {
+			return "", err
+		}
+	}
+	resp := out.String()
+	switch {
+	case strings.HasPrefix(resp, "No rconpassword set on the server."):
+		return "", ErrNoRconPassword
+	case strings.HasPrefix(resp, "Bad rconpassword."):
+		return "", ErrBadRconPassword
+	}
+	return resp, nil
+}
+
+// Client is a client slot from the output of the rcon status command.
+type Client struct {
+	Num   int    `json:"num"`
+	Score int    `json:"score"`
+	Ping  int    `json:"ping"`
+	Name  string `json:"name"`
+	// Address is the client address, or "bot" for bots.
+	Address string `json:"address"`
+	QPort   int    `json:"qport"`
+	Rate    int    `json:"rate"`
+}
+
+func (c *Client) IsBot() bool {
+	return c.Address == "bot"
+}
+
+type ServerStatus struct {
+	Map     string   `json:"map"`
+	Clients []Client `json:"clients"`
+}
+
+// ParseServerStatus parses the output of the rcon status command:
+//
+//	map: q3dm7
+//	num score ping name            lastmsg address               qport rate
+//	--- ----- ---- --------------- ------- --------------------- ----- -----
+//	  0     0    0 Sarge^7              50 bot                       0 16384
+//
+// Clients that are still connecting report a ping of CNCT, or ZMBI when
+// disconnected, which are both returned as -1.
+func ParseServerStatus(data string) (*ServerStatus, error) {
+	status := &ServerStatus{Clients: make([]Client, 0)}
+	lines := strings.Split(data, "\n")
+	for _, line := range lines {
+		if strings.HasPrefix(line, "map:") {
+			status.Map = strings.TrimSpace(strings.TrimPrefix(line, "map:"))
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 8 {
+			continue
+		}
+		num, err := strconv.Atoi(fields[0])
+		if err != nil {
+			// header lines
+			continue
+		}
+		c := Client{Num: num, Ping: -1}
+		if c.Score, err = strconv.Atoi(fields[1]); err != nil {
+			return nil, errors.Errorf("cannot parse status line: %q", line)
+		}
+		if ping, err := strconv.Atoi(fields[2]); err == nil {
+			c.Ping = ping
+		}
+		n := len(fields)
+		c.Address = fields[n-3]
+		if c.QPort, err = strconv.Atoi(fields[n-2]); err != nil {
+			return nil, errors.Errorf("cannot parse status line: %q", line)
+		}
+		if c.Rate, err = strconv.Atoi(fields[n-1]); err != nil {
+			return nil, errors.Errorf("cannot parse status line: %q", line)
+		}
+		c.Name = strings.TrimSuffix(strings.Join(fields[3:n-4], " "), "^7")
+		status.Clients = append(status.Clients, c)
+	}
+	return status, nil
+}
+
+// GetServerStatus returns the clients connected to the server using rcon.
+func GetServerStatus(addr, password string) (*ServerStatus, error) {
+	resp, err := Rcon(addr, password, "status")
+	if err != nil {
+		return nil, err
+	}
+	return ParseServerStatus(resp)
+}
+
+var cvarRegexp = regexp.MustCompile(`"([^"]+)" is:"(.*?)(\^7)?"`)
+
+// ParseCvar parses the output of querying a cvar by name, e.g.:
+//
+//	"sv_hostname" is:"quakekube^7" default:"noname^7"
+func ParseCvar(data string) (name, value string, ok bool) {
+	m := cvarRegexp.FindStringSubmatch(data)
+	if m == nil {
+		return "", "", false
+	}
+	return m[1], m[2], true
+}
+
+var cvarListRegexp = regexp.MustCompile(`(?m)^[A-Z? ]*?(\S+) "[^"]*"\r?$`)
+
+// ParseCvarList returns the names of the cvars in the output of the cvarlist
+// command, e.g.:
+//
+//	S R     sv_hostname "quakekube"
+//	        sv_fps "20"
+//
+//	2 total cvars
+func ParseCvarList(data string) []string {
+	names := make([]string, 0)
+	for _, m := range cvarListRegexp.FindAllStringSubmatch(data, -1) {
+		names = append(names, m[1])
+	}
+	return names
+}
@@ -0,0 +1,56 @@
+package net
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const statusOutput = `map: q3dm7
+num score ping name            lastmsg address               qport rate
+--- ----- ---- --------------- ------- --------------------- ----- -----
+  0     5    0 Sarge^7              50 bot                       0 16384
+  1    12   45 Big ^1Player^7        0 127.0.0.1:53211        1234 25000
+  2     0 CNCT newbie^7            100 10.0.0.3:27960          4321  5000
+`
+
+func TestParseServerStatus(t *testing.T) {
+	status, err := ParseServerStatus(statusOutput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &ServerStatus{
+		Map: "q3dm7",
+		Clients: []Client{
+			{Num: 0, Score: 5, Ping: 0, Name: "Sarge", Address: "bot", QPort: 0, Rate: 16384},
+			{Num: 1, Score: 12, Ping: 45, Name: "Big ^1Player", Address: "127.0.0.1:53211", QPort: 1234, Rate: 25000},
+			{Num: 2, Score: 0, Ping: -1, Name: "newbie", Address: "10.0.0.3:27960", QPort: 4321, Rate: 5000},
+		},
+	}
+	if diff := cmp.Diff(expected, status); diff != "" {
+		t.Errorf("net: status differs: (-want +got)\n%s", diff)
+	}
+}
+
+func TestParseCvar(t *testing.T) {
+	name, value, ok := ParseCvar(`"sv_hostname" is:"quake kube^7" default:"noname^7"` + "\n")
+	if !ok {
+		t.Fatal("net: expected cvar")
+	}
+	if diff := cmp.Diff([]string{"sv_hostname", "quake kube"}, []string{name, value}); diff != "" {
+		t.Errorf("net: cvar differs: (-want +got)\n%s", diff)
+	}
+	if _, _, ok := ParseCvar("Unknown command \"nope^7\"\n"); ok {
+		t.Error("net: expected unknown cvar")
+	}
+}
+
+func TestParseCvarList(t *testing.T) {
+	data := "S R     sv_hostname \"quake kube\"\n        sv_fps \"20\"\n\n2 total cvars\n2 cvar indexes\n"
+	if diff := cmp.Diff([]string{"sv_hostname", "sv_fps"}, ParseCvarList(data)); diff != "" {
+		t.Errorf("net: cvar list differs: (-want +got)\n%s", diff)
+	}
+	if names := ParseCvarList("\n0 total cvars\n0 cvar indexes\n"); len(names) != 0 {
+		t.Errorf("net: expected no cvars, received %v", names)
+	}
+}
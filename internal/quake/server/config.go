@@ -39,6 +39,10 @@ func (gt GameType) String() string {
 	}
 }
 
+func (gt GameType) MarshalText() ([]byte, error) {
+	return []byte(gt.String()), nil
+}
+
 func (gt *GameType) UnmarshalText(data []byte) error {
 	switch string(data) {
 	case "FreeForAll", "FFA":
@@ -57,63 +61,296 @@ func (gt *GameType) UnmarshalText(data []byte) error {
 	return nil
 }
 
+// IsTeamGame reports whether players are split into the red and blue teams.
+func (gt GameType) IsTeamGame() bool {
+	return gt == TeamDeathmatch || gt == CaptureTheFlag
+}
+
 type Config struct {
-	FragLimit int             `name:"fraglimit"`
-	TimeLimit metav1.Duration `name:"timelimit"`
+	FragLimit int             `json:"fragLimit" name:"fraglimit"`
+	TimeLimit metav1.Duration `json:"timeLimit" name:"timelimit"`
 
 	BotConfig        `json:"bot"`
 	GameConfig       `json:"game"`
 	FileServerConfig `json:"fs"`
 	ServerConfig     `json:"server"`
+	TeamConfig       `json:"teams,omitempty"`
 	Commands         []string `json:"commands"`
 
-	Maps
+	Maps `json:"maps"`
 }
 
 type BotConfig struct {
-	MinPlayers int  `name:"bot_minplayers"`
-	NoChat     bool `name:"bot_nochat"`
+	MinPlayers int  `json:"minPlayers" name:"bot_minplayers"`
+	NoChat     bool `json:"noChat" name:"bot_nochat"`
+
+	// Bots are added to every map in the rotation, unless the map provides
+	// its own list of bots.
+	Bots Bots `json:"bots"`
+}
+
+type Bot struct {
+	Name string `json:"name"`
+	// Skill is the bot skill level from 1 to 5. When not set, the game
+	// singlePlayerSkill is used, or defaultBotSkill if that is not set.
+	Skill int `json:"skill"`
+	// Team is one of red, blue or free. Bots without a team are assigned one
+	// automatically in team game types.
+	Team  string          `json:"team"`
+	Delay metav1.Duration `json:"delay"`
+}
+
+func (b Bot) command() string {
+	cmd := fmt.Sprintf("addbot %s %d", b.Name, b.Skill)
+	if b.Team == "" && b.Delay.Duration == 0 {
+		return cmd
+	}
+	team := b.Team
+	if team == "" {
+		team = "free"
+	}
+	cmd += " " + team
+	if b.Delay.Duration != 0 {
+		cmd += fmt.Sprintf(" %d", b.Delay.Milliseconds())
+	}
+	return cmd
+}
+
+func (b Bot) validate() error {
+	if b.Name == "" {
+		return errors.New("bot name cannot be empty")
+	}
+	if strings.ContainsAny(b.Name, " \t\";\\") {
+		return errors.Errorf("invalid bot name: %q", b.Name)
+	}
+	// 0 is resolved to the default skill by the rotation
+	if b.Skill != 0 && (b.Skill < 1 || b.Skill > 5) {
+		return errors.Errorf("bot %s: skill must be between 1 and 5, or unset, received %d", b.Name, b.Skill)
+	}
+	switch b.Team {
+	case "", "red", "blue", "free":
+	default:
+		return errors.Errorf("bot %s: unknown team %q", b.Name, b.Team)
+	}
+	if b.Delay.Duration < 0 {
+		return errors.Errorf("bot %s: delay cannot be negative", b.Name)
+	}
+	return nil
+}
+
+type Bots []Bot
+
+// defaultBotSkill is the skill of bots when neither the bot nor
+// singlePlayerSkill set one, the default of g_spSkill in the game.
+const defaultBotSkill = 2
+
+// botSkill returns the skill of bots that do not set one.
+func (c *Config) botSkill() int {
+	if c.SinglePlayerSkill < 1 || c.SinglePlayerSkill > 5 {
+		return defaultBotSkill
+	}
+	return c.SinglePlayerSkill
+}
+
+// defaultTeamBots are the bots used to fill teams when the bot roster is
+// empty.
+var defaultTeamBots = []string{"sarge", "crash", "hunter", "major", "visor", "daemia"}
+
+// TeamConfig only applies to team game types (TeamDeathmatch and
+// CaptureTheFlag) and is not written unless set.
+type TeamConfig struct {
+	RedTeam      string `json:"redTeam,omitempty" name:"g_redteam"`
+	BlueTeam     string `json:"blueTeam,omitempty" name:"g_blueteam"`
+	FriendlyFire bool   `json:"friendlyFire" name:"g_friendlyFire"`
+	AutoJoin     bool   `json:"autoJoin" name:"g_teamAutoJoin"`
+	ForceBalance bool   `json:"forceBalance" name:"g_teamForceBalance"`
+
+	// MaxPlayers is the maximum number of players in the game across both
+	// teams, 0 is unlimited. It is not a limit on each team, which is only
+	// kept balanced with ForceBalance.
+	MaxPlayers int `json:"maxPlayers"`
+
+	// RedBots and BlueBots are the number of bots added to each team. Bot
+	// names are taken from the bot roster in order.
+	RedBots  int `json:"redBots"`
+	BlueBots int `json:"blueBots"`
+}
+
+func (t TeamConfig) isZero() bool {
+	return t == TeamConfig{}
+}
+
+func (t TeamConfig) validate() error {
+	if t.MaxPlayers < 0 {
+		return errors.Errorf("teams: maxPlayers cannot be negative, received %d", t.MaxPlayers)
+	}
+	if t.RedBots < 0 || t.BlueBots < 0 {
+		return errors.New("teams: bot count cannot be negative")
+	}
+	if t.MaxPlayers != 0 && t.RedBots+t.BlueBots > t.MaxPlayers {
+		return errors.Errorf("teams: bot count exceeds maxPlayers %d", t.MaxPlayers)
+	}
+	for _, name := range []string{t.RedTeam, t.BlueTeam} {
+		if strings.ContainsAny(name, "\"\\;") {
+			return errors.Errorf("teams: invalid team name: %q", name)
+		}
+	}
+	return nil
+}
+
+// bots returns the bots used to fill the red and blue teams, skipping the
+// bots already on the map. Names are taken from the roster, then from the
+// default team bots, and the teams are filled for as long as there are names.
+func (t TeamConfig) bots(roster, present Bots, skill int) Bots {
+	seen := make(map[string]bool)
+	for _, b := range present {
+		seen[strings.ToLower(b.Name)] = true
+	}
+	names := make([]string, 0)
+	for _, b := range roster {
+		names = append(names, b.Name)
+	}
+	names = append(names, defaultTeamBots...)
+	bots := make(Bots, 0)
+	for _, name := range names {
+		if len(bots) == t.RedBots+t.BlueBots {
+			break
+		}
+		if seen[strings.ToLower(name)] {
+			continue
+		}
+		seen[strings.ToLower(name)] = true
+		team := "red"
+		if len(bots) >= t.RedBots {
+			team = "blue"
+		}
+		bots = append(bots, Bot{Name: name, Skill: skill, Team: team})
+	}
+	return bots
 }
 
 type GameConfig struct {
-	ForceRespawn      bool            `name:"g_forcerespawn"`
+	ForceRespawn      bool            `json:"forceRespawn" name:"g_forcerespawn"`
 	GameType          GameType        `json:"type" name:"g_gametype"`
-	Inactivity        metav1.Duration `name:"g_inactivity"`
-	Log               string          `name:"g_log"`
-	MOTD              string          `name:"g_motd"`
-	Password          string          `name:"g_password"`
-	QuadFactor        int             `name:"g_quadfactor"`
-	SinglePlayerSkill int             `name:"g_spSkill"`
-	WeaponRespawn     int             `name:"g_weaponrespawn"`
+	Inactivity        metav1.Duration `json:"inactivity" name:"g_inactivity"`
+	Log               string          `json:"log" name:"g_log"`
+	MOTD              string          `json:"motd" name:"g_motd"`
+	Password          string          `json:"password" name:"g_password"`
+	PasswordFrom      *SecretRef      `json:"passwordFrom,omitempty"`
+	QuadFactor        int             `json:"quadFactor" name:"g_quadfactor"`
+	SinglePlayerSkill int             `json:"singlePlayerSkill" name:"g_spSkill"`
+	WeaponRespawn     int             `json:"weaponRespawn" name:"g_weaponrespawn"`
 }
 
 type FileServerConfig struct {
 	// allows people to base mods upon mods syntax to follow
-	BaseGame string `name:"fs_basegame"`
+	BaseGame string `json:"baseGame" name:"fs_basegame"`
 	// set base path root C:\Program Files\Quake III Arena for files to be
 	// downloaded from this path may change for TC's and MOD's
-	BasePath string `name:"fs_basepath"`
+	BasePath string `json:"basePath" name:"fs_basepath"`
 	// toggle if files can be copied from servers or if client will download
-	CopyFiles bool `name:"fs_copyfiles"`
+	CopyFiles bool `json:"copyFiles" name:"fs_copyfiles"`
 	// possibly enables file server debug mode for download/uploads or
 	// something
-	Debug bool `name:"fs_debug"`
+	Debug bool `json:"debug" name:"fs_debug"`
 	// set gamedir set the game folder/dir default is baseq3
-	Game string `name:"fs_game"`
+	Game string `json:"game" name:"fs_game"`
 	// possibly for TC's and MODS the default is the path to quake3.exe
-	HomePath string `name:"fs_homepath"`
+	HomePath string `json:"homePath" name:"fs_homepath"`
 }
 
 type ServerConfig struct {
-	AllowDownload bool   `name:"sv_allowDownload"`
-	DownloadURL   string `name:"sv_dlURL"`
-	Hostname      string `name:"sv_hostname"`
-	MaxClients    int    `name:"sv_maxclients"`
-	Password      string `name:"rconpassword"`
+	AllowDownload bool   `json:"allowDownload" name:"sv_allowDownload"`
+	DownloadURL   string `json:"downloadURL" name:"sv_dlURL"`
+	Hostname      string `json:"hostname" name:"sv_hostname"`
+	MaxClients    int    `json:"maxClients" name:"sv_maxclients"`
+	Password      string `json:"password" name:"rconpassword"`
+
+	PasswordFrom *SecretRef `json:"passwordFrom,omitempty"`
 }
 
 func (c *Config) Marshal() ([]byte, error) {
-	return writeStruct(reflect.Indirect(reflect.ValueOf(c)))
+	cfg := *c
+	cfg.Maps = c.rotation()
+	return writeStruct(reflect.ValueOf(cfg))
+}
+
+// rotation returns the map rotation with the bots for each map resolved
+// against the global bot roster.
+func (c *Config) rotation() Maps {
+	maps := make(Maps, len(c.Maps))
+	for i, m := range c.Maps {
+		bots := c.BotConfig.Bots
+		overridden := m.Bots != nil
+		if overridden {
+			bots = m.Bots
+		}
+		m.Bots = make(Bots, len(bots))
+		for j, b := range bots {
+			if b.Skill == 0 {
+				b.Skill = c.botSkill()
+			}
+			m.Bots[j] = b
+		}
+		if m.Type.IsTeamGame() {
+			// a map that lists its own bots, including none, is not filled
+			if !overridden {
+				m.Bots = append(m.Bots, c.TeamConfig.bots(c.BotConfig.Bots, m.Bots, c.botSkill())...)
+			}
+			m.maxPlayers = c.TeamConfig.MaxPlayers
+		} else if c.TeamConfig.MaxPlayers != 0 {
+			// the player limit must be reset for maps that are not team games
+			m.maxPlayers = -1
+		}
+		maps[i] = m
+	}
+	return maps
+}
+
+// BotNames returns the names of all bots used in the map rotation.
+func (c *Config) BotNames() []string {
+	names := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, m := range c.rotation() {
+		for _, b := range m.Bots {
+			if seen[strings.ToLower(b.Name)] {
+				continue
+			}
+			seen[strings.ToLower(b.Name)] = true
+			names = append(names, b.Name)
+		}
+	}
+	return names
+}
+
+// Validate checks the configuration for values that would otherwise be
+// silently ignored by the dedicated server.
+func (c *Config) Validate() error {
+	if !c.TeamConfig.isZero() {
+		if err := c.TeamConfig.validate(); err != nil {
+			return err
+		}
+		teamGame := c.GameType.IsTeamGame()
+		for _, m := range c.Maps {
+			teamGame = teamGame || m.Type.IsTeamGame()
+		}
+		if !teamGame {
+			return errors.New("teams can only be configured for TeamDeathmatch or CaptureTheFlag")
+		}
+	}
+	for _, b := range c.BotConfig.Bots {
+		if err := b.validate(); err != nil {
+			return err
+		}
+	}
+	for _, m := range c.Maps {
+		for _, b := range m.Bots {
+			if err := b.validate(); err != nil {
+				return errors.Wrapf(err, "map %s", m.Name)
+			}
+		}
+	}
+	return nil
 }
 
 func writeStruct(v reflect.Value) ([]byte, error) {
@@ -123,6 +360,9 @@ func writeStruct(v reflect.Value) ([]byte, error) {
 	var b bytes.Buffer
 	for i := 0; i < v.Type().NumField(); i++ {
 		fv := v.Field(i)
+		if isOmitEmpty(v.Type().Field(i)) && isZero(fv) {
+			continue
+		}
 		switch fv.Kind() {
 		case reflect.Struct:
 			data, err := writeStruct(fv)
@@ -135,7 +375,7 @@ func writeStruct(v reflect.Value) ([]byte, error) {
 			case Maps:
 				data, _ := val.Marshal()
 				b.Write(data)
-			case []string:
+			case []string, Bots:
 			default:
 				panic(fmt.Errorf("received unknown type %T", val))
 			}
@@ -167,6 +407,14 @@ func writeStruct(v reflect.Value) ([]byte, error) {
 	return b.Bytes(), nil
 }
 
+func isOmitEmpty(f reflect.StructField) bool {
+	return strings.HasSuffix(f.Tag.Get("json"), ",omitempty")
+}
+
+func isZero(v reflect.Value) bool {
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+}
+
 func toString(name string, v reflect.Value) string {
 	switch val := v.Interface().(type) {
 	case string:
@@ -216,7 +464,7 @@ func Default() *Config {
 		ServerConfig: ServerConfig{
 			MaxClients: 12,
 			Hostname:   "quakekube",
-			Password:   "changeme",
+			Password:   DefaultPassword,
 		},
 		Maps: Maps{
 			{Name: "q3dm7", Type: FreeForAll},
@@ -232,11 +480,27 @@ type Map struct {
 	CaptureLimit int             `json:"captureLimit"`
 	FragLimit    int             `json:"fragLimit"`
 	TimeLimit    metav1.Duration `json:"timeLimit"`
+
+	// Bots overrides the global bot roster for this map. An empty list
+	// removes all bots for the map.
+	Bots Bots `json:"bots"`
+
+	// maxPlayers is the maximum number of players in a team game, 0 when
+	// not configured and -1 when it must be reset.
+	maxPlayers int
 }
 
 type Maps []Map
 
 func (maps Maps) Marshal() ([]byte, error) {
+	// Bots must be re-added after every map change, so once any map in the
+	// rotation has bots every map starts by removing the previous ones.
+	hasBots := false
+	for _, m := range maps {
+		if len(m.Bots) > 0 {
+			hasBots = true
+		}
+	}
 	var b bytes.Buffer
 	for i, m := range maps {
 		cmds := []string{
@@ -251,7 +515,19 @@ func (maps Maps) Marshal() ([]byte, error) {
 		if m.TimeLimit.Duration != 0 {
 			cmds = append(cmds, fmt.Sprintf("timelimit %s", toString("TimeLimit", reflect.ValueOf(m.TimeLimit))))
 		}
+		switch {
+		case m.maxPlayers > 0:
+			cmds = append(cmds, fmt.Sprintf("g_maxGameClients %d", m.maxPlayers))
+		case m.maxPlayers < 0:
+			cmds = append(cmds, "g_maxGameClients 0")
+		}
 		cmds = append(cmds, fmt.Sprintf("map %s", m.Name))
+		if hasBots {
+			cmds = append(cmds, "kick allbots")
+		}
+		for _, bot := range m.Bots {
+			cmds = append(cmds, bot.command())
+		}
 		nextmap := "d0"
 		if i < len(maps)-1 {
 			nextmap = fmt.Sprintf("d%d", i+1)
@@ -2,6 +2,10 @@ package server
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -88,3 +92,208 @@ func TestConfigMarshal(t *testing.T) {
 		t.Fatalf(diff)
 	}
 }
+
+const botsConfig = `
+game:
+  singlePlayerSkill: 3
+bot:
+  bots:
+  - name: sarge
+  - name: crash
+    skill: 4
+    team: red
+    delay: 2s
+maps:
+- name: q3dm7
+  type: FreeForAll
+- name: q3tourney2
+  type: Tournament
+  bots:
+  - name: hunter
+    skill: 5
+- name: q3dm17
+  type: FreeForAll
+  bots: []
+`
+
+const expectedBotsRotation = `set d0 "seta g_gametype 0 ; map q3dm7 ; kick allbots ; addbot sarge 3 ; addbot crash 4 red 2000 ; set nextmap vstr d1"
+set d1 "seta g_gametype 1 ; map q3tourney2 ; kick allbots ; addbot hunter 5 ; set nextmap vstr d2"
+set d2 "seta g_gametype 0 ; map q3dm17 ; kick allbots ; set nextmap vstr d0"
+vstr d0
+`
+
+func TestConfigMarshalBots(t *testing.T) {
+	cfg := Default()
+	if err := yaml.Unmarshal([]byte(botsConfig), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := cfg.rotation().Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(expectedBotsRotation, string(data)); diff != "" {
+		t.Fatalf("server: rotation differs: (-want +got)\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"sarge", "crash", "hunter"}, cfg.BotNames()); diff != "" {
+		t.Fatalf("server: bot names differ: (-want +got)\n%s", diff)
+	}
+}
+
+func TestConfigBotSkill(t *testing.T) {
+	// a config without singlePlayerSkill leaves g_spSkill at 0, which is not
+	// a valid bot skill
+	cfg := &Config{
+		BotConfig: BotConfig{Bots: Bots{{Name: "sarge"}}},
+		Maps:      Maps{{Name: "q3dm7", Type: FreeForAll}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if b := cfg.rotation()[0].Bots[0]; b.Skill != defaultBotSkill {
+		t.Errorf("server: expected default skill %d, received %d", defaultBotSkill, b.Skill)
+	}
+
+	for _, skill := range []int{-1, 6} {
+		cfg.BotConfig.Bots[0].Skill = skill
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("server: expected skill %d to be rejected", skill)
+		}
+	}
+}
+
+const teamsConfig = `
+game:
+  singlePlayerSkill: 2
+teams:
+  redTeam: Stroggs
+  blueTeam: Pagans
+  friendlyFire: true
+  forceBalance: true
+  maxPlayers: 8
+  redBots: 1
+  blueBots: 2
+maps:
+- name: q3dm17
+  type: FreeForAll
+- name: q3wctf1
+  type: CaptureTheFlag
+  captureLimit: 8
+`
+
+const expectedTeamsRotation = `set d0 "seta g_gametype 0 ; g_maxGameClients 0 ; map q3dm17 ; kick allbots ; set nextmap vstr d1"
+set d1 "seta g_gametype 4 ; capturelimit 8 ; g_maxGameClients 8 ; map q3wctf1 ; kick allbots ; addbot sarge 2 red ; addbot crash 2 blue ; addbot hunter 2 blue ; set nextmap vstr d0"
+vstr d0
+`
+
+func TestConfigMarshalTeams(t *testing.T) {
+	cfg := Default()
+	if err := yaml.Unmarshal([]byte(teamsConfig), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := cfg.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`seta g_redteam "Stroggs"`,
+		`seta g_blueteam "Pagans"`,
+		`seta g_friendlyFire "1"`,
+		`seta g_teamAutoJoin "0"`,
+		`seta g_teamForceBalance "1"`,
+	} {
+		if !strings.Contains(string(data), line+"\n") {
+			t.Errorf("server: expected %q in config", line)
+		}
+	}
+	data, err = cfg.rotation().Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(expectedTeamsRotation, string(data)); diff != "" {
+		t.Fatalf("server: rotation differs: (-want +got)\n%s", diff)
+	}
+
+	// the bots of both teams count towards the player limit
+	cfg.TeamConfig.MaxPlayers = 2
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("server: expected more bots than maxPlayers to be rejected")
+	}
+	cfg.TeamConfig.MaxPlayers = 3
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.Maps = Maps{{Name: "q3dm17", Type: FreeForAll}}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("server: expected teams to be rejected without a team game type")
+	}
+}
+
+func TestConfigTeamBots(t *testing.T) {
+	cfg := &Config{
+		BotConfig:  BotConfig{Bots: Bots{{Name: "Sarge", Skill: 3}}},
+		TeamConfig: TeamConfig{RedBots: 1, BlueBots: 1},
+		Maps: Maps{
+			{Name: "q3wctf1", Type: CaptureTheFlag},
+			{Name: "q3wctf2", Type: CaptureTheFlag, Bots: Bots{}},
+			{Name: "q3wctf3", Type: CaptureTheFlag, Bots: Bots{{Name: "crash", Skill: 4}}},
+		},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	expected := []Bots{
+		// the roster bot is already on the map, so the teams are filled
+		// from the default bots
+		{{Name: "Sarge", Skill: 3}, {Name: "crash", Skill: 2, Team: "red"}, {Name: "hunter", Skill: 2, Team: "blue"}},
+		{},
+		{{Name: "crash", Skill: 4}},
+	}
+	for i, m := range cfg.rotation() {
+		if diff := cmp.Diff(expected[i], m.Bots); diff != "" {
+			t.Errorf("server: %s bots differ: (-want +got)\n%s", m.Name, diff)
+		}
+	}
+}
+
+func TestConfigResolveSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "rcon-password")
+	if err := ioutil.WriteFile(path, []byte("hunter2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("TEST_GAME_PASSWORD", "letmein")
+	defer os.Unsetenv("TEST_GAME_PASSWORD")
+
+	cfg := Default()
+	cfg.ServerConfig.PasswordFrom = &SecretRef{File: path}
+	cfg.GameConfig.PasswordFrom = &SecretRef{Env: "TEST_GAME_PASSWORD"}
+	if err := cfg.ResolveSecrets(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("hunter2", cfg.ServerConfig.Password); diff != "" {
+		t.Errorf("server: rcon password differs: (-want +got)\n%s", diff)
+	}
+	if diff := cmp.Diff("letmein", cfg.GameConfig.Password); diff != "" {
+		t.Errorf("server: game password differs: (-want +got)\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{path}, cfg.SecretFiles()); diff != "" {
+		t.Errorf("server: secret files differ: (-want +got)\n%s", diff)
+	}
+
+	cfg.GameConfig.PasswordFrom = &SecretRef{Env: "TEST_MISSING_PASSWORD"}
+	if err := cfg.ResolveSecrets(); err == nil {
+		t.Error("server: expected error for missing environment variable")
+	}
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+)
+
+const (
+	// consoleHistory is the number of lines of output kept for new
+	// subscribers.
+	consoleHistory = 500
+
+	// consoleBuffer is the number of lines buffered for each subscriber.
+	// Lines are dropped for subscribers that fall further behind than this.
+	consoleBuffer = 256
+)
+
+// consoleLog keeps the recent output of the dedicated server and sends each
+// new line to any subscribers. The zero value is ready to use.
+type consoleLog struct {
+	mu      sync.Mutex
+	partial []byte
+	history []string
+	subs    map[chan string]struct{}
+}
+
+func (l *consoleLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.partial = append(l.partial, p...)
+	for {
+		i := bytes.IndexByte(l.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := strings.TrimRight(string(l.partial[:i]), "\r")
+		l.partial = l.partial[i+1:]
+
+		l.history = append(l.history, line)
+		if len(l.history) > consoleHistory {
+			l.history = l.history[len(l.history)-consoleHistory:]
+		}
+		for ch := range l.subs {
+			select {
+			case ch <- line:
+			default:
+			}
+		}
+	}
+	if len(l.partial) == 0 {
+		l.partial = nil
+	}
+	return len(p), nil
+}
+
+func (l *consoleLog) subscribe() ([]string, <-chan string, func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.subs == nil {
+		l.subs = make(map[chan string]struct{})
+	}
+	ch := make(chan string, consoleBuffer)
+	l.subs[ch] = struct{}{}
+	history := append([]string{}, l.history...)
+	var once sync.Once
+	return history, ch, func() {
+		once.Do(func() {
+			l.mu.Lock()
+			delete(l.subs, ch)
+			l.mu.Unlock()
+		})
+	}
+}
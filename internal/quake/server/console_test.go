@@ -0,0 +1,42 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestConsoleLog(t *testing.T) {
+	var l consoleLog
+	fmt.Fprint(&l, "first\r\nsec")
+	history, lines, cancel := l.subscribe()
+	defer cancel()
+	if diff := cmp.Diff([]string{"first"}, history); diff != "" {
+		t.Errorf("server: history differs: (-want +got)\n%s", diff)
+	}
+
+	fmt.Fprint(&l, "ond\nthird\n")
+	for _, expected := range []string{"second", "third"} {
+		if line := <-lines; line != expected {
+			t.Errorf("server: expected %q, received %q", expected, line)
+		}
+	}
+
+	cancel()
+	fmt.Fprint(&l, "fourth\n")
+	select {
+	case line := <-lines:
+		t.Errorf("server: received %q after cancel", line)
+	default:
+	}
+
+	for i := 0; i < consoleHistory; i++ {
+		fmt.Fprintf(&l, "%d\n", i)
+	}
+	history, _, cancel = l.subscribe()
+	defer cancel()
+	if len(history) != consoleHistory || history[0] != "0" {
+		t.Errorf("server: expected the last %d lines, received %d starting with %q", consoleHistory, len(history), history[0])
+	}
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+)
+
+// MergeStrategy determines how a list in a config file is combined with the
+// same list from the previous config files.
+type MergeStrategy string
+
+const (
+	// MergeReplace replaces the previous list entirely. This is the default
+	// for all lists.
+	MergeReplace MergeStrategy = "replace"
+
+	// MergeAppend adds the entries to the end of the previous list.
+	MergeAppend MergeStrategy = "append"
+
+	// MergePatch merges entries with the entry of the same name in the
+	// previous list, adding entries that do not exist yet. It can only be
+	// used with lists of objects that have a name, such as maps and bots.
+	MergePatch MergeStrategy = "patch"
+)
+
+// mergeKey is the top-level key of a config file that sets the merge strategy
+// for lists, by their path in the config, e.g.:
+//
+//	merge:
+//	  maps: patch
+//	  commands: append
+//	  bot.bots: append
+const mergeKey = "merge"
+
+// ConfigFiles expands the provided paths into the list of config files to
+// load. Directories are expanded to the .yaml, .yml and .json files they
+// contain, in lexical order.
+func ConfigFiles(paths ...string) ([]string, error) {
+	files := make([]string, 0)
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		infos, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		names := make([]string, 0)
+		for _, info := range infos {
+			// Kubernetes ConfigMap volumes contain hidden directories (e.g.
+			// ..data) that must be skipped.
+			if info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+				continue
+			}
+			switch filepath.Ext(info.Name()) {
+			case ".yaml", ".yml", ".json":
+				names = append(names, info.Name())
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			files = append(files, filepath.Join(path, name))
+		}
+	}
+	return files, nil
+}
+
+// LoadConfig reads the config files found at the provided paths and deep
+// merges them, in order, onto the default config.
+func LoadConfig(paths ...string) (*Config, error) {
+	files, err := ConfigFiles(paths...)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(Default())
+	if err != nil {
+		return nil, err
+	}
+	var base map[string]interface{}
+	if err := json.Unmarshal(data, &base); err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var overlay map[string]interface{}
+		if err := yaml.Unmarshal(data, &overlay); err != nil {
+			return nil, errors.Wrapf(err, "cannot unmarshal %s", file)
+		}
+		rules, err := mergeRules(overlay)
+		if err != nil {
+			return nil, errors.Wrap(err, file)
+		}
+		if err := mergeObjects(base, overlay, "", rules); err != nil {
+			return nil, errors.Wrap(err, file)
+		}
+	}
+	data, err = json.Marshal(base)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// mergeRules removes the merge strategies from a config file, returning them
+// by their path.
+func mergeRules(overlay map[string]interface{}) (map[string]MergeStrategy, error) {
+	rules := make(map[string]MergeStrategy)
+	v, ok := overlay[mergeKey]
+	if !ok {
+		return rules, nil
+	}
+	delete(overlay, mergeKey)
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.Errorf("%s must be a map of list paths to merge strategies", mergeKey)
+	}
+	for path, v := range m {
+		s, _ := v.(string)
+		switch strategy := MergeStrategy(s); strategy {
+		case MergeReplace, MergeAppend, MergePatch:
+			rules[strings.ToLower(path)] = strategy
+		default:
+			return nil, errors.Errorf("unknown merge strategy for %s: %q", path, s)
+		}
+	}
+	return rules, nil
+}
+
+func mergeObjects(dst, src map[string]interface{}, path string, rules map[string]MergeStrategy) error {
+	for k, sv := range src {
+		// keys are matched case-insensitively, the same as when the config is
+		// unmarshaled
+		key := k
+		for dk := range dst {
+			if strings.EqualFold(dk, k) {
+				key = dk
+				break
+			}
+		}
+		p := strings.ToLower(strings.TrimPrefix(path+"."+key, "."))
+		dv, ok := dst[key]
+		if !ok || dv == nil || sv == nil {
+			dst[key] = sv
+			continue
+		}
+		switch sv := sv.(type) {
+		case map[string]interface{}:
+			dm, ok := dv.(map[string]interface{})
+			if !ok {
+				dst[key] = sv
+				continue
+			}
+			if err := mergeObjects(dm, sv, p, rules); err != nil {
+				return err
+			}
+		case []interface{}:
+			dl, ok := dv.([]interface{})
+			if !ok {
+				dst[key] = sv
+				continue
+			}
+			merged, err := mergeLists(dl, sv, p, rules)
+			if err != nil {
+				return err
+			}
+			dst[key] = merged
+		default:
+			dst[key] = sv
+		}
+	}
+	return nil
+}
+
+func mergeLists(dst, src []interface{}, path string, rules map[string]MergeStrategy) ([]interface{}, error) {
+	switch rules[path] {
+	case MergeAppend:
+		return append(dst, src...), nil
+	case MergePatch:
+		for _, sv := range src {
+			sm, ok := sv.(map[string]interface{})
+			if !ok || sm["name"] == nil {
+				return nil, errors.Errorf("%s: patch requires a list of objects with a name", path)
+			}
+			found := false
+			for _, dv := range dst {
+				dm, ok := dv.(map[string]interface{})
+				if !ok || dm["name"] != sm["name"] {
+					continue
+				}
+				if err := mergeObjects(dm, sm, path, rules); err != nil {
+					return nil, err
+				}
+				found = true
+				break
+			}
+			if !found {
+				dst = append(dst, sm)
+			}
+		}
+		return dst, nil
+	default:
+		return src, nil
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"base.yaml": `
+fragLimit: 20
+commands:
+- seta sv_timeout 120
+maps:
+- name: q3dm7
+  type: FreeForAll
+- name: q3wctf1
+  type: CaptureTheFlag
+  captureLimit: 8
+`,
+		"overlays/10-env.yaml": `
+merge:
+  commands: append
+  maps: patch
+server:
+  hostname: staging
+commands:
+- seta g_inactivity 600
+maps:
+- name: q3wctf1
+  captureLimit: 5
+- name: q3dm17
+  type: FreeForAll
+`,
+		"overlays/20-server.yaml": `
+game:
+  motd: hello
+`,
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg, err := LoadConfig(filepath.Join(dir, "base.yaml"), filepath.Join(dir, "overlays"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Default()
+	expected.FragLimit = 20
+	expected.Hostname = "staging"
+	expected.MOTD = "hello"
+	expected.Commands = []string{"seta sv_timeout 120", "seta g_inactivity 600"}
+	expected.Maps = Maps{
+		{Name: "q3dm7", Type: FreeForAll},
+		{Name: "q3wctf1", Type: CaptureTheFlag, CaptureLimit: 5},
+		{Name: "q3dm17", Type: FreeForAll},
+	}
+	if diff := cmp.Diff(expected, cfg, cmpopts.IgnoreUnexported(Map{})); diff != "" {
+		t.Fatalf("server: merged config differs: (-want +got)\n%s", diff)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "overlays/30-bad.yaml"), []byte("merge:\n  commands: patch\ncommands:\n- say hi\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(filepath.Join(dir, "base.yaml"), filepath.Join(dir, "overlays")); err == nil {
+		t.Fatal("server: expected error when patching a list without names")
+	}
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	quakenet "github.com/criticalstack/quake-kube/internal/quake/net"
+	"github.com/criticalstack/quake-kube/internal/quake/stats"
+)
+
+// matchTracker builds the match history from getstatus snapshots. A match
+// ends when the map or game type changes, or when the scores are reset on the
+// same map (e.g. map_restart or the same map being next in the rotation).
+// getstatus has no team scores, so they are only read from the output of the
+// server.
+type matchTracker struct {
+	store   *stats.Store
+	current *stats.Match
+	scores  map[string]stats.PlayerScore
+
+	// teams is the team of each player, which is not in getstatus and is
+	// read from the output of the server instead.
+	teams map[string]string
+}
+
+func newMatchTracker(store *stats.Store) *matchTracker {
+	return &matchTracker{store: store, teams: make(map[string]string)}
+}
+
+// output reads the team of each player, and the team scores at the end of a
+// match, from the game log lines printed by the dedicated server:
+//
+//	ClientUserinfoChanged: 0 n\Sarge\t\1\model\sarge
+//	red:8  blue:5
+func (t *matchTracker) output(line string) {
+	if strings.HasPrefix(line, "ClientUserinfoChanged: ") {
+		parts := strings.SplitN(line, " ", 3)
+		if len(parts) != 3 {
+			return
+		}
+		info := parseInfo(parts[2])
+		name, ok := info["n"]
+		if !ok {
+			return
+		}
+		switch info["t"] {
+		case "1":
+			t.teams[name] = "red"
+		case "2":
+			t.teams[name] = "blue"
+		default:
+			delete(t.teams, name)
+		}
+		return
+	}
+	var red, blue int
+	if n, _ := fmt.Sscanf(line, "red:%d  blue:%d", &red, &blue); n == 2 && t.current != nil {
+		t.current.RedScore, t.current.BlueScore = red, blue
+	}
+}
+
+// parseInfo parses an info string of backslash separated keys and values.
+func parseInfo(s string) map[string]string {
+	info := make(map[string]string)
+	parts := strings.Split(strings.TrimPrefix(s, "\\"), "\\")
+	for i := 0; i+1 < len(parts); i += 2 {
+		info[parts[i]] = parts[i+1]
+	}
+	return info
+}
+
+func (t *matchTracker) observe(status *quakenet.StatusResponse, now time.Time) error {
+	mapname := status.Configuration["mapname"]
+	gametype := FreeForAll
+	if v, err := strconv.Atoi(status.Configuration["g_gametype"]); err == nil {
+		gametype = GameType(v)
+	}
+	if t.current != nil {
+		switch {
+		case t.current.Map != mapname, t.current.GameType != gametype.String(), t.scoresReset(status.Players):
+			if err := t.finish(); err != nil {
+				return err
+			}
+		}
+	}
+	if t.current == nil {
+		t.current = &stats.Match{
+			Map:      mapname,
+			GameType: gametype.String(),
+			Start:    now,
+		}
+		t.scores = make(map[string]stats.PlayerScore)
+	}
+	t.current.End = now
+
+	// players that leave before the end of the match keep their last score
+	for _, p := range status.Players {
+		ps := stats.PlayerScore{Name: p.Name, Score: p.Score, Ping: p.Ping}
+		if gametype.IsTeamGame() {
+			ps.Team = t.teams[p.Name]
+		}
+		t.scores[p.Name] = ps
+	}
+	return nil
+}
+
+// scoresReset reports whether every player that was previously scoring is
+// now back to zero.
+func (t *matchTracker) scoresReset(players []quakenet.Player) bool {
+	reset := false
+	for _, p := range players {
+		prev, ok := t.scores[p.Name]
+		if !ok {
+			continue
+		}
+		if p.Score != 0 || prev.Score == 0 {
+			return false
+		}
+		reset = true
+	}
+	return reset
+}
+
+// finish records the current match, unless nobody played in it.
+func (t *matchTracker) finish() error {
+	m := t.current
+	t.current = nil
+	if len(t.scores) == 0 {
+		return nil
+	}
+	m.Duration = metav1.Duration{Duration: m.End.Sub(m.Start)}
+	for _, p := range t.scores {
+		m.Players = append(m.Players, p)
+	}
+	return t.store.Record(m)
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	quakenet "github.com/criticalstack/quake-kube/internal/quake/net"
+	"github.com/criticalstack/quake-kube/internal/quake/stats"
+)
+
+func TestMatchTrackerTeams(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := stats.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	tracker := newMatchTracker(store)
+	for _, line := range []string{
+		`ClientUserinfoChanged: 0 n\Sarge\t\1\model\sarge`,
+		`ClientUserinfoChanged: 1 n\player\t\2\model\visor`,
+		`ClientUserinfoChanged: 2 n\Crash\t\2\model\crash`,
+	} {
+		tracker.output(line)
+	}
+	now := time.Date(2020, 8, 1, 12, 0, 0, 0, time.UTC)
+	ctf := func(players ...quakenet.Player) *quakenet.StatusResponse {
+		return &quakenet.StatusResponse{
+			Configuration: map[string]string{"mapname": "q3wctf1", "g_gametype": "4"},
+			Players:       players,
+		}
+	}
+	// the top scorer is on the losing team
+	if err := tracker.observe(ctf(
+		quakenet.Player{Name: "Sarge", Score: 30},
+		quakenet.Player{Name: "player", Score: 10},
+		quakenet.Player{Name: "Crash", Score: 5},
+	), now); err != nil {
+		t.Fatal(err)
+	}
+	tracker.output("red:3  blue:5")
+	next := &quakenet.StatusResponse{
+		Configuration: map[string]string{"mapname": "q3dm17", "g_gametype": "0"},
+	}
+	if err := tracker.observe(next, now.Add(10*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	matches, err := store.Matches(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 {
+		t.Fatalf("server: expected 1 match, received %d", len(matches))
+	}
+	m := matches[0]
+	if m.RedScore != 3 || m.BlueScore != 5 {
+		t.Errorf("server: expected team scores 3-5, received %d-%d", m.RedScore, m.BlueScore)
+	}
+	if diff := cmp.Diff([]string{"player", "Crash"}, m.Winners()); diff != "" {
+		t.Errorf("server: winners differ: (-want +got)\n%s", diff)
+	}
+}
+
+func TestMatchTrackerStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := stats.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	// without any output from the server, the scoreboard only comes from the
+	// getstatus snapshots
+	tracker := newMatchTracker(store)
+	now := time.Date(2020, 8, 1, 12, 0, 0, 0, time.UTC)
+	ffa := func(players ...quakenet.Player) *quakenet.StatusResponse {
+		return &quakenet.StatusResponse{
+			Configuration: map[string]string{"mapname": "q3dm7", "g_gametype": "0"},
+			Players:       players,
+		}
+	}
+	if err := tracker.observe(ffa(
+		quakenet.Player{Name: "Sarge", Score: 2, Ping: 0},
+		quakenet.Player{Name: "player", Score: 1, Ping: 40},
+	), now); err != nil {
+		t.Fatal(err)
+	}
+	// player leaves before the end of the match and keeps their last score
+	if err := tracker.observe(ffa(
+		quakenet.Player{Name: "Sarge", Score: 7, Ping: 0},
+	), now.Add(5*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	next := &quakenet.StatusResponse{
+		Configuration: map[string]string{"mapname": "q3dm17", "g_gametype": "0"},
+	}
+	if err := tracker.observe(next, now.Add(10*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	matches, err := store.Matches(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 {
+		t.Fatalf("server: expected 1 match, received %d", len(matches))
+	}
+	m := matches[0]
+	expected := []stats.PlayerScore{
+		{Name: "Sarge", Score: 7},
+		{Name: "player", Score: 1, Ping: 40},
+	}
+	sortPlayers := cmpopts.SortSlices(func(a, b stats.PlayerScore) bool { return a.Name < b.Name })
+	if diff := cmp.Diff(expected, m.Players, sortPlayers); diff != "" {
+		t.Errorf("server: players differ: (-want +got)\n%s", diff)
+	}
+	if m.Map != "q3dm7" || m.GameType != FreeForAll.String() {
+		t.Errorf("server: expected a FreeForAll match on q3dm7, received %s on %s", m.GameType, m.Map)
+	}
+	if m.RedScore != 0 || m.BlueScore != 0 {
+		t.Errorf("server: expected no team scores, received %d-%d", m.RedScore, m.BlueScore)
+	}
+	if diff := cmp.Diff([]string{"Sarge"}, m.Winners()); diff != "" {
+		t.Errorf("server: winners differ: (-want +got)\n%s", diff)
+	}
+}
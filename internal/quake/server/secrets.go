@@ -0,0 +1,89 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultPassword is the rcon password used by the default configuration.
+const DefaultPassword = "changeme"
+
+// SecretRef references a value that is kept outside of the config file, such
+// as a file mounted from a Kubernetes Secret or an environment variable. When
+// both are set, the file takes precedence.
+type SecretRef struct {
+	File string `json:"file,omitempty"`
+	Env  string `json:"env,omitempty"`
+}
+
+func (r *SecretRef) Resolve() (string, error) {
+	if r.File != "" {
+		data, err := ioutil.ReadFile(r.File)
+		if err != nil {
+			return "", errors.Wrapf(err, "cannot read secret file %q", r.File)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	if r.Env != "" {
+		v, ok := os.LookupEnv(r.Env)
+		if !ok {
+			return "", errors.Errorf("secret environment variable %q is not set", r.Env)
+		}
+		return v, nil
+	}
+	return "", errors.New("secret reference must specify a file or env")
+}
+
+// ResolveSecrets sets every string field that has a matching <Field>From
+// secret reference, e.g. PasswordFrom sets Password.
+func (c *Config) ResolveSecrets() error {
+	return walkSecrets(reflect.ValueOf(c).Elem(), func(ref *SecretRef, field reflect.Value) error {
+		v, err := ref.Resolve()
+		if err != nil {
+			return err
+		}
+		field.SetString(v)
+		return nil
+	})
+}
+
+// SecretFiles returns the files referenced by secrets in the config.
+func (c *Config) SecretFiles() []string {
+	files := make([]string, 0)
+	_ = walkSecrets(reflect.ValueOf(c).Elem(), func(ref *SecretRef, field reflect.Value) error {
+		if ref.File != "" {
+			files = append(files, ref.File)
+		}
+		return nil
+	})
+	return files
+}
+
+func walkSecrets(v reflect.Value, fn func(*SecretRef, reflect.Value) error) error {
+	for i := 0; i < v.NumField(); i++ {
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct {
+			if err := walkSecrets(fv, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		ref, ok := fv.Interface().(*SecretRef)
+		if !ok || ref == nil {
+			continue
+		}
+		name := strings.TrimSuffix(v.Type().Field(i).Name, "From")
+		field := v.FieldByName(name)
+		if !field.IsValid() || field.Kind() != reflect.String {
+			return errors.Errorf("secret %s does not reference a string field", v.Type().Field(i).Name)
+		}
+		if err := fn(ref, field); err != nil {
+			return errors.Wrapf(err, "%s", name)
+		}
+	}
+	return nil
+}
@@ -2,19 +2,27 @@ package server
 
 import (
 	"context"
+	"io"
 	"io/ioutil"
-	"log"
 	"net"
 	"os"
+	osexec "os/exec"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
-	"sigs.k8s.io/yaml"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 
+	"github.com/criticalstack/quake-kube/internal/quake/content"
 	quakenet "github.com/criticalstack/quake-kube/internal/quake/net"
+	"github.com/criticalstack/quake-kube/internal/quake/stats"
 	"github.com/criticalstack/quake-kube/internal/util/exec"
+	logutil "github.com/criticalstack/quake-kube/internal/util/log"
 )
 
 var (
@@ -42,8 +50,54 @@ var (
 type Server struct {
 	Dir           string
 	WatchInterval time.Duration
-	ConfigFile    string
 	Addr          string
+
+	// ConfigFiles are the config files, or directories of config files, that
+	// are merged in order onto the default config.
+	ConfigFiles []string
+
+	// Stats records the match history, when set.
+	Stats *stats.Store
+
+	// AllowDefaultPassword allows the server to start when the rcon password
+	// is left as DefaultPassword.
+	AllowDefaultPassword bool
+
+	mu sync.Mutex
+
+	// secretFiles are the secret files referenced by the last loaded config,
+	// which are watched alongside the config file.
+	secretFiles []string
+
+	// rconPassword is the rcon password from the last loaded config, used to
+	// send commands to the dedicated server.
+	rconPassword string
+
+	// localAddr is the address used to reach the dedicated server.
+	localAddr string
+
+	// stdin is connected to the dedicated server console, and is kept across
+	// restarts of the process.
+	stdin *os.File
+
+	// console is the output of the dedicated server.
+	console consoleLog
+
+	// process is the state of the dedicated server process.
+	process ProcessState
+
+	log *zap.Logger
+}
+
+// ProcessState is the state of the dedicated server process. A process that
+// has not started has no Pid.
+type ProcessState struct {
+	Pid     int
+	Running bool
+	Started time.Time
+
+	// Err is why the process exited, which is nil when it exited cleanly.
+	Err error
 }
 
 func (s *Server) Start(ctx context.Context) error {
@@ -63,25 +117,37 @@ func (s *Server) Start(ctx context.Context) error {
 		"+set", "com_gamename", "Quake3Arena",
 		"+exec", "server.cfg",
 	}
+	s.log = zap.L().Named("server").With(zap.String("instance", s.Addr))
+
+	// the output of the dedicated server is logged a line at a time
+	output := s.log.Named("ioq3ded")
+	levels := map[string]zapcore.Level{
+		"WARNING": zapcore.WarnLevel,
+		"ERROR":   zapcore.ErrorLevel,
+	}
 	cmd := exec.CommandContext(ctx, "ioq3ded", args...)
 	cmd.Dir = s.Dir
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	if s.ConfigFile == "" {
-		cfg := Default()
-		data, err := cfg.Marshal()
-		if err != nil {
-			return err
-		}
-		if err := ioutil.WriteFile(filepath.Join(s.Dir, "baseq3/server.cfg"), data, 0644); err != nil {
-			return err
-		}
-		if err := cmd.Start(); err != nil {
-			return err
-		}
-		return cmd.Wait()
+	cmd.Stdout = io.MultiWriter(&logutil.Writer{Log: output, Level: zapcore.InfoLevel, Prefixes: levels}, &s.console)
+	cmd.Stderr = io.MultiWriter(&logutil.Writer{Log: output, Level: zapcore.WarnLevel, Prefixes: levels}, &s.console)
+
+	// the read side of the pipe is passed directly to the process, so it can
+	// be reused when the process is restarted
+	stdin, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer stdin.Close()
+	defer w.Close()
+	cmd.Stdin = stdin
+
+	addr := s.Addr
+	if net.ParseIP(host).IsUnspecified() {
+		addr = net.JoinHostPort("127.0.0.1", port)
 	}
+	s.mu.Lock()
+	s.stdin = w
+	s.localAddr = addr
+	s.mu.Unlock()
 
 	if err := s.reload(); err != nil {
 		return err
@@ -89,41 +155,18 @@ func (s *Server) Start(ctx context.Context) error {
 	if err := cmd.Start(); err != nil {
 		return err
 	}
+	s.started(cmd.Cmd)
 
-	go func() {
-		if err := cmd.Wait(); err != nil {
-			log.Println(err)
-		}
-	}()
-
-	go func() {
-		addr := s.Addr
-		if net.ParseIP(host).IsUnspecified() {
-			addr = net.JoinHostPort("127.0.0.1", port)
-		}
-		tick := time.NewTicker(5 * time.Second)
-		defer tick.Stop()
+	// the status is polled for metrics and the match history whether or not
+	// the config is watched, until the server stops
+	pollCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go s.pollStatus(pollCtx, addr)
 
-		for {
-			select {
-			case <-tick.C:
-				status, err := quakenet.GetStatus(addr)
-				if err != nil {
-					log.Printf("metrics: get status failed %v", err)
-					continue
-				}
-				actrvePlayers.Set(float64(len(status.Players)))
-				for _, p := range status.Players {
-					if mapname, ok := status.Configuration["mapname"]; ok {
-						scores.WithLabelValues(p.Name, mapname).Set(float64(p.Score))
-					}
-					pings.WithLabelValues(p.Name).Set(float64(p.Ping))
-				}
-			case <-ctx.Done():
-				return
-			}
-		}
-	}()
+	if len(s.ConfigFiles) == 0 {
+		return s.wait(cmd.Cmd)
+	}
+	go s.wait(cmd.Cmd)
 
 	ch, err := s.watch(ctx)
 	if err != nil {
@@ -137,44 +180,194 @@ func (s *Server) Start(ctx context.Context) error {
 				return err
 			}
 			configReloads.Inc()
+			s.log.Info("reloaded config, restarting ioq3ded")
 			if err := cmd.Restart(ctx); err != nil {
 				return err
 			}
-			go func() {
-				if err := cmd.Wait(); err != nil {
-					log.Println(err)
-				}
-			}()
+			s.started(cmd.Cmd)
+			go s.wait(cmd.Cmd)
 		case <-ctx.Done():
 			return ctx.Err()
 		}
 	}
 }
 
+// pollStatus records the status of the server in the metrics and the match
+// history until the context is done.
+func (s *Server) pollStatus(ctx context.Context, addr string) {
+	tick := time.NewTicker(5 * time.Second)
+	defer tick.Stop()
+
+	var (
+		matches *matchTracker
+		lines   <-chan string
+	)
+	if s.Stats != nil {
+		matches = newMatchTracker(s.Stats)
+		var cancel func()
+		_, lines, cancel = s.console.subscribe()
+		defer cancel()
+	}
+
+	for {
+		select {
+		case line := <-lines:
+			matches.output(line)
+		case <-tick.C:
+			status, err := quakenet.GetStatus(addr)
+			if err != nil {
+				s.log.Warn("cannot get status", zap.Error(err))
+				continue
+			}
+			actrvePlayers.Set(float64(len(status.Players)))
+			for _, p := range status.Players {
+				if mapname, ok := status.Configuration["mapname"]; ok {
+					scores.WithLabelValues(p.Name, mapname).Set(float64(p.Score))
+				}
+				pings.WithLabelValues(p.Name).Set(float64(p.Ping))
+			}
+			if matches != nil {
+				if err := matches.observe(status, time.Now()); err != nil {
+					s.log.Error("cannot record match", zap.Error(err))
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// started records that the process has started.
+func (s *Server) started(proc *osexec.Cmd) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.process = ProcessState{
+		Pid:     proc.Process.Pid,
+		Running: true,
+		Started: time.Now(),
+	}
+	s.log.Info("started ioq3ded", zap.Int("pid", proc.Process.Pid))
+}
+
+// wait waits for the process to exit and records why. A process that has
+// been replaced by a restart is only logged.
+func (s *Server) wait(proc *osexec.Cmd) error {
+	err := proc.Wait()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pid := proc.Process.Pid
+	if s.process.Pid == pid {
+		s.process.Running = false
+		s.process.Err = err
+		s.log.Error("ioq3ded exited", zap.Int("pid", pid), zap.Error(err))
+	} else {
+		s.log.Debug("ioq3ded stopped for restart", zap.Int("pid", pid), zap.Error(err))
+	}
+	return err
+}
+
+// Process returns the state of the dedicated server process.
+func (s *Server) Process() ProcessState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.process
+}
+
 func (s *Server) reload() error {
-	data, err := ioutil.ReadFile(s.ConfigFile)
+	cfg, err := s.loadConfig()
 	if err != nil {
 		return err
 	}
-	cfg := Default()
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
+	data, err := cfg.Marshal()
+	if err != nil {
 		return err
 	}
-	data, err = cfg.Marshal()
+	return ioutil.WriteFile(filepath.Join(s.Dir, "baseq3/server.cfg"), data, 0644)
+}
+
+// loadConfig merges the config files onto the default config and resolves
+// any secrets it references.
+func (s *Server) loadConfig() (*Config, error) {
+	cfg, err := LoadConfig(s.ConfigFiles...)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.ResolveSecrets(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.secretFiles = cfg.SecretFiles()
+	s.rconPassword = cfg.ServerConfig.Password
+	s.mu.Unlock()
+	if cfg.ServerConfig.Password == DefaultPassword && !s.AllowDefaultPassword {
+		return nil, errors.Errorf("the rcon password must be changed from the default %q", DefaultPassword)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if err := s.validateBots(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Exec runs a command on the dedicated server. The command is sent using rcon
+// when an rcon password is configured, returning the output of the command.
+// Otherwise, it is written to the server console and no output is returned.
+func (s *Server) Exec(cmd string) (string, error) {
+	s.mu.Lock()
+	addr, password, stdin := s.localAddr, s.rconPassword, s.stdin
+	s.mu.Unlock()
+
+	if strings.ContainsAny(cmd, "\r\n") {
+		return "", errors.Errorf("invalid command: %q", cmd)
+	}
+	if addr == "" {
+		return "", errors.New("server has not started")
+	}
+	if password != "" {
+		return quakenet.Rcon(addr, password, cmd)
+	}
+	if _, err := stdin.WriteString(cmd + "\n"); err != nil {
+		return "", err
+	}
+	return "", nil
+}
+
+// Subscribe returns the recent output of the dedicated server, and a channel
+// that receives each new line of output until cancel is called.
+func (s *Server) Subscribe() (history []string, lines <-chan string, cancel func()) {
+	return s.console.subscribe()
+}
+
+// validateBots ensures that every bot in the map rotation is defined by one of
+// the pk3 files in the server directory.
+func (s *Server) validateBots(cfg *Config) error {
+	names := cfg.BotNames()
+	if len(names) == 0 {
+		return nil
+	}
+	bots, err := content.ListBots(s.Dir)
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(filepath.Join(s.Dir, "baseq3/server.cfg"), data, 0644)
+	known := make(map[string]bool)
+	for _, name := range bots {
+		known[strings.ToLower(name)] = true
+	}
+	for _, name := range names {
+		if !known[strings.ToLower(name)] {
+			return errors.Errorf("bot %q is not defined in any pk3 file in %s", name, s.Dir)
+		}
+	}
+	return nil
 }
 
 func (s *Server) watch(ctx context.Context) (<-chan struct{}, error) {
 	if s.WatchInterval == 0 {
 		s.WatchInterval = 15 * time.Second
 	}
-	cur, err := os.Stat(s.ConfigFile)
-	if err != nil {
-		return nil, err
-	}
+	cur := s.modTimes()
 
 	ch := make(chan struct{})
 
@@ -185,12 +378,14 @@ func (s *Server) watch(ctx context.Context) (<-chan struct{}, error) {
 		for {
 			select {
 			case <-ticker.C:
-				if fi, err := os.Stat(s.ConfigFile); err == nil {
-					if fi.ModTime().After(cur.ModTime()) {
+				next := s.modTimes()
+				for path, t := range next {
+					if prev, ok := cur[path]; ok && t.After(prev) {
 						ch <- struct{}{}
+						break
 					}
-					cur = fi
 				}
+				cur = next
 			case <-ctx.Done():
 				return
 			}
@@ -198,3 +393,24 @@ func (s *Server) watch(ctx context.Context) (<-chan struct{}, error) {
 	}()
 	return ch, nil
 }
+
+// modTimes returns the modification times of the config files, the
+// directories they are in and the secret files they reference. Files mounted
+// from a Kubernetes ConfigMap or Secret are updated by swapping a symlink,
+// which is followed by os.Stat.
+func (s *Server) modTimes() map[string]time.Time {
+	files := append([]string{}, s.ConfigFiles...)
+	if expanded, err := ConfigFiles(s.ConfigFiles...); err == nil {
+		files = append(files, expanded...)
+	}
+	s.mu.Lock()
+	files = append(files, s.secretFiles...)
+	s.mu.Unlock()
+	times := make(map[string]time.Time)
+	for _, path := range files {
+		if fi, err := os.Stat(path); err == nil {
+			times[path] = fi.ModTime()
+		}
+	}
+	return times
+}
@@ -0,0 +1,226 @@
+package stats
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Standing is the total for a player over a period of time.
+type Standing struct {
+	Name    string `json:"name"`
+	Frags   int    `json:"frags"`
+	Matches int    `json:"matches"`
+	Wins    int    `json:"wins"`
+}
+
+func (st *Standing) add(frags int, won bool) {
+	st.Frags += frags
+	st.Matches++
+	if won {
+		st.Wins++
+	}
+}
+
+// dayFormat is the key of the daily standings in the index.
+const dayFormat = "2006-01-02"
+
+// TopFraggers returns up to limit players with the most frags in matches
+// that ended after since. All matches are counted when since is zero. The
+// totals are kept by day, so every match that ended on the same day (UTC) as
+// since is counted.
+func (s *Store) TopFraggers(since time.Time, limit int) ([]*Standing, error) {
+	standings, err := s.standings(since)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*Standing, 0, len(standings))
+	for _, st := range standings {
+		result = append(result, st)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Frags == result[j].Frags {
+			return result[i].Name < result[j].Name
+		}
+		return result[i].Frags > result[j].Frags
+	})
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+// standings returns the total for each player since the day of since, from
+// the index when it has the days, or else from the log.
+func (s *Store) standings(since time.Time) (map[string]*Standing, error) {
+	standings := make(map[string]*Standing)
+	get := func(name string) *Standing {
+		st, ok := standings[name]
+		if !ok {
+			st = &Standing{Name: name}
+			standings[name] = st
+		}
+		return st
+	}
+	day := since.UTC().Format(dayFormat)
+
+	s.mu.RLock()
+	switch {
+	case since.IsZero():
+		for name, p := range s.idx.Players {
+			standings[name] = &Standing{Name: name, Frags: p.Frags, Matches: p.Matches, Wins: p.Wins}
+		}
+		s.mu.RUnlock()
+		return standings, nil
+	case day >= s.idx.DailySince:
+		for d, daily := range s.idx.Daily {
+			if d < day {
+				continue
+			}
+			for name, st := range daily {
+				total := get(name)
+				total.Frags += st.Frags
+				total.Matches += st.Matches
+				total.Wins += st.Wins
+			}
+		}
+		s.mu.RUnlock()
+		return standings, nil
+	}
+	s.mu.RUnlock()
+
+	err := s.Each(func(m *Match) error {
+		if m.End.UTC().Format(dayFormat) < day {
+			return nil
+		}
+		winners := make(map[string]bool)
+		for _, name := range m.Winners() {
+			winners[name] = true
+		}
+		for _, p := range m.Players {
+			get(p.Name).add(p.Score, winners[p.Name])
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return standings, nil
+}
+
+// WinRate is the record of a player for a single game type.
+type WinRate struct {
+	Name string `json:"name"`
+	Record
+}
+
+func (w *WinRate) Percent() string {
+	if w.Matches == 0 {
+		return "0%"
+	}
+	return fmt.Sprintf("%.0f%%", float64(w.Wins)/float64(w.Matches)*100)
+}
+
+// WinRates returns, for each game type, up to limit players with the best win
+// rate that have played at least minMatches of that game type.
+func (s *Store) WinRates(minMatches, limit int) map[string][]*WinRate {
+	rates := make(map[string][]*WinRate)
+	for _, p := range s.Players() {
+		for gt, r := range p.GameTypes {
+			if r.Matches < minMatches {
+				continue
+			}
+			rates[gt] = append(rates[gt], &WinRate{Name: p.Name, Record: *r})
+		}
+	}
+	for gt, list := range rates {
+		sort.Slice(list, func(i, j int) bool {
+			a := list[i].Wins * list[j].Matches
+			b := list[j].Wins * list[i].Matches
+			if a == b {
+				return list[i].Matches > list[j].Matches
+			}
+			return a > b
+		})
+		if limit > 0 && len(list) > limit {
+			rates[gt] = list[:limit]
+		}
+	}
+	return rates
+}
+
+// PlayerMatches returns up to limit of the most recent matches the named
+// player took part in, newest first.
+func (s *Store) PlayerMatches(name string, limit int) ([]*Match, error) {
+	if limit > 0 && limit <= recentPlayerMatches {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		return s.readRecent(s.idx.PlayerRecent[name], limit)
+	}
+	matches := make([]*Match, 0)
+	err := s.Each(func(m *Match) error {
+		for _, p := range m.Players {
+			if p.Name != name {
+				continue
+			}
+			matches = append(matches, m)
+			if limit > 0 && len(matches) > limit {
+				matches = matches[1:]
+			}
+			break
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(matches)-1; i < j; i, j = i+1, j-1 {
+		matches[i], matches[j] = matches[j], matches[i]
+	}
+	return matches, nil
+}
+
+type MapCount struct {
+	Map     string `json:"map"`
+	Matches int    `json:"matches"`
+}
+
+// FavouriteMaps returns up to limit of the maps the player has played the
+// most.
+func (p *Player) FavouriteMaps(limit int) []MapCount {
+	maps := make([]MapCount, 0, len(p.Maps))
+	for name, n := range p.Maps {
+		maps = append(maps, MapCount{Map: name, Matches: n})
+	}
+	sort.Slice(maps, func(i, j int) bool {
+		if maps[i].Matches == maps[j].Matches {
+			return maps[i].Map < maps[j].Map
+		}
+		return maps[i].Matches > maps[j].Matches
+	})
+	if limit > 0 && len(maps) > limit {
+		maps = maps[:limit]
+	}
+	return maps
+}
+
+// Score returns the final score of the named player in the match.
+func (m *Match) Score(name string) int {
+	for _, p := range m.Players {
+		if p.Name == name {
+			return p.Score
+		}
+	}
+	return 0
+}
+
+// Place returns the position of the named player in the final scoreboard,
+// starting from 1.
+func (m *Match) Place(name string) int {
+	for i, p := range m.Players {
+		if p.Name == name {
+			return i + 1
+		}
+	}
+	return 0
+}
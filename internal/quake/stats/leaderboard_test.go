@@ -0,0 +1,195 @@
+package stats
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// recordDays records a match on each of n days, where sarge scores the
+// number of the day and player scores 1.
+func recordDays(t *testing.T, s *Store, start time.Time, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		m := &Match{
+			Map:      "q3dm7",
+			GameType: "FreeForAll",
+			Start:    start.AddDate(0, 0, i),
+			End:      start.AddDate(0, 0, i).Add(10 * time.Minute),
+			Duration: metav1.Duration{Duration: 10 * time.Minute},
+			Players: []PlayerScore{
+				{Name: "sarge", Score: i},
+				{Name: "player", Score: 1},
+			},
+		}
+		if i%2 == 1 {
+			m.Map = "q3dm17"
+		}
+		if err := s.Record(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func matchIDs(matches []*Match) []int64 {
+	ids := make([]int64, 0, len(matches))
+	for _, m := range matches {
+		ids = append(ids, m.ID)
+	}
+	return ids
+}
+
+func TestLeaderboard(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2020, 8, 1, 12, 0, 0, 0, time.UTC)
+	recordDays(t, s, start, 12)
+
+	allTime := []*Standing{
+		{Name: "sarge", Frags: 66, Matches: 12, Wins: 11},
+		{Name: "player", Frags: 12, Matches: 12, Wins: 2},
+	}
+	cases := []struct {
+		name     string
+		since    time.Time
+		expected []*Standing
+	}{
+		{
+			name:     "all time",
+			expected: allTime,
+		},
+		{
+			// matches that ended earlier on the same day are counted
+			name:  "recent days",
+			since: time.Date(2020, 8, 10, 18, 0, 0, 0, time.UTC),
+			expected: []*Standing{
+				{Name: "sarge", Frags: 30, Matches: 3, Wins: 3},
+				{Name: "player", Frags: 3, Matches: 3, Wins: 0},
+			},
+		},
+		{
+			// older than the days kept in the index, so the log is read
+			name:  "older days",
+			since: time.Date(2020, 8, 2, 0, 0, 0, 0, time.UTC),
+			expected: []*Standing{
+				{Name: "sarge", Frags: 66, Matches: 11, Wins: 11},
+				{Name: "player", Frags: 11, Matches: 11, Wins: 1},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			result, err := s.TopFraggers(c.since, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(c.expected, result); diff != "" {
+				t.Errorf("stats: top fraggers differ: (-want +got)\n%s", diff)
+			}
+		})
+	}
+
+	top, err := s.TopFraggers(time.Time{}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(allTime[:1], top); diff != "" {
+		t.Errorf("stats: limited top fraggers differ: (-want +got)\n%s", diff)
+	}
+
+	expectedRates := map[string][]*WinRate{
+		"FreeForAll": {
+			{Name: "sarge", Record: Record{Matches: 12, Wins: 11}},
+			{Name: "player", Record: Record{Matches: 12, Wins: 2}},
+		},
+	}
+	if diff := cmp.Diff(expectedRates, s.WinRates(3, 10)); diff != "" {
+		t.Errorf("stats: win rates differ: (-want +got)\n%s", diff)
+	}
+	if rates := s.WinRates(13, 10); len(rates) != 0 {
+		t.Errorf("stats: expected no win rates under the minimum matches, received %v", rates)
+	}
+
+	recent, err := s.Matches(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]int64{12, 11, 10}, matchIDs(recent)); diff != "" {
+		t.Errorf("stats: recent matches differ: (-want +got)\n%s", diff)
+	}
+	playerRecent, err := s.PlayerMatches("player", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(recent[:2], playerRecent); diff != "" {
+		t.Errorf("stats: recent player matches differ: (-want +got)\n%s", diff)
+	}
+	all, err := s.PlayerMatches("player", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 12 || all[0].ID != 12 {
+		t.Errorf("stats: expected 12 player matches newest first, received %v", matchIDs(all))
+	}
+	if none, err := s.PlayerMatches("crash", 10); err != nil || len(none) != 0 {
+		t.Errorf("stats: expected no matches for unknown player, received %v, %v", none, err)
+	}
+
+	p, ok := s.Player("player")
+	if !ok {
+		t.Fatal("stats: expected player")
+	}
+	expectedMaps := []MapCount{{Map: "q3dm17", Matches: 6}, {Map: "q3dm7", Matches: 6}}
+	if diff := cmp.Diff(expectedMaps, p.FavouriteMaps(5)); diff != "" {
+		t.Errorf("stats: favourite maps differ: (-want +got)\n%s", diff)
+	}
+	if diff := cmp.Diff(expectedMaps[:1], p.FavouriteMaps(1)); diff != "" {
+		t.Errorf("stats: limited favourite maps differ: (-want +got)\n%s", diff)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// an index written by an older version is rebuilt from the log
+	fi, err := os.Stat(filepath.Join(dir, logFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := []byte(`{"offset":` + strconv.FormatInt(fi.Size(), 10) + `,"nextID":13,"players":{}}`)
+	if err := ioutil.WriteFile(filepath.Join(dir, indexFile), old, 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	result, err := s.TopFraggers(time.Time{}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(allTime, result); diff != "" {
+		t.Errorf("stats: rebuilt top fraggers differ: (-want +got)\n%s", diff)
+	}
+	recent, err = s.Matches(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]int64{12, 11, 10}, matchIDs(recent)); diff != "" {
+		t.Errorf("stats: rebuilt recent matches differ: (-want +got)\n%s", diff)
+	}
+}
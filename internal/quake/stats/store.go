@@ -0,0 +1,463 @@
+package stats
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	logFile   = "matches.log"
+	indexFile = "index.json"
+
+	// indexVersion is changed when the index gains totals, so that an older
+	// index is rebuilt from the log.
+	indexVersion = 2
+
+	// recentMatches and recentPlayerMatches are the number of matches, and
+	// of matches of each player, that can be read without scanning the log.
+	recentMatches       = 100
+	recentPlayerMatches = 20
+
+	// dailyStandings is the number of days of standings kept in the index
+	// for TopFraggers.
+	dailyStandings = 8
+)
+
+type PlayerScore struct {
+	Name  string `json:"name"`
+	Score int    `json:"score"`
+	Ping  int    `json:"ping"`
+
+	// Team is red or blue in team game types, when known.
+	Team string `json:"team,omitempty"`
+}
+
+type Match struct {
+	ID       int64           `json:"id"`
+	Map      string          `json:"map"`
+	GameType string          `json:"gameType"`
+	Start    time.Time       `json:"start"`
+	End      time.Time       `json:"end"`
+	Duration metav1.Duration `json:"duration"`
+
+	// RedScore and BlueScore are the team scores in team game types.
+	RedScore  int `json:"redScore,omitempty"`
+	BlueScore int `json:"blueScore,omitempty"`
+
+	// Players is the final scoreboard, sorted by score.
+	Players []PlayerScore `json:"players"`
+}
+
+// IsTeamGame returns whether the match was played in teams.
+func (m *Match) IsTeamGame() bool {
+	return m.GameType == "TeamDeathmatch" || m.GameType == "CaptureTheFlag"
+}
+
+// Winners returns the names of the players with the highest score, or the
+// players on the team with the highest score in team game types. There are
+// no winners when nobody scored or the teams are tied.
+func (m *Match) Winners() []string {
+	winners := make([]string, 0)
+	if m.IsTeamGame() {
+		team := ""
+		switch {
+		case m.RedScore > m.BlueScore:
+			team = "red"
+		case m.BlueScore > m.RedScore:
+			team = "blue"
+		default:
+			return winners
+		}
+		for _, p := range m.Players {
+			if p.Team == team {
+				winners = append(winners, p.Name)
+			}
+		}
+		return winners
+	}
+	top := 0
+	for _, p := range m.Players {
+		switch {
+		case p.Score > top:
+			top = p.Score
+			winners = []string{p.Name}
+		case p.Score == top && top > 0:
+			winners = append(winners, p.Name)
+		}
+	}
+	return winners
+}
+
+// Record is a count of matches played and won.
+type Record struct {
+	Matches int `json:"matches"`
+	Wins    int `json:"wins"`
+}
+
+type Player struct {
+	Name       string          `json:"name"`
+	Matches    int             `json:"matches"`
+	Wins       int             `json:"wins"`
+	Frags      int             `json:"frags"`
+	TimePlayed metav1.Duration `json:"timePlayed"`
+	FirstSeen  time.Time       `json:"firstSeen"`
+	LastSeen   time.Time       `json:"lastSeen"`
+
+	// Maps is the number of matches played on each map.
+	Maps map[string]int `json:"maps"`
+
+	// GameTypes is the record of the player for each game type.
+	GameTypes map[string]*Record `json:"gameTypes"`
+}
+
+func (p *Player) add(m *Match, score int, won bool) {
+	if p.Matches == 0 || m.Start.Before(p.FirstSeen) {
+		p.FirstSeen = m.Start
+	}
+	if m.End.After(p.LastSeen) {
+		p.LastSeen = m.End
+	}
+	p.Matches++
+	p.Frags += score
+	p.TimePlayed.Duration += m.Duration.Duration
+	p.Maps[m.Map]++
+	r, ok := p.GameTypes[m.GameType]
+	if !ok {
+		r = &Record{}
+		p.GameTypes[m.GameType] = r
+	}
+	r.Matches++
+	if won {
+		p.Wins++
+		r.Wins++
+	}
+}
+
+// index is the per-player totals for every match in the log up to offset,
+// with the position of recent matches in the log and the standings of the
+// last few days.
+type index struct {
+	Version int                `json:"version"`
+	Offset  int64              `json:"offset"`
+	NextID  int64              `json:"nextID"`
+	Players map[string]*Player `json:"players"`
+
+	// Recent and PlayerRecent are the positions of recent matches, oldest
+	// first.
+	Recent       []matchPos            `json:"recent"`
+	PlayerRecent map[string][]matchPos `json:"playerRecent"`
+
+	// Daily is the standing of each player for each day (UTC) that matches
+	// ended on, which is complete from DailySince onwards.
+	Daily      map[string]map[string]*Standing `json:"daily"`
+	DailySince string                          `json:"dailySince,omitempty"`
+}
+
+// matchPos is the position of a match in the log.
+type matchPos struct {
+	Offset int64 `json:"offset"`
+	Size   int   `json:"size"`
+}
+
+func newIndex() *index {
+	return &index{
+		Version:      indexVersion,
+		NextID:       1,
+		Players:      make(map[string]*Player),
+		PlayerRecent: make(map[string][]matchPos),
+		Daily:        make(map[string]map[string]*Standing),
+	}
+}
+
+// Store is an embedded match history. Matches are written to an append-only
+// log, and the per-player totals, recent matches and daily standings are
+// kept in an index alongside it that is rebuilt from the log when missing or
+// behind.
+type Store struct {
+	dir string
+
+	mu  sync.RWMutex
+	f   *os.File
+	idx *index
+}
+
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, logFile), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{dir: dir, f: f}
+	if err := s.load(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) load() error {
+	s.idx = newIndex()
+	if data, err := ioutil.ReadFile(filepath.Join(s.dir, indexFile)); err == nil {
+		idx := &index{}
+		if err := json.Unmarshal(data, idx); err == nil && idx.Version == indexVersion {
+			s.idx = idx
+		}
+	}
+	fi, err := s.f.Stat()
+	if err != nil {
+		return err
+	}
+	if s.idx.Offset > fi.Size() {
+		// the log is behind the index, so the index cannot be trusted
+		s.idx = newIndex()
+	}
+	if _, err := s.f.Seek(s.idx.Offset, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReader(s.f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// a partially written match is discarded
+			break
+		}
+		if err != nil {
+			return err
+		}
+		var m Match
+		if err := json.Unmarshal(line, &m); err != nil {
+			return errors.Wrapf(err, "cannot read match at offset %d", s.idx.Offset)
+		}
+		s.idx.add(&m, matchPos{Offset: s.idx.Offset, Size: len(line)})
+		s.idx.Offset += int64(len(line))
+	}
+	if err := s.f.Truncate(s.idx.Offset); err != nil {
+		return err
+	}
+	if _, err := s.f.Seek(s.idx.Offset, io.SeekStart); err != nil {
+		return err
+	}
+	return s.writeIndex()
+}
+
+func (idx *index) add(m *Match, pos matchPos) {
+	if m.ID >= idx.NextID {
+		idx.NextID = m.ID + 1
+	}
+	idx.Recent = appendPos(idx.Recent, pos, recentMatches)
+
+	day := m.End.UTC().Format(dayFormat)
+	daily, ok := idx.Daily[day]
+	if !ok {
+		daily = make(map[string]*Standing)
+		idx.Daily[day] = daily
+	}
+	oldest := m.End.UTC().AddDate(0, 0, -dailyStandings).Format(dayFormat)
+	for d := range idx.Daily {
+		if d < oldest {
+			delete(idx.Daily, d)
+			if oldest > idx.DailySince {
+				idx.DailySince = oldest
+			}
+		}
+	}
+
+	winners := make(map[string]bool)
+	for _, name := range m.Winners() {
+		winners[name] = true
+	}
+	for _, ps := range m.Players {
+		idx.PlayerRecent[ps.Name] = appendPos(idx.PlayerRecent[ps.Name], pos, recentPlayerMatches)
+		st, ok := daily[ps.Name]
+		if !ok {
+			st = &Standing{Name: ps.Name}
+			daily[ps.Name] = st
+		}
+		st.add(ps.Score, winners[ps.Name])
+
+		p, ok := idx.Players[ps.Name]
+		if !ok {
+			p = &Player{
+				Name:      ps.Name,
+				Maps:      make(map[string]int),
+				GameTypes: make(map[string]*Record),
+			}
+			idx.Players[ps.Name] = p
+		}
+		p.add(m, ps.Score, winners[ps.Name])
+	}
+}
+
+func (s *Store) writeIndex() error {
+	data, err := json.Marshal(s.idx)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.dir, indexFile)
+	if err := ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// Record appends a finished match to the log and updates the totals for the
+// players in it.
+func (s *Store) Record(m *Match) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m.ID = s.idx.NextID
+	sort.SliceStable(m.Players, func(i, j int) bool {
+		return m.Players[i].Score > m.Players[j].Score
+	})
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if _, err := s.f.Write(data); err != nil {
+		return err
+	}
+	if err := s.f.Sync(); err != nil {
+		return err
+	}
+	s.idx.add(m, matchPos{Offset: s.idx.Offset, Size: len(data)})
+	s.idx.Offset += int64(len(data))
+	return s.writeIndex()
+}
+
+// Each calls fn for every match in the log, oldest first.
+func (s *Store) Each(fn func(*Match) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	r := io.NewSectionReader(s.f, 0, s.idx.Offset)
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var m Match
+		if err := json.Unmarshal(line, &m); err != nil {
+			return err
+		}
+		if err := fn(&m); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+func appendPos(list []matchPos, pos matchPos, max int) []matchPos {
+	list = append(list, pos)
+	if len(list) > max {
+		list = append([]matchPos{}, list[len(list)-max:]...)
+	}
+	return list
+}
+
+// readRecent reads up to limit of the matches at the positions, newest first.
+// The caller must hold the read lock.
+func (s *Store) readRecent(list []matchPos, limit int) ([]*Match, error) {
+	matches := make([]*Match, 0)
+	for i := len(list) - 1; i >= 0 && len(matches) < limit; i-- {
+		data := make([]byte, list[i].Size)
+		if _, err := s.f.ReadAt(data, list[i].Offset); err != nil {
+			return nil, err
+		}
+		var m Match
+		if err := json.Unmarshal(data, &m); err != nil {
+			return nil, errors.Wrapf(err, "cannot read match at offset %d", list[i].Offset)
+		}
+		matches = append(matches, &m)
+	}
+	return matches, nil
+}
+
+// Matches returns up to limit of the most recent matches, newest first. All
+// matches are returned when limit is 0.
+func (s *Store) Matches(limit int) ([]*Match, error) {
+	if limit > 0 && limit <= recentMatches {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		return s.readRecent(s.idx.Recent, limit)
+	}
+	matches := make([]*Match, 0)
+	err := s.Each(func(m *Match) error {
+		matches = append(matches, m)
+		if limit > 0 && len(matches) > limit {
+			matches = matches[1:]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(matches)-1; i < j; i, j = i+1, j-1 {
+		matches[i], matches[j] = matches[j], matches[i]
+	}
+	return matches, nil
+}
+
+// Player returns a copy of the totals for the named player.
+func (s *Store) Player(name string) (*Player, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	p, ok := s.idx.Players[name]
+	if !ok {
+		return nil, false
+	}
+	return p.copy(), true
+}
+
+// Players returns a copy of the totals for all players.
+func (s *Store) Players() []*Player {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	players := make([]*Player, 0, len(s.idx.Players))
+	for _, p := range s.idx.Players {
+		players = append(players, p.copy())
+	}
+	sort.Slice(players, func(i, j int) bool {
+		return players[i].Name < players[j].Name
+	})
+	return players
+}
+
+func (p *Player) copy() *Player {
+	c := *p
+	c.Maps = make(map[string]int)
+	for k, v := range p.Maps {
+		c.Maps[k] = v
+	}
+	c.GameTypes = make(map[string]*Record)
+	for k, v := range p.GameTypes {
+		r := *v
+		c.GameTypes[k] = &r
+	}
+	return &c
+}
+
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.f.Close()
+}
@@ -0,0 +1,157 @@
+package stats
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2020, 8, 1, 12, 0, 0, 0, time.UTC)
+	matches := []*Match{
+		{
+			Map:      "q3dm7",
+			GameType: "FreeForAll",
+			Start:    start,
+			End:      start.Add(10 * time.Minute),
+			Duration: metav1.Duration{Duration: 10 * time.Minute},
+			Players: []PlayerScore{
+				{Name: "sarge", Score: 5},
+				{Name: "player", Score: 20},
+			},
+		},
+		{
+			Map:      "q3dm17",
+			GameType: "FreeForAll",
+			Start:    start.Add(10 * time.Minute),
+			End:      start.Add(25 * time.Minute),
+			Duration: metav1.Duration{Duration: 15 * time.Minute},
+			Players: []PlayerScore{
+				{Name: "player", Score: 3},
+				{Name: "sarge", Score: 12},
+			},
+		},
+	}
+	for _, m := range matches {
+		if err := s.Record(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the index must be rebuilt from the log when it is missing, ignoring a
+	// partially written match
+	if err := os.Remove(filepath.Join(dir, indexFile)); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(filepath.Join(dir, logFile), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(`{"id":3,"map":"q3`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	s, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	result, err := s.Matches(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(matches[1:], result[:1]); diff != "" {
+		t.Errorf("stats: most recent match differs: (-want +got)\n%s", diff)
+	}
+	if len(result) != 2 || result[1].ID != 1 {
+		t.Errorf("stats: expected 2 matches newest first, received %d", len(result))
+	}
+
+	p, ok := s.Player("player")
+	if !ok {
+		t.Fatal("stats: expected player")
+	}
+	expected := &Player{
+		Name:       "player",
+		Matches:    2,
+		Wins:       1,
+		Frags:      23,
+		TimePlayed: metav1.Duration{Duration: 25 * time.Minute},
+		FirstSeen:  start,
+		LastSeen:   start.Add(25 * time.Minute),
+		Maps:       map[string]int{"q3dm7": 1, "q3dm17": 1},
+		GameTypes:  map[string]*Record{"FreeForAll": {Matches: 2, Wins: 1}},
+	}
+	if diff := cmp.Diff(expected, p); diff != "" {
+		t.Errorf("stats: player differs: (-want +got)\n%s", diff)
+	}
+}
+
+func TestMatchWinners(t *testing.T) {
+	cases := []struct {
+		name     string
+		match    Match
+		expected []string
+	}{
+		{
+			name: "free for all",
+			match: Match{GameType: "FreeForAll", Players: []PlayerScore{
+				{Name: "sarge", Score: 10},
+				{Name: "player", Score: 10},
+				{Name: "crash", Score: 3},
+			}},
+			expected: []string{"sarge", "player"},
+		},
+		{
+			name: "nobody scored",
+			match: Match{GameType: "FreeForAll", Players: []PlayerScore{
+				{Name: "sarge"},
+			}},
+			expected: []string{},
+		},
+		{
+			name: "winning team",
+			match: Match{GameType: "CaptureTheFlag", RedScore: 2, BlueScore: 1, Players: []PlayerScore{
+				{Name: "sarge", Score: 30, Team: "blue"},
+				{Name: "player", Score: 5, Team: "red"},
+				{Name: "crash", Score: 1, Team: "red"},
+			}},
+			expected: []string{"player", "crash"},
+		},
+		{
+			name: "tied teams",
+			match: Match{GameType: "TeamDeathmatch", RedScore: 20, BlueScore: 20, Players: []PlayerScore{
+				{Name: "sarge", Score: 12, Team: "blue"},
+				{Name: "player", Score: 8, Team: "red"},
+			}},
+			expected: []string{},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if diff := cmp.Diff(c.expected, c.match.Winners()); diff != "" {
+				t.Errorf("stats: winners differ: (-want +got)\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+// Package log configures the structured logger shared by every command.
+// Packages log with the global zap logger, named after their subsystem, e.g.
+// zap.L().Named("proxy").
+package log
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Formats are the output formats of New.
+var Formats = []string{"text", "json"}
+
+// New returns a logger writing records in the format, either text or json.
+// Info and above are written by default, and debug records with a verbosity
+// of 1 or more.
+func New(w io.Writer, format string, verbosity int) (*zap.Logger, error) {
+	cfg := zap.NewProductionEncoderConfig()
+	cfg.EncodeTime = zapcore.ISO8601TimeEncoder
+	cfg.EncodeDuration = zapcore.StringDurationEncoder
+
+	var enc zapcore.Encoder
+	switch format {
+	case "text":
+		cfg.EncodeLevel = zapcore.CapitalLevelEncoder
+		enc = zapcore.NewConsoleEncoder(cfg)
+	case "json":
+		enc = zapcore.NewJSONEncoder(cfg)
+	default:
+		return nil, errors.Errorf("invalid log format %q, must be one of %s", format, strings.Join(Formats, ", "))
+	}
+	level := zapcore.InfoLevel
+	if verbosity > 0 {
+		level = zapcore.DebugLevel
+	}
+	core := zapcore.NewCore(enc, zapcore.Lock(zapcore.AddSync(w)), level)
+	return zap.New(core, zap.ErrorOutput(zapcore.Lock(zapcore.AddSync(w)))), nil
+}
+
+// Requests logs each request handled by echo, except those skipped.
+func Requests(log *zap.Logger, skipper middleware.Skipper) echo.MiddlewareFunc {
+	if skipper == nil {
+		skipper = middleware.DefaultSkipper
+	}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if skipper(c) {
+				return next(c)
+			}
+			start := time.Now()
+			err := next(c)
+			if err != nil {
+				// sets the status, which is otherwise only written after
+				// the middleware returns
+				c.Error(err)
+			}
+			req, res := c.Request(), c.Response()
+			fields := []zap.Field{
+				zap.String("method", req.Method),
+				zap.String("uri", req.RequestURI),
+				zap.Int("status", res.Status),
+				zap.Duration("latency", time.Since(start)),
+				zap.String("remote_ip", c.RealIP()),
+				zap.Int64("bytes_out", res.Size),
+			}
+			if err != nil {
+				fields = append(fields, zap.Error(err))
+			}
+			if res.Status >= http.StatusInternalServerError {
+				log.Error("request", fields...)
+			} else {
+				log.Info("request", fields...)
+			}
+			return nil
+		}
+	}
+}
+
+// Writer writes each line as a log record, such as the output of a process.
+// A line is logged at the level of the first matching prefix, or the default
+// level.
+type Writer struct {
+	Log      *zap.Logger
+	Level    zapcore.Level
+	Prefixes map[string]zapcore.Level
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.log(string(bytes.TrimRight(w.buf[:i], "\r")))
+		w.buf = w.buf[i+1:]
+	}
+	if len(w.buf) == 0 {
+		w.buf = nil
+	}
+	return len(p), nil
+}
+
+func (w *Writer) log(line string) {
+	if line == "" {
+		return
+	}
+	level := w.Level
+	for prefix, l := range w.Prefixes {
+		if strings.HasPrefix(line, prefix) {
+			level = l
+			break
+		}
+	}
+	if ce := w.Log.Check(level, line); ce != nil {
+		ce.Write()
+	}
+}
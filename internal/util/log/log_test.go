@@ -0,0 +1,105 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+	log, err := New(&buf, "json", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Named("proxy").Debug("hidden")
+	log.Named("proxy").Info("opened session", zap.Uint64("session", 1))
+
+	var record map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("log: expected a single JSON record, received %q: %v", buf.String(), err)
+	}
+	if record["logger"] != "proxy" || record["msg"] != "opened session" || record["session"] != 1.0 {
+		t.Errorf("log: unexpected record %v", record)
+	}
+
+	buf.Reset()
+	log, err = New(&buf, "text", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log.Debug("shown")
+	if !strings.Contains(buf.String(), "DEBUG\tshown") {
+		t.Errorf("log: expected debug record with -v, received %q", buf.String())
+	}
+
+	if _, err := New(&buf, "xml", 0); err == nil {
+		t.Error("log: expected invalid format to be refused")
+	}
+}
+
+func TestWriter(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	w := &Writer{
+		Log:      zap.New(core),
+		Level:    zapcore.InfoLevel,
+		Prefixes: map[string]zapcore.Level{"WARNING": zapcore.WarnLevel},
+	}
+	for _, s := range []string{"Hunk_Clear: reset the hunk ok\r\n", "WARNING: could not", " find q3dm0\n\nparti", "al"} {
+		if _, err := w.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected := []struct {
+		level zapcore.Level
+		msg   string
+	}{
+		{zapcore.InfoLevel, "Hunk_Clear: reset the hunk ok"},
+		{zapcore.WarnLevel, "WARNING: could not find q3dm0"},
+	}
+	entries := logs.All()
+	if len(entries) != len(expected) {
+		t.Fatalf("log: expected %d records, received %+v", len(expected), entries)
+	}
+	for i, e := range expected {
+		if entries[i].Level != e.level || entries[i].Message != e.msg {
+			t.Errorf("log: expected %v %q, received %v %q", e.level, e.msg, entries[i].Level, entries[i].Message)
+		}
+	}
+}
+
+func TestRequests(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	e := echo.New()
+	e.Use(Requests(zap.New(core), func(c echo.Context) bool {
+		return c.Path() == "/healthz"
+	}))
+	e.GET("/healthz", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	})
+	e.GET("/info", func(c echo.Context) error {
+		return errors.New("timeout")
+	})
+	for _, path := range []string{"/healthz", "/info"} {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	entries := logs.All()
+	if len(entries) != 1 {
+		t.Fatalf("log: expected 1 record, received %+v", entries)
+	}
+	fields := entries[0].ContextMap()
+	if entries[0].Level != zapcore.ErrorLevel || fields["uri"] != "/info" || fields["status"] != int64(http.StatusInternalServerError) {
+		t.Errorf("log: unexpected record %v %v", entries[0].Level, fields)
+	}
+}
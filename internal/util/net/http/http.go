@@ -1,10 +1,12 @@
 package http
 
 import (
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"time"
 
+	"github.com/labstack/echo/v4"
 	"github.com/pkg/errors"
 )
 
@@ -43,3 +45,22 @@ func GetUntil(url string, stop <-chan struct{}) error {
 		}
 	}
 }
+
+// JSONErrors returns all errors from the handler as JSON:
+//
+//	{"error": "player not found"}
+func JSONErrors(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		err := next(c)
+		if err == nil {
+			return nil
+		}
+		code := http.StatusInternalServerError
+		msg := err.Error()
+		if he, ok := err.(*echo.HTTPError); ok {
+			code = he.Code
+			msg = fmt.Sprint(he.Message)
+		}
+		return c.JSON(code, map[string]string{"error": msg})
+	}
+}
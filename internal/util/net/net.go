@@ -2,6 +2,8 @@ package net
 
 import (
 	"net"
+	"strconv"
+	"strings"
 
 	"github.com/pkg/errors"
 )
@@ -23,3 +25,67 @@ func DetectHostIPv4() (string, error) {
 	}
 	return "", errors.New("cannot detect host IPv4 address")
 }
+
+// ParseCIDR parses an address range in CIDR notation. A single address is
+// treated as a range containing only that address.
+func ParseCIDR(s string) (*net.IPNet, error) {
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, errors.Errorf("invalid address: %q", s)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, network, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, errors.Errorf("invalid address range: %q", s)
+	}
+	return network, nil
+}
+
+// ParseCIDRs parses a list of address ranges with ParseCIDR.
+func ParseCIDRs(ss []string) ([]*net.IPNet, error) {
+	result := make([]*net.IPNet, 0, len(ss))
+	for _, s := range ss {
+		n, err := ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, n)
+	}
+	return result, nil
+}
+
+// ContainsIP reports whether any of the address ranges contain ip.
+func ContainsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParsePortRange parses a range of ports written as <min>-<max>. An empty
+// string is the zero range.
+func ParsePortRange(s string) (min, max uint16, err error) {
+	if s == "" {
+		return 0, 0, nil
+	}
+	parts := strings.SplitN(s, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, errors.Errorf("invalid port range: %q", s)
+	}
+	lo, err := strconv.ParseUint(parts[0], 10, 16)
+	if err != nil {
+		return 0, 0, errors.Errorf("invalid port range: %q", s)
+	}
+	hi, err := strconv.ParseUint(parts[1], 10, 16)
+	if err != nil || lo == 0 || hi < lo {
+		return 0, 0, errors.Errorf("invalid port range: %q", s)
+	}
+	return uint16(lo), uint16(hi), nil
+}
@@ -0,0 +1,82 @@
+package net
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestReadProxyHeader(t *testing.T) {
+	v2 := append([]byte{}, proxyProtocolV2Signature...)
+	v2 = append(v2, 0x21, 0x11, 0, 12)
+	v2 = append(v2, 203, 0, 113, 7, 10, 0, 0, 1, 0, 0, 0, 0)
+	binary.BigEndian.PutUint16(v2[len(v2)-4:], 56324)
+	binary.BigEndian.PutUint16(v2[len(v2)-2:], 443)
+
+	cases := []struct {
+		name     string
+		header   string
+		expected string
+	}{
+		{"v1 tcp4", "PROXY TCP4 203.0.113.7 10.0.0.1 56324 443\r\n", "203.0.113.7:56324"},
+		{"v1 tcp6", "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n", "[2001:db8::1]:56324"},
+		{"v1 unknown", "PROXY UNKNOWN\r\n", ""},
+		{"v2 tcp4", string(v2), "203.0.113.7:56324"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r := bufio.NewReader(strings.NewReader(c.header + "GET / HTTP/1.1\r\n"))
+			addr, err := readProxyHeader(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got string
+			if addr != nil {
+				got = addr.String()
+			}
+			if got != c.expected {
+				t.Errorf("expected %q, received %q", c.expected, got)
+			}
+			rest, _ := r.ReadString('\n')
+			if rest != "GET / HTTP/1.1\r\n" {
+				t.Errorf("expected the header to be consumed, received %q", rest)
+			}
+		})
+	}
+
+	if _, err := readProxyHeader(bufio.NewReader(strings.NewReader("GET / HTTP/1.1\r\n"))); err == nil {
+		t.Error("expected missing header error")
+	}
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pl := &ProxyProtocolListener{Listener: l, Trusted: func(ip net.IP) bool { return ip.IsLoopback() }}
+	defer pl.Close()
+
+	go func() {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Write([]byte("PROXY TCP4 203.0.113.7 10.0.0.1 56324 443\r\nhello\n"))
+	}()
+	conn, err := pl.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if addr := conn.RemoteAddr().String(); addr != "203.0.113.7:56324" {
+		t.Errorf("expected remote address from header, received %s", addr)
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "hello\n" {
+		t.Errorf("expected hello, received %q %v", line, err)
+	}
+}
@@ -0,0 +1,107 @@
+package net
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Certificate is a TLS certificate and key loaded from files, which can be
+// reloaded when they change, e.g. when renewed by cert-manager.
+type Certificate struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// LoadCertificate loads the PEM encoded certificate and key.
+func LoadCertificate(certFile, keyFile string) (*Certificate, error) {
+	c := &Certificate{certFile: certFile, keyFile: keyFile}
+	if _, err := c.refresh(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// GetCertificate returns the current certificate, for use as
+// tls.Config.GetCertificate.
+func (c *Certificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, nil
+}
+
+// refresh reloads the files when either has been modified since they were
+// last read, returning whether they were reloaded. The caller must hold the
+// write lock, except when loading.
+func (c *Certificate) refresh() (bool, error) {
+	var modTime time.Time
+	for _, name := range []string{c.certFile, c.keyFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return false, err
+		}
+		if fi.ModTime().After(modTime) {
+			modTime = fi.ModTime()
+		}
+	}
+	if modTime.Equal(c.modTime) {
+		return false, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return false, errors.Wrapf(err, "cannot load %s", c.certFile)
+	}
+	c.cert, c.modTime = &cert, modTime
+	return true, nil
+}
+
+// Watch reloads the certificate when the files change, until the context is
+// done. The previous certificate is kept when the files are invalid, such as
+// when the certificate has been written but the key has not.
+func (c *Certificate) Watch(ctx context.Context, interval time.Duration) {
+	log := zap.L().Named("tls")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.mu.Lock()
+			reloaded, err := c.refresh()
+			c.mu.Unlock()
+			if err != nil {
+				log.Error("cannot reload certificate", zap.String("file", c.certFile), zap.Error(err))
+				continue
+			}
+			if reloaded {
+				log.Info("reloaded certificate", zap.String("file", c.certFile))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// LoadCertPool loads the PEM encoded CA certificates in the file.
+func LoadCertPool(name string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.Errorf("no certificates found in %s", name)
+	}
+	return pool, nil
+}
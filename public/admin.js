@@ -0,0 +1,171 @@
+(function() {
+  var token = sessionStorage.getItem('adminToken') || '';
+  var socket;
+
+  function $(id) {
+    return document.getElementById(id);
+  }
+
+  function showError(err) {
+    $('error').textContent = err ? err.message || String(err) : '';
+  }
+
+  // api calls the admin API, using the token when one is set. Without a token
+  // the browser prompts for basic auth credentials.
+  function api(method, path, body) {
+    var opts = {method: method, headers: {}, credentials: 'same-origin'};
+    if (token) {
+      opts.headers['Authorization'] = 'Bearer ' + token;
+    }
+    if (body !== undefined) {
+      opts.headers['Content-Type'] = 'application/json';
+      opts.body = JSON.stringify(body);
+    }
+    return fetch('/admin/api' + path, opts).then(function(resp) {
+      if (resp.status === 204) {
+        return null;
+      }
+      return resp.json().then(function(data) {
+        if (!resp.ok) {
+          throw new Error(data.error || resp.statusText);
+        }
+        return data;
+      });
+    });
+  }
+
+  function button(label, onclick) {
+    var b = document.createElement('button');
+    b.type = 'button';
+    b.textContent = label;
+    b.onclick = onclick;
+    return b;
+  }
+
+  function refreshPlayers() {
+    return api('GET', '/players').then(function(status) {
+      showError();
+      $('map').textContent = status.map;
+      var tbody = $('players');
+      tbody.innerHTML = '';
+      status.clients.forEach(function(client) {
+        var tr = document.createElement('tr');
+        [client.num, client.name, client.score, client.ping < 0 ? '-' : client.ping, client.address].forEach(function(v, i) {
+          var td = document.createElement('td');
+          td.textContent = v;
+          if (i === 2 || i === 3) {
+            td.className = 'number';
+          }
+          tr.appendChild(td);
+        });
+        var actions = document.createElement('td');
+        actions.appendChild(button('Kick', function() {
+          if (confirm('Kick ' + client.name + '?')) {
+            api('POST', '/players/' + client.num + '/kick').then(refreshPlayers, showError);
+          }
+        }));
+        if (client.address !== 'bot') {
+          actions.appendChild(button('Ban', function() {
+            if (confirm('Ban ' + client.name + '?')) {
+              api('POST', '/players/' + client.num + '/ban').then(refreshPlayers, showError);
+            }
+          }));
+        }
+        tr.appendChild(actions);
+        tbody.appendChild(tr);
+      });
+    }, showError);
+  }
+
+  function loadMaps() {
+    return api('GET', '/maps').then(function(maps) {
+      var select = $('maps');
+      select.innerHTML = '';
+      maps.forEach(function(m) {
+        var option = document.createElement('option');
+        option.value = m.name;
+        option.textContent = m.name + ' (' + m.file + ')';
+        select.appendChild(option);
+      });
+    }, showError);
+  }
+
+  function print(line) {
+    var el = $('console');
+    var atBottom = el.scrollTop + el.clientHeight >= el.scrollHeight - 4;
+    el.appendChild(document.createTextNode(line + '\n'));
+    if (atBottom) {
+      el.scrollTop = el.scrollHeight;
+    }
+  }
+
+  function connectConsole() {
+    if (socket) {
+      socket.onclose = null;
+      socket.close();
+    }
+    $('console').textContent = '';
+    var url = (location.protocol === 'https:' ? 'wss://' : 'ws://') + location.host + '/admin/api/console';
+    if (token) {
+      url += '?token=' + encodeURIComponent(token);
+    }
+    socket = new WebSocket(url);
+    socket.onmessage = function(e) {
+      print(e.data);
+    };
+    socket.onclose = function() {
+      print('-- disconnected, reconnecting --');
+      setTimeout(connectConsole, 5000);
+    };
+  }
+
+  function start() {
+    refreshPlayers();
+    loadMaps();
+    connectConsole();
+  }
+
+  $('token').value = token;
+  $('login').onsubmit = function(e) {
+    e.preventDefault();
+    token = $('token').value;
+    sessionStorage.setItem('adminToken', token);
+    start();
+  };
+
+  $('map-form').onsubmit = function(e) {
+    e.preventDefault();
+    var name = $('maps').value;
+    var req = e.submitter && e.submitter.value === 'nextmap' ? api('PUT', '/nextmap', {name: name}) : api('POST', '/map', {name: name});
+    req.then(function() { showError(); }, showError);
+  };
+  $('restart').onclick = function() {
+    api('POST', '/map/restart').then(function() { showError(); }, showError);
+  };
+
+  $('cvar-get').onclick = function() {
+    api('GET', '/cvars/' + encodeURIComponent($('cvar-name').value)).then(function(cvar) {
+      showError();
+      $('cvar-value').value = cvar.value;
+    }, showError);
+  };
+  $('cvar-form').onsubmit = function(e) {
+    e.preventDefault();
+    var name = $('cvar-name').value;
+    api('PUT', '/cvars/' + encodeURIComponent(name), {value: $('cvar-value').value}).then(function() { showError(); }, showError);
+  };
+
+  $('console-form').onsubmit = function(e) {
+    e.preventDefault();
+    var cmd = $('command').value;
+    if (!cmd || !socket || socket.readyState !== WebSocket.OPEN) {
+      return;
+    }
+    print('] ' + cmd);
+    socket.send(cmd);
+    $('command').value = '';
+  };
+
+  start();
+  setInterval(refreshPlayers, 5000);
+})();
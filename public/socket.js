@@ -0,0 +1,365 @@
+// socket.js replaces window.WebSocket, which ioquake3.js uses to reach the
+// proxy, with a socket that:
+//
+// - sends game packets over an unordered WebRTC data channel without
+//   retransmits, so that lost packets are dropped rather than delaying the
+//   packets behind them as they would over TCP. It falls back to a websocket
+//   when the proxy does not offer WebRTC, or the data channel cannot be
+//   opened.
+// - reconnects with the resume token given by the proxy when the connection
+//   drops, e.g. on a Wi-Fi handoff, so that the dedicated server sees no
+//   change.
+(function() {
+  var NativeWebSocket = window.WebSocket;
+  if (!NativeWebSocket) {
+    return;
+  }
+
+  var CONNECTING = 0;
+  var OPEN = 1;
+  var CLOSING = 2;
+  var CLOSED = 3;
+
+  // CLOSE_ABNORMAL is the close code of a connection that dropped.
+  var CLOSE_ABNORMAL = 1006;
+
+  // OPEN_TIMEOUT is how long to wait for the data channel before falling back
+  // to a websocket.
+  var OPEN_TIMEOUT = 5000;
+
+  // GATHER_TIMEOUT bounds the time spent gathering candidates for the offer.
+  var GATHER_TIMEOUT = 2000;
+
+  // RESUME_TIMEOUT is how long to keep trying to resume a session, which is
+  // less than the grace period of the proxy and cl_timeout.
+  var RESUME_TIMEOUT = 10000;
+  var RESUME_INTERVAL = 1000;
+
+  // RESUME_PREFIX starts the packet with the resume token of the session.
+  var RESUME_PREFIX = '\xff\xff\xff\xffresume ';
+
+  // rtcDisabled is set once WebRTC has failed, including when the proxy does
+  // not offer it, so that reconnecting does not wait for it to fail again.
+  var rtcDisabled = !window.RTCPeerConnection || !window.fetch;
+
+  function defaultPort(protocol) {
+    return protocol === 'wss:' || protocol === 'https:' ? '443' : '80';
+  }
+
+  // sameServer reports whether the url is the proxy that served the page,
+  // which is the only server wrapped by this socket.
+  function sameServer(url) {
+    var u;
+    try {
+      u = new URL(url);
+    } catch (e) {
+      return false;
+    }
+    return u.hostname === location.hostname &&
+      (u.port || defaultPort(u.protocol)) === (location.port || defaultPort(location.protocol));
+  }
+
+  // resumeToken returns the token of a resume packet, or null for any other
+  // packet.
+  function resumeToken(data) {
+    var bytes = new Uint8Array(data);
+    if (bytes.length <= RESUME_PREFIX.length) {
+      return null;
+    }
+    for (var i = 0; i < RESUME_PREFIX.length; i++) {
+      if (bytes[i] !== RESUME_PREFIX.charCodeAt(i)) {
+        return null;
+      }
+    }
+    return String.fromCharCode.apply(null, bytes.subarray(RESUME_PREFIX.length));
+  }
+
+  // gathered resolves once the offer includes all of the local candidates,
+  // since the proxy does not accept trickled candidates.
+  function gathered(pc) {
+    return new Promise(function(resolve) {
+      if (pc.iceGatheringState === 'complete') {
+        resolve();
+        return;
+      }
+      pc.addEventListener('icegatheringstatechange', function() {
+        if (pc.iceGatheringState === 'complete') {
+          resolve();
+        }
+      });
+      setTimeout(resolve, GATHER_TIMEOUT);
+    });
+  }
+
+  // Transports call the handlers open(), message(data) and close(code,
+  // reason), and fail(reason) when a data channel cannot be opened.
+
+  function openWebSocket(url, protocols, h) {
+    var ws = new NativeWebSocket(url, protocols);
+    ws.binaryType = 'arraybuffer';
+    ws.onopen = function() {
+      h.open();
+    };
+    ws.onmessage = function(e) {
+      h.message(e.data);
+    };
+    ws.onclose = function(e) {
+      h.close(e.code, e.reason);
+    };
+    return {
+      send: function(data) {
+        ws.send(data);
+      },
+      close: function(code, reason) {
+        ws.onclose = null;
+        ws.close(code, reason);
+      },
+    };
+  }
+
+  // signallingURL returns the WebRTC signalling path for the websocket url,
+  // which keeps the backend picked by the path or query of the url.
+  function signallingURL(url, token) {
+    var u = new URL(url);
+    var params = new URLSearchParams(u.search);
+    params.set('resume', token);
+    return '/rtc' + u.pathname.replace(/\/$/, '') + '?' + params.toString();
+  }
+
+  function openDataChannel(signalling, h) {
+    var pc = new RTCPeerConnection();
+    var dc = pc.createDataChannel('quake', {ordered: false, maxRetransmits: 0});
+    dc.binaryType = 'arraybuffer';
+
+    var opened = false;
+    var done = false;
+    // the proxy sends the close code as text, since data channels do not
+    // have one
+    var closeMessage = null;
+
+    function finish() {
+      done = true;
+      clearTimeout(timer);
+      dc.onclose = null;
+      pc.oniceconnectionstatechange = null;
+      pc.close();
+    }
+    function fail(reason) {
+      if (done) {
+        return;
+      }
+      finish();
+      if (opened) {
+        var m = closeMessage || {code: CLOSE_ABNORMAL, reason: reason};
+        h.close(m.code, m.reason);
+      } else {
+        h.fail(reason);
+      }
+    }
+
+    var timer = setTimeout(function() {
+      fail('data channel timed out');
+    }, OPEN_TIMEOUT);
+
+    dc.onopen = function() {
+      if (done) {
+        return;
+      }
+      clearTimeout(timer);
+      opened = true;
+      h.open();
+    };
+    dc.onmessage = function(e) {
+      if (typeof e.data === 'string') {
+        try {
+          closeMessage = JSON.parse(e.data);
+        } catch (err) {}
+        return;
+      }
+      h.message(e.data);
+    };
+    dc.onclose = function() {
+      fail('data channel closed');
+    };
+    pc.oniceconnectionstatechange = function() {
+      if (pc.iceConnectionState === 'failed') {
+        fail('connection failed');
+      }
+    };
+
+    pc.createOffer().then(function(offer) {
+      return pc.setLocalDescription(offer);
+    }).then(function() {
+      return gathered(pc);
+    }).then(function() {
+      return fetch(signalling, {
+        method: 'POST',
+        headers: {'Content-Type': 'application/json'},
+        body: JSON.stringify(pc.localDescription),
+      });
+    }).then(function(resp) {
+      if (!resp.ok) {
+        throw new Error('signalling failed: ' + resp.status);
+      }
+      return resp.json();
+    }).then(function(answer) {
+      return pc.setRemoteDescription(answer);
+    }).catch(function(err) {
+      fail(err.message || String(err));
+    });
+
+    return {
+      send: function(data) {
+        dc.send(data);
+      },
+      close: finish,
+    };
+  }
+
+  // QuakeSocket has the interface of a WebSocket used by ioquake3.js.
+  function QuakeSocket(url, protocols) {
+    this.url = url;
+    this.protocol = '';
+    this.binaryType = 'blob';
+    this.readyState = CONNECTING;
+    this.bufferedAmount = 0;
+    this.onopen = null;
+    this.onmessage = null;
+    this.onerror = null;
+    this.onclose = null;
+
+    this._protocols = protocols;
+    this._transport = null;
+    this._connected = false;
+    this._token = null;
+    this._resumeStarted = 0;
+    this._connect(!rtcDisabled);
+  }
+
+  QuakeSocket.CONNECTING = QuakeSocket.prototype.CONNECTING = CONNECTING;
+  QuakeSocket.OPEN = QuakeSocket.prototype.OPEN = OPEN;
+  QuakeSocket.CLOSING = QuakeSocket.prototype.CLOSING = CLOSING;
+  QuakeSocket.CLOSED = QuakeSocket.prototype.CLOSED = CLOSED;
+
+  QuakeSocket.prototype._emit = function(name, event) {
+    if (typeof this[name] === 'function') {
+      this[name](event);
+    }
+  };
+
+  // _connect opens a transport, resuming the session when there is a token.
+  QuakeSocket.prototype._connect = function(rtc) {
+    var self = this;
+    var query = '?resume=' + encodeURIComponent(this._token || '');
+    var h = {
+      open: function() {
+        if (self._transport !== transport) {
+          return;
+        }
+        self._connected = true;
+        self._resumeStarted = 0;
+        if (self.readyState === CONNECTING) {
+          self.readyState = OPEN;
+          self._emit('onopen', {type: 'open'});
+        }
+      },
+      message: function(data) {
+        if (self._transport !== transport) {
+          return;
+        }
+        var token = resumeToken(data);
+        if (token !== null) {
+          self._token = token;
+          return;
+        }
+        self._emit('onmessage', {type: 'message', data: data});
+      },
+      close: function(code, reason) {
+        if (self._transport !== transport) {
+          return;
+        }
+        self._lost(code, reason);
+      },
+      fail: function(reason) {
+        if (self._transport !== transport) {
+          return;
+        }
+        console.log('webrtc: ' + reason + ', using a websocket');
+        rtcDisabled = true;
+        self._connect(false);
+      },
+    };
+    this._connected = false;
+    var transport = null;
+    if (rtc) {
+      try {
+        transport = openDataChannel(signallingURL(this.url, this._token || ''), h);
+      } catch (e) {
+        rtcDisabled = true;
+      }
+    }
+    if (!transport) {
+      transport = openWebSocket(this.url.replace(/\/?$/, '/') + query, this._protocols, h);
+    }
+    this._transport = transport;
+  };
+
+  // _lost handles the transport closing, which is resumed when it dropped.
+  QuakeSocket.prototype._lost = function(code, reason) {
+    if (this.readyState === OPEN && this._token && code === CLOSE_ABNORMAL) {
+      var now = Date.now();
+      if (!this._resumeStarted) {
+        this._resumeStarted = now;
+      }
+      if (now - this._resumeStarted < RESUME_TIMEOUT) {
+        var self = this;
+        this._transport = null;
+        setTimeout(function() {
+          if (self.readyState === OPEN) {
+            self._connect(!rtcDisabled);
+          }
+        }, this._connected ? 0 : RESUME_INTERVAL);
+        return;
+      }
+    }
+    this._closed(code, reason);
+  };
+
+  QuakeSocket.prototype._closed = function(code, reason) {
+    if (this.readyState === CLOSED) {
+      return;
+    }
+    this._transport = null;
+    this.readyState = CLOSED;
+    this._emit('onclose', {type: 'close', code: code, reason: reason || '', wasClean: code !== CLOSE_ABNORMAL});
+  };
+
+  QuakeSocket.prototype.send = function(data) {
+    if (this.readyState === CONNECTING) {
+      throw new DOMException('still in CONNECTING state', 'InvalidStateError');
+    }
+    if (this.readyState !== OPEN || !this._connected) {
+      // lost while resuming, as it would have been in the network
+      return;
+    }
+    this._transport.send(data);
+  };
+
+  QuakeSocket.prototype.close = function(code, reason) {
+    if (this._transport) {
+      this._transport.close(code, reason);
+    }
+    this._closed(code || 1000, reason);
+  };
+
+  window.WebSocket = function(url, protocols) {
+    if (!sameServer(url)) {
+      return new NativeWebSocket(url, protocols);
+    }
+    return new QuakeSocket(url, protocols);
+  };
+  window.WebSocket.CONNECTING = CONNECTING;
+  window.WebSocket.OPEN = OPEN;
+  window.WebSocket.CLOSING = CLOSING;
+  window.WebSocket.CLOSED = CLOSED;
+})();